@@ -2,8 +2,10 @@ package alarmrun
 
 import (
 	"bytes"
+	"errors"
 	"fmt"
 	"os/exec"
+	"sync"
 	"time"
 
 	"github.com/faiface/beep"
@@ -13,79 +15,304 @@ import (
 	upstreamspotify "github.com/jchorl/spotify"
 	"github.com/valyala/fasthttp"
 
+	"github.com/jchorl/gowaker/briefing"
 	"github.com/jchorl/gowaker/config"
+	"github.com/jchorl/gowaker/events"
+	"github.com/jchorl/gowaker/integration"
+	"github.com/jchorl/gowaker/plugin"
 	"github.com/jchorl/gowaker/requestcontext"
 	"github.com/jchorl/gowaker/speech"
 	"github.com/jchorl/gowaker/spotify"
 )
 
-func AlarmRun(ctx *fasthttp.RequestCtx) error {
+// ErrNotRunning is returned when there is no alarm run to snooze or dismiss
+var ErrNotRunning = errors.New("no alarm is running")
+
+// DefaultSnooze is how long an alarm is snoozed for when no duration is given
+const DefaultSnooze = 9 * time.Minute
+
+// Steps of an alarm run, published as step events
+const (
+	StepSettingVolume      = "setting_volume"
+	StepGettingSong        = "getting_song"
+	StepPlayingSong        = "playing_song"
+	StepWaitingForBriefing = "waiting_for_briefing"
+	StepSpeakingBriefing   = "speaking_briefing"
+)
+
+// alarmVolume is the volume alarms play at, in percent
+const alarmVolume = 100
+
+// NowPlaying is the data of track events
+type NowPlaying struct {
+	ID         string `json:"id"`
+	Name       string `json:"name"`
+	Artist     string `json:"artist"`
+	DurationMs int    `json:"duration_ms"`
+}
+
+// activeRun is an alarm run in progress, which can be stopped early
+type activeRun struct {
+	ctx   *fasthttp.RequestCtx
+	run   plugin.Run
+	steps []integration.Step
+
+	// record is the id of the run in the history
+	record string
+
+	stop     chan struct{}
+	stopOnce sync.Once
+	snoozed  bool
+}
+
+// halt stops the run, returning false if it was already stopped
+func (a *activeRun) halt() bool {
+	halted := false
+	a.stopOnce.Do(func() {
+		close(a.stop)
+		halted = true
+	})
+	return halted
+}
+
+func (a *activeRun) stopped() bool {
+	select {
+	case <-a.stop:
+		return true
+	default:
+		return false
+	}
+}
+
+var (
+	activeMu sync.Mutex
+	active   *activeRun
+
+	// pending are snoozed runs waiting to go off again
+	pending = map[*snooze]bool{}
+)
+
+// snooze is a run that will go off again when its timer fires
+type snooze struct {
+	a     *activeRun
+	timer *time.Timer
+}
+
+// AlarmRun plays the wakeup song and then speaks the briefing, running the
+// alarm's integration steps along the way
+func AlarmRun(ctx *fasthttp.RequestCtx, run plugin.Run, steps []integration.Step) error {
 	log.Infof("running job at %s", time.Now())
-	err := setVolume()
+
+	a := &activeRun{ctx: ctx, run: run, steps: steps, stop: make(chan struct{})}
+	activeMu.Lock()
+	active = a
+	activeMu.Unlock()
+
+	a.record = startRecord(ctx, run)
+	publish(ctx, events.Firing, run, nil)
+	err := ring(ctx, a)
+
+	activeMu.Lock()
+	if active == a {
+		active = nil
+	}
+	snoozed := a.snoozed
+	activeMu.Unlock()
+
+	switch {
+	case snoozed:
+		endRecord(ctx, a.record, OutcomeSnoozed, err)
+		// the alarm will go off again, so it isn't over yet
+		return nil
+	case a.stopped():
+		// dismissed, which was already published
+		endRecord(ctx, a.record, OutcomeDismissed, err)
+	case err != nil:
+		endRecord(ctx, a.record, OutcomeFailed, err)
+		publish(ctx, events.Failed, run, err.Error())
+	default:
+		endRecord(ctx, a.record, OutcomeFinished, nil)
+		publish(ctx, events.Finished, run, nil)
+	}
+
+	integration.Fire(ctx, steps, integration.Dismiss, run)
+	return err
+}
+
+// Dismiss stops the alarm that is going off. If none is, snoozed alarms are
+// stopped from going off again.
+func Dismiss() error {
+	activeMu.Lock()
+	a := active
+	if a != nil && a.halt() {
+		activeMu.Unlock()
+		publish(a.ctx, events.Dismissed, a.run, nil)
+		return nil
+	}
+
+	var snoozed []*activeRun
+	for p := range pending {
+		if p.timer.Stop() {
+			snoozed = append(snoozed, p.a)
+		}
+		delete(pending, p)
+	}
+	activeMu.Unlock()
+
+	if len(snoozed) == 0 {
+		return ErrNotRunning
+	}
+
+	// the runs ended when they were snoozed, so their dismiss steps haven't run yet
+	for _, a := range snoozed {
+		publish(a.ctx, events.Dismissed, a.run, nil)
+		integration.Fire(a.ctx, a.steps, integration.Dismiss, a.run)
+	}
+	return nil
+}
+
+// Snooze stops the alarm that is going off and runs it again after d
+func Snooze(d time.Duration) error {
+	activeMu.Lock()
+	defer activeMu.Unlock()
+
+	a := active
+	// halting and marking the run snoozed together, so a concurrent dismiss
+	// can't be recorded as a snooze
+	if a == nil || !a.halt() {
+		return ErrNotRunning
+	}
+	a.snoozed = true
+
+	until := time.Now().Add(d)
+	p := &snooze{a: a}
+	p.timer = time.AfterFunc(d, func() {
+		activeMu.Lock()
+		if !pending[p] {
+			activeMu.Unlock()
+			return
+		}
+		delete(pending, p)
+		activeMu.Unlock()
+
+		run := a.run
+		run.Scheduled = until.Truncate(time.Minute)
+		AlarmRun(a.ctx, run, a.steps)
+	})
+	pending[p] = true
+
+	publish(a.ctx, events.Snoozed, a.run, map[string]time.Time{"until": until})
+	return nil
+}
+
+func publish(ctx *fasthttp.RequestCtx, t events.Type, run plugin.Run, data interface{}) {
+	requestcontext.Events(ctx).Publish(events.Event{
+		Type:    t,
+		AlarmID: run.AlarmID,
+		Label:   run.Label,
+		Data:    data,
+	})
+}
+
+func publishStep(ctx *fasthttp.RequestCtx, run plugin.Run, step string) {
+	publish(ctx, events.Step, run, map[string]string{"step": step})
+}
+
+// ring plays the song and the briefing, giving up early when the run is stopped
+func ring(ctx *fasthttp.RequestCtx, a *activeRun) error {
+	publishStep(ctx, a.run, StepSettingVolume)
+	err := setVolume(alarmVolume)
+	if err != nil {
+		log.Errorf("setting volume: %s", err)
+		return err
+	}
+	publish(ctx, events.Volume, a.run, map[string]int{"percent": alarmVolume})
+
+	publishStep(ctx, a.run, StepGettingSong)
+	wakeupSong, err := spotify.GetNextWakeupSong(ctx)
 	if err != nil {
-		log.Error("setting volume: %s", err)
+		err = fmt.Errorf("getting next wakeup song: %w", err)
+		log.Error(err)
 		return err
 	}
 
-	speechChan := make(chan []byte)
-	speechErrChan := make(chan error)
+	type spokenBriefing struct {
+		contents []byte
+		data     briefing.Data
+	}
+
+	// buffered so the goroutine can finish even if the run is stopped
+	speechChan := make(chan spokenBriefing, 1)
+	speechErrChan := make(chan error, 1)
 
 	go func() {
-		speechStr, err := generateSpeechStr(ctx)
+		speechDoc, data, err := briefing.Generate(ctx, a.run, briefing.TrackFromSong(wakeupSong))
 		if err != nil {
 			speechErrChan <- fmt.Errorf("generating speech: %s", err)
 			return
 		}
 
-		contents, err := speech.GetAudioContent(ctx, speechStr)
+		contents, err := speech.GetSSMLAudioContent(ctx, speechDoc)
 		if err != nil {
 			speechErrChan <- fmt.Errorf("getting audio content: %s", err)
 			return
 		}
 
-		speechChan <- contents
+		speechChan <- spokenBriefing{contents: contents, data: data}
 	}()
 
-	err = playSong(ctx)
+	integration.Fire(ctx, a.steps, integration.SongStart, a.run)
+	err = playSong(ctx, a, wakeupSong)
 	if err != nil {
 		log.Error(err)
 		return err
 	}
 
-	var contents []byte
+	publishStep(ctx, a.run, StepWaitingForBriefing)
+	var spoken spokenBriefing
 	select {
 	case err = <-speechErrChan:
 		log.Error(err)
 		return err
-	case contents = <-speechChan:
+	case spoken = <-speechChan:
+	case <-a.stop:
+		return nil
 	}
 
-	streamer, format, err := wav.Decode(bytes.NewReader(contents))
+	streamer, format, err := wav.Decode(bytes.NewReader(spoken.contents))
 	if err != nil {
-		log.Error("wav decoding: %s", err)
+		log.Errorf("wav decoding: %s", err)
 		return err
 	}
 	defer streamer.Close()
 
-	done := make(chan bool)
+	publishStep(ctx, a.run, StepSpeakingBriefing)
+	integration.Fire(ctx, a.steps, integration.BriefingStart, a.run)
+	done := make(chan bool, 1)
 	speaker.Init(format.SampleRate, format.SampleRate.N(time.Second/10))
 	speaker.Play(beep.Seq(streamer, beep.Callback(func() {
 		done <- true
 	})))
-	<-done
+	select {
+	case <-done:
+		briefing.Spoken(ctx, a.run, spoken.data)
+	case <-a.stop:
+		speaker.Clear()
+	}
 
 	log.Infof("finished job at %s", time.Now())
 	return nil
 }
 
-func setVolume() error {
-	cmd := exec.Command("amixer", "sset", "DAC", "100%")
+func setVolume(percent int) error {
+	level := fmt.Sprintf("%d%%", percent)
+	cmd := exec.Command("amixer", "sset", "DAC", level)
 	err := cmd.Run()
 	if err != nil {
 		return fmt.Errorf("setting volume on DAC: %w", err)
 	}
 
-	cmd = exec.Command("amixer", "sset", "Line Out", "100%")
+	cmd = exec.Command("amixer", "sset", "Line Out", level)
 	err = cmd.Run()
 	if err != nil {
 		return fmt.Errorf("setting volume on Line Out: %w", err)
@@ -93,7 +320,7 @@ func setVolume() error {
 	return nil
 }
 
-func playSong(ctx *fasthttp.RequestCtx) error {
+func playSong(ctx *fasthttp.RequestCtx, a *activeRun, wakeupSong *upstreamspotify.FullTrack) error {
 	var device *upstreamspotify.PlayerDevice
 
 	devices, err := spotify.GetDevices(ctx)
@@ -112,19 +339,27 @@ func playSong(ctx *fasthttp.RequestCtx) error {
 		return fmt.Errorf("finding device %s", config.SpotifyDeviceName)
 	}
 
-	wakeupSong, err := spotify.GetNextWakeupSong(ctx)
-	if err != nil {
-		return fmt.Errorf("getting next wakeup song: %w", err)
-	}
-
+	publishStep(ctx, a.run, StepPlayingSong)
 	err = spotify.PlaySong(ctx, wakeupSong, device)
 	if err != nil {
 		return fmt.Errorf("playing wakeup song: %w", err)
 	}
 
-	err = <-spotify.WaitForSong(ctx)
-	if err != nil {
-		return fmt.Errorf("waiting for spotify to finish playing: %w", err)
+	track := briefing.TrackFromSong(wakeupSong)
+	publish(ctx, events.Track, a.run, NowPlaying{
+		ID:         string(wakeupSong.ID),
+		Name:       track.Name,
+		Artist:     track.Artist,
+		DurationMs: wakeupSong.Duration,
+	})
+	recordTrack(ctx, a.record, fmt.Sprintf("%s - %s", track.Name, track.Artist))
+
+	select {
+	case err = <-spotify.WaitForSong(ctx, a.stop):
+		if err != nil {
+			return fmt.Errorf("waiting for spotify to finish playing: %w", err)
+		}
+	case <-a.stop:
 	}
 
 	err = spotify.PauseSong(ctx)
@@ -139,20 +374,3 @@ func playSong(ctx *fasthttp.RequestCtx) error {
 
 	return nil
 }
-
-func generateSpeechStr(ctx *fasthttp.RequestCtx) (string, error) {
-	plugins := requestcontext.Plugins(ctx)
-
-	var fullStr string
-	for _, p := range plugins {
-		pText, err := p.Text()
-		if err != nil {
-			return "", fmt.Errorf("p.Text(): %w", err)
-		}
-		fullStr += pText
-	}
-
-	fullStr += "Have a great day!"
-
-	return fullStr, nil
-}
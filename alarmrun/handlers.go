@@ -0,0 +1,93 @@
+package alarmrun
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	log "github.com/golang/glog"
+	"github.com/valyala/fasthttp"
+)
+
+// SnoozeRequest is the optional body of a snooze
+type SnoozeRequest struct {
+	Minutes int `json:"minutes"`
+}
+
+// Duration returns how long to snooze for
+func (r SnoozeRequest) Duration() time.Duration {
+	if r.Minutes <= 0 {
+		return DefaultSnooze
+	}
+	return time.Duration(r.Minutes) * time.Minute
+}
+
+func HandlerSnooze(ctx *fasthttp.RequestCtx) {
+	req := SnoozeRequest{}
+	if len(ctx.Request.Body()) > 0 {
+		err := json.Unmarshal(ctx.Request.Body(), &req)
+		if err != nil {
+			err = fmt.Errorf("decoding body: %w", err)
+			log.Error(err)
+			ctx.Error(err.Error(), fasthttp.StatusBadRequest)
+			return
+		}
+	}
+
+	err := Snooze(req.Duration())
+	if errors.Is(err, ErrNotRunning) {
+		ctx.Error(err.Error(), fasthttp.StatusConflict)
+		return
+	}
+	if err != nil {
+		err = fmt.Errorf("snoozing: %w", err)
+		log.Error(err)
+		ctx.Error(err.Error(), fasthttp.StatusInternalServerError)
+		return
+	}
+}
+
+func HandlerDismiss(ctx *fasthttp.RequestCtx) {
+	err := Dismiss()
+	if errors.Is(err, ErrNotRunning) {
+		ctx.Error(err.Error(), fasthttp.StatusConflict)
+		return
+	}
+	if err != nil {
+		err = fmt.Errorf("dismissing: %w", err)
+		log.Error(err)
+		ctx.Error(err.Error(), fasthttp.StatusInternalServerError)
+		return
+	}
+}
+
+const (
+	defaultHistoryLimit = 50
+	maxHistoryLimit     = 500
+)
+
+// HandlerGetHistory lists recent alarm runs, newest first. The limit query
+// param picks how many.
+func HandlerGetHistory(ctx *fasthttp.RequestCtx) {
+	limit := defaultHistoryLimit
+	if ctx.QueryArgs().Has("limit") {
+		var err error
+		limit, err = ctx.QueryArgs().GetUint("limit")
+		if err != nil || limit == 0 || limit > maxHistoryLimit {
+			ctx.Error(fmt.Sprintf("limit must be between 1 and %d", maxHistoryLimit), fasthttp.StatusBadRequest)
+			return
+		}
+	}
+
+	records, err := History(ctx, limit)
+	if err != nil {
+		err = fmt.Errorf("getting alarm history: %w", err)
+		log.Error(err)
+		ctx.Error(err.Error(), fasthttp.StatusInternalServerError)
+		return
+	}
+
+	ctx.Response.SetStatusCode(fasthttp.StatusOK)
+	json.NewEncoder(ctx).Encode(records)
+}
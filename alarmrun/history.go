@@ -0,0 +1,121 @@
+package alarmrun
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+
+	log "github.com/golang/glog"
+	"github.com/google/uuid"
+	"github.com/valyala/fasthttp"
+
+	"github.com/jchorl/gowaker/plugin"
+	"github.com/jchorl/gowaker/requestcontext"
+)
+
+// Outcomes of an alarm run
+const (
+	OutcomeRunning   = "running"
+	OutcomeFinished  = "finished"
+	OutcomeDismissed = "dismissed"
+	OutcomeSnoozed   = "snoozed"
+	OutcomeFailed    = "failed"
+)
+
+// Record is an alarm run in the history. A snoozed alarm gets a new record
+// each time it goes off.
+type Record struct {
+	ID        string     `json:"id"`
+	AlarmID   string     `json:"alarm_id"`
+	Label     string     `json:"label"`
+	Scheduled time.Time  `json:"scheduled"`
+	Started   time.Time  `json:"started"`
+	Ended     *time.Time `json:"ended"`
+	Outcome   string     `json:"outcome"`
+	Error     string     `json:"error"`
+
+	// Track is the song that was played, empty if it didn't get that far
+	Track string `json:"track"`
+}
+
+// startRecord adds a running record to the history, returning its id. The
+// history is best effort, so failures are only logged.
+func startRecord(ctx *fasthttp.RequestCtx, run plugin.Run) string {
+	db := requestcontext.DB(ctx)
+	id := uuid.New().String()
+
+	_, err := db.Exec(
+		"insert into runs(id, alarm_id, label, scheduled_at, started_at, outcome) values(?, ?, ?, ?, ?, ?)",
+		id, run.AlarmID, run.Label, run.Scheduled.Unix(), time.Now().Unix(), OutcomeRunning,
+	)
+	if err != nil {
+		log.Errorf("recording alarm run: %s", err)
+	}
+
+	return id
+}
+
+func recordTrack(ctx *fasthttp.RequestCtx, id, track string) {
+	db := requestcontext.DB(ctx)
+
+	_, err := db.Exec("update runs set track = ? where id = ?", track, id)
+	if err != nil {
+		log.Errorf("recording track of alarm run %s: %s", id, err)
+	}
+}
+
+func endRecord(ctx *fasthttp.RequestCtx, id, outcome string, runErr error) {
+	db := requestcontext.DB(ctx)
+
+	errStr := ""
+	if runErr != nil {
+		errStr = runErr.Error()
+	}
+
+	_, err := db.Exec(
+		"update runs set ended_at = ?, outcome = ?, error = ? where id = ?",
+		time.Now().Unix(), outcome, errStr, id,
+	)
+	if err != nil {
+		log.Errorf("recording end of alarm run %s: %s", id, err)
+	}
+}
+
+// History returns the most recent alarm runs, newest first
+func History(ctx *fasthttp.RequestCtx, limit int) ([]Record, error) {
+	db := requestcontext.DB(ctx)
+
+	rows, err := db.Query(`
+		select id, alarm_id, label, scheduled_at, started_at, ended_at, outcome, error, track
+		from runs order by started_at desc, scheduled_at desc limit ?
+	`, limit)
+	if err != nil {
+		return nil, fmt.Errorf("querying runs: %w", err)
+	}
+	defer rows.Close()
+
+	records := []Record{}
+	for rows.Next() {
+		var r Record
+		var scheduled, started int64
+		var ended sql.NullInt64
+		err = rows.Scan(&r.ID, &r.AlarmID, &r.Label, &scheduled, &started, &ended, &r.Outcome, &r.Error, &r.Track)
+		if err != nil {
+			return nil, fmt.Errorf("scanning row: %w", err)
+		}
+
+		r.Scheduled = time.Unix(scheduled, 0)
+		r.Started = time.Unix(started, 0)
+		if ended.Valid {
+			t := time.Unix(ended.Int64, 0)
+			r.Ended = &t
+		}
+		records = append(records, r)
+	}
+	err = rows.Err()
+	if err != nil {
+		return nil, fmt.Errorf("iterating over run query results: %w", err)
+	}
+
+	return records, nil
+}
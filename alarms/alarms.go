@@ -1,10 +1,12 @@
 package alarms
 
 import (
+	"database/sql"
 	"encoding/json"
+	"errors"
 	"fmt"
-	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	log "github.com/golang/glog"
@@ -13,15 +15,30 @@ import (
 
 	"github.com/jasonlvhit/gocron"
 	"github.com/jchorl/gowaker/alarmrun"
+	"github.com/jchorl/gowaker/events"
+	"github.com/jchorl/gowaker/integration"
+	"github.com/jchorl/gowaker/plugin"
 	"github.com/jchorl/gowaker/requestcontext"
 )
 
 type Alarm struct {
 	ID      string    `json:"id"`
+	Label   string    `json:"label"`
 	Time    Time      `json:"time"`
 	Repeat  bool      `json:"repeat"`
 	Days    []string  `json:"days"`
 	NextRun time.Time `json:"next_run"`
+
+	// Timezone is the IANA zone that the briefing is given in, e.g.
+	// "America/Toronto". It defaults to the server's local zone.
+	Timezone string `json:"timezone"`
+
+	// Plugins optionally chooses which plugins to run for the alarm,
+	// instead of every enabled plugin
+	Plugins []string `json:"plugins"`
+
+	// Integrations are calls to home automation at points of the alarm run
+	Integrations []integration.Step `json:"integrations"`
 }
 
 type Time struct {
@@ -31,6 +48,100 @@ type Time struct {
 
 const alarmCronType = "alarm"
 
+// location returns the time zone that the alarm's briefing is given in
+func (a Alarm) location() (*time.Location, error) {
+	if a.Timezone == "" {
+		return time.Local, nil
+	}
+
+	return time.LoadLocation(a.Timezone)
+}
+
+// run returns the plugin run descriptor for a firing of the alarm at scheduled
+func (a Alarm) run(scheduled time.Time) plugin.Run {
+	loc, err := a.location()
+	if err != nil {
+		log.Errorf("loading location for alarm %s, falling back to local: %s", a.ID, err)
+		loc = time.Local
+	}
+
+	return plugin.Run{
+		AlarmID:   a.ID,
+		Label:     a.Label,
+		Scheduled: scheduled,
+		Location:  loc,
+		Plugins:   a.Plugins,
+	}
+}
+
+// fireTime returns when the alarm's jobs fire, which is early enough for its
+// pre-alarm steps. dayShift is -1 when that is on the day before.
+func (a Alarm) fireTime() (hour, minute, dayShift int) {
+	leadMinutes := int((integration.Lead(a.Integrations) + time.Minute - 1) / time.Minute)
+	minutes := a.Time.Hour*60 + a.Time.Minute - leadMinutes
+	if minutes < 0 {
+		minutes += 24 * 60
+		dayShift = -1
+	}
+
+	return minutes / 60, minutes % 60, dayShift
+}
+
+// fireWeekday returns the weekday that the jobs of an alarm on day fire on
+func fireWeekday(day time.Weekday, dayShift int) time.Weekday {
+	return (day + time.Weekday(7+dayShift)) % 7
+}
+
+// lead is how long before the alarm time its jobs fire
+func (a Alarm) lead() time.Duration {
+	hour, minute, dayShift := a.fireTime()
+	return time.Duration((a.Time.Hour-hour-24*dayShift)*60+a.Time.Minute-minute) * time.Minute
+}
+
+var (
+	countdownsMu sync.Mutex
+	// countdowns are closed to stop alarms that are waiting to go off, by alarm id
+	countdowns = map[string]chan struct{}{}
+)
+
+// ring runs the pre-alarm steps, waits for the alarm time and runs the alarm.
+// It returns false if the alarm was deleted or changed before it went off.
+func (a Alarm) ring(ctx *fasthttp.RequestCtx) bool {
+	stop := make(chan struct{})
+	countdownsMu.Lock()
+	countdowns[a.ID] = stop
+	countdownsMu.Unlock()
+
+	defer func() {
+		countdownsMu.Lock()
+		if countdowns[a.ID] == stop {
+			delete(countdowns, a.ID)
+		}
+		countdownsMu.Unlock()
+	}()
+
+	alarmTime := time.Now().Truncate(time.Minute).Add(a.lead())
+	run := a.run(alarmTime)
+
+	if !integration.Countdown(ctx, a.Integrations, alarmTime, run, stop) {
+		log.Infof("alarm %s was changed before it went off", a.ID)
+		return false
+	}
+	alarmrun.AlarmRun(ctx, run, a.Integrations)
+	return true
+}
+
+// stopCountdown stops the alarm from going off if it is waiting to
+func stopCountdown(id string) {
+	countdownsMu.Lock()
+	defer countdownsMu.Unlock()
+
+	if stop, ok := countdowns[id]; ok {
+		close(stop)
+		delete(countdowns, id)
+	}
+}
+
 func HandlerPost(ctx *fasthttp.RequestCtx) {
 	alarm := Alarm{}
 	err := json.Unmarshal(ctx.Request.Body(), &alarm)
@@ -41,7 +152,14 @@ func HandlerPost(ctx *fasthttp.RequestCtx) {
 		return
 	}
 
-	alarm, err = newAlarm(ctx, alarm)
+	err = Validate(ctx, alarm)
+	if err != nil {
+		log.Error(err)
+		ctx.Error(err.Error(), fasthttp.StatusBadRequest)
+		return
+	}
+
+	alarm, err = Create(ctx, alarm)
 	if err != nil {
 		err = fmt.Errorf("creating new alarm: %w", err)
 		log.Error(err)
@@ -53,7 +171,40 @@ func HandlerPost(ctx *fasthttp.RequestCtx) {
 	json.NewEncoder(ctx).Encode(&alarm)
 }
 
-func newAlarm(ctx *fasthttp.RequestCtx, alarm Alarm) (Alarm, error) {
+// Validate checks an alarm before it is created or updated
+func Validate(ctx *fasthttp.RequestCtx, alarm Alarm) error {
+	if alarm.Time.Hour < 0 || alarm.Time.Hour > 23 || alarm.Time.Minute < 0 || alarm.Time.Minute > 59 {
+		return fmt.Errorf("invalid time %d:%02d", alarm.Time.Hour, alarm.Time.Minute)
+	}
+
+	for _, day := range alarm.Days {
+		if _, ok := dayStrToTimeDay[day]; !ok {
+			return fmt.Errorf("unknown day %s", day)
+		}
+	}
+
+	_, err := alarm.location()
+	if err != nil {
+		return fmt.Errorf("loading timezone: %w", err)
+	}
+
+	_, err = requestcontext.Plugins(ctx).Select(alarm.Plugins)
+	if err != nil {
+		return fmt.Errorf("validating plugins: %w", err)
+	}
+
+	for i, step := range alarm.Integrations {
+		err = step.Validate()
+		if err != nil {
+			return fmt.Errorf("validating integration step %d: %w", i, err)
+		}
+	}
+
+	return nil
+}
+
+// Create schedules and saves a validated alarm
+func Create(ctx *fasthttp.RequestCtx, alarm Alarm) (Alarm, error) {
 	alarm.ID = uuid.New().String()
 
 	alarm = newAlarmCron(ctx, alarm)
@@ -63,6 +214,69 @@ func newAlarm(ctx *fasthttp.RequestCtx, alarm Alarm) (Alarm, error) {
 		return Alarm{}, err
 	}
 
+	requestcontext.Events(ctx).Publish(events.Event{
+		Type:    events.Scheduled,
+		AlarmID: alarm.ID,
+		Label:   alarm.Label,
+		Data:    alarm,
+	})
+
+	return alarm, nil
+}
+
+// HandlerPut replaces the alarm with the id in the path, keeping its id
+func HandlerPut(ctx *fasthttp.RequestCtx) {
+	alarm := Alarm{}
+	err := json.Unmarshal(ctx.Request.Body(), &alarm)
+	if err != nil {
+		err = fmt.Errorf("decoding body: %w", err)
+		log.Error(err)
+		ctx.Error(err.Error(), fasthttp.StatusBadRequest)
+		return
+	}
+	alarm.ID = ctx.UserValue("id").(string)
+
+	err = Validate(ctx, alarm)
+	if err != nil {
+		log.Error(err)
+		ctx.Error(err.Error(), fasthttp.StatusBadRequest)
+		return
+	}
+
+	alarm, err = Update(ctx, alarm)
+	if errors.Is(err, sql.ErrNoRows) {
+		ctx.Error(fmt.Sprintf("alarm %s not found", alarm.ID), fasthttp.StatusNotFound)
+		return
+	}
+	if err != nil {
+		err = fmt.Errorf("updating alarm: %w", err)
+		log.Error(err)
+		ctx.Error(err.Error(), fasthttp.StatusInternalServerError)
+		return
+	}
+
+	ctx.Response.SetStatusCode(fasthttp.StatusOK)
+	json.NewEncoder(ctx).Encode(&alarm)
+}
+
+// Update reschedules and saves a validated alarm, returning sql.ErrNoRows if
+// there is no alarm with its id
+func Update(ctx *fasthttp.RequestCtx, alarm Alarm) (Alarm, error) {
+	err := updateAlarmDB(ctx, alarm)
+	if err != nil {
+		return alarm, err
+	}
+
+	removeAlarmJobs(ctx, alarm.ID)
+	alarm = newAlarmCron(ctx, alarm)
+
+	requestcontext.Events(ctx).Publish(events.Event{
+		Type:    events.Updated,
+		AlarmID: alarm.ID,
+		Label:   alarm.Label,
+		Data:    alarm,
+	})
+
 	return alarm, nil
 }
 
@@ -72,36 +286,51 @@ func newAlarmCron(ctx *fasthttp.RequestCtx, alarm Alarm) Alarm {
 	// need to clone the request ctx, because fasthttp recycles it after the req
 	clonedCtx := requestcontext.Clone(ctx)
 
+	// jobs fire early when there are pre-alarm steps
+	hour, minute, dayShift := alarm.fireTime()
+
 	if !alarm.Repeat {
 		job := scheduler.
 			Every(1).
 			Day().
 			At(
-				fmt.Sprintf("%d:%d", alarm.Time.Hour, alarm.Time.Minute),
+				fmt.Sprintf("%d:%d", hour, minute),
 			)
 
 		job.Tag(jobTag("id", alarm.ID), jobTag("type", alarmCronType))
 
+		// jobs run on the scheduler's goroutine, so ring elsewhere to not hold up other jobs
 		job.Do(func() {
-			alarmrun.AlarmRun(clonedCtx)
-			scheduler.RemoveByRef(job)
+			go func() {
+				if !alarm.ring(clonedCtx) {
+					return
+				}
+
+				// one-off alarms are done once they've run
+				err := deleteAlarm(clonedCtx, alarm.ID)
+				if err != nil {
+					log.Errorf("deleting one-off alarm %s: %s", alarm.ID, err)
+				}
+			}()
 		})
-		alarm.NextRun = job.NextScheduledTime()
+		alarm.NextRun = job.NextScheduledTime().Add(alarm.lead())
 	} else {
 		// create an alarm for each day it should run
 		for _, day := range alarm.Days {
 			job := scheduler.
 				Every(1).
-				Weekday(dayStrToTimeDay[day]).
+				Weekday(fireWeekday(dayStrToTimeDay[day], dayShift)).
 				At(
-					fmt.Sprintf("%d:%d", alarm.Time.Hour, alarm.Time.Minute),
+					fmt.Sprintf("%d:%d", hour, minute),
 				)
 
 			job.Tag(jobTag("id", alarm.ID), jobTag("type", alarmCronType))
 
-			job.Do(alarmrun.AlarmRun)
+			job.Do(func() {
+				go alarm.ring(clonedCtx)
+			})
 
-			thisNextTime := job.NextScheduledTime()
+			thisNextTime := job.NextScheduledTime().Add(alarm.lead())
 			if alarm.NextRun.Equal(time.Time{}) || thisNextTime.Before(alarm.NextRun) {
 				alarm.NextRun = thisNextTime
 			}
@@ -111,21 +340,40 @@ func newAlarmCron(ctx *fasthttp.RequestCtx, alarm Alarm) Alarm {
 	return alarm
 }
 
+// integrationsJSON encodes the integration steps for the db, empty if there are none
+func (a Alarm) integrationsJSON() (string, error) {
+	if len(a.Integrations) == 0 {
+		return "", nil
+	}
+
+	b, err := json.Marshal(a.Integrations)
+	if err != nil {
+		return "", fmt.Errorf("encoding integrations: %w", err)
+	}
+	return string(b), nil
+}
+
 func newAlarmDB(ctx *fasthttp.RequestCtx, alarm Alarm) error {
 	db := requestcontext.DB(ctx)
 
 	daysCSV := strings.Join(alarm.Days, ",")
+	pluginsCSV := strings.Join(alarm.Plugins, ",")
+
+	integrationsJSON, err := alarm.integrationsJSON()
+	if err != nil {
+		return err
+	}
 
 	stmt, err := db.Prepare(`
-		insert into alarms(id, hour, minute, repeat, days)
-		values(?, ?, ?, ?, ?)
+		insert into alarms(id, label, hour, minute, repeat, days, timezone, plugins, integrations)
+		values(?, ?, ?, ?, ?, ?, ?, ?, ?)
 	`,
 	)
 	if err != nil {
 		return fmt.Errorf("preparing alarm insert stmt: %w", err)
 	}
 	defer stmt.Close()
-	_, err = stmt.Exec(alarm.ID, alarm.Time.Hour, alarm.Time.Minute, alarm.Repeat, daysCSV)
+	_, err = stmt.Exec(alarm.ID, alarm.Label, alarm.Time.Hour, alarm.Time.Minute, alarm.Repeat, daysCSV, alarm.Timezone, pluginsCSV, integrationsJSON)
 	if err != nil {
 		return fmt.Errorf("executing alarm insert stmt: %w", err)
 	}
@@ -133,63 +381,84 @@ func newAlarmDB(ctx *fasthttp.RequestCtx, alarm Alarm) error {
 	return nil
 }
 
-func HandlerGet(ctx *fasthttp.RequestCtx) {
-	scheduler := requestcontext.Scheduler(ctx)
-	allJobs := scheduler.Jobs()
+func updateAlarmDB(ctx *fasthttp.RequestCtx, alarm Alarm) error {
+	db := requestcontext.DB(ctx)
 
-	groupedByID := map[string][]*gocron.Job{}
-	for _, job := range allJobs {
-		jobType := getJobTagValue(job, "type")
-		if jobType != alarmCronType {
-			continue
-		}
+	daysCSV := strings.Join(alarm.Days, ",")
+	pluginsCSV := strings.Join(alarm.Plugins, ",")
 
-		jobID := getJobTagValue(job, "id")
-		groupedByID[jobID] = append(groupedByID[jobID], job)
+	integrationsJSON, err := alarm.integrationsJSON()
+	if err != nil {
+		return err
 	}
 
-	alarms := []Alarm{}
-	for jobID, jobs := range groupedByID {
-		// for the time, just take the first job, they should all be the same
-		sample := jobs[0]
-		timeStr := sample.GetAt()
-		timeStrSplit := strings.Split(timeStr, ":")
-		hour, _ := strconv.Atoi(timeStrSplit[0])
-		minute, _ := strconv.Atoi(timeStrSplit[1])
+	stmt, err := db.Prepare(`
+		update alarms set label = ?, hour = ?, minute = ?, repeat = ?, days = ?, timezone = ?, plugins = ?, integrations = ?
+		where id = ?
+	`,
+	)
+	if err != nil {
+		return fmt.Errorf("preparing alarm update stmt: %w", err)
+	}
+	defer stmt.Close()
+	res, err := stmt.Exec(alarm.Label, alarm.Time.Hour, alarm.Time.Minute, alarm.Repeat, daysCSV, alarm.Timezone, pluginsCSV, integrationsJSON, alarm.ID)
+	if err != nil {
+		return fmt.Errorf("executing alarm update stmt: %w", err)
+	}
 
-		alarm := Alarm{
-			ID: jobID,
-			Time: Time{
-				Hour:   hour,
-				Minute: minute,
-			},
-		}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("counting updated alarms: %w", err)
+	}
+	if n == 0 {
+		return sql.ErrNoRows
+	}
 
-		if len(jobs) == 1 {
-			alarm.NextRun = jobs[0].NextScheduledTime()
-		} else {
-			alarm.Repeat = true
+	return nil
+}
 
-			for _, job := range jobs {
-				alarm.Days = append(
-					alarm.Days,
-					strings.ToLower(job.GetWeekday().String()),
-				)
+func HandlerGet(ctx *fasthttp.RequestCtx) {
+	alarms, err := getAlarmsDB(ctx)
+	if err != nil {
+		err = fmt.Errorf("getting alarms: %w", err)
+		log.Error(err)
+		ctx.Error(err.Error(), fasthttp.StatusInternalServerError)
+		return
+	}
 
-				thisNextTime := job.NextScheduledTime()
-				if alarm.NextRun.Equal(time.Time{}) || thisNextTime.Before(alarm.NextRun) {
-					alarm.NextRun = thisNextTime
-				}
+	jobsByID := alarmJobsByID(ctx)
+	for i, alarm := range alarms {
+		for _, job := range jobsByID[alarm.ID] {
+			thisNextTime := job.NextScheduledTime().Add(alarm.lead())
+			if alarm.NextRun.Equal(time.Time{}) || thisNextTime.Before(alarm.NextRun) {
+				alarm.NextRun = thisNextTime
 			}
 		}
-
-		alarms = append(alarms, alarm)
+		alarms[i] = alarm
 	}
 
 	ctx.Response.SetStatusCode(fasthttp.StatusOK)
 	json.NewEncoder(ctx).Encode(alarms)
 }
 
+// alarmJobsByID groups the scheduled alarm jobs by alarm id
+func alarmJobsByID(ctx *fasthttp.RequestCtx) map[string][]*gocron.Job {
+	scheduler := requestcontext.Scheduler(ctx)
+
+	groupedByID := map[string][]*gocron.Job{}
+	for _, job := range scheduler.Jobs() {
+		jobType := getJobTagValue(job, "type")
+		if jobType != alarmCronType {
+			continue
+		}
+
+		jobID := getJobTagValue(job, "id")
+		groupedByID[jobID] = append(groupedByID[jobID], job)
+	}
+
+	return groupedByID
+}
+
 func HandlerDelete(ctx *fasthttp.RequestCtx) {
 	alarm := Alarm{}
 	err := json.Unmarshal(ctx.Request.Body(), &alarm)
@@ -209,9 +478,10 @@ func HandlerDelete(ctx *fasthttp.RequestCtx) {
 	}
 }
 
+// deleteAlarm deletes and unschedules an alarm, whether a client deleted it or
+// it was a one-off that has run
 func deleteAlarm(ctx *fasthttp.RequestCtx, id string) error {
 	db := requestcontext.DB(ctx)
-	scheduler := requestcontext.Scheduler(ctx)
 
 	stmt, err := db.Prepare(`delete from alarms where id = ?`)
 	if err != nil {
@@ -223,6 +493,21 @@ func deleteAlarm(ctx *fasthttp.RequestCtx, id string) error {
 		return fmt.Errorf("executing alarm delete stmt: %w", err)
 	}
 
+	removeAlarmJobs(ctx, id)
+
+	requestcontext.Events(ctx).Publish(events.Event{
+		Type:    events.Deleted,
+		AlarmID: id,
+	})
+	return nil
+}
+
+// removeAlarmJobs unschedules an alarm, including one that is counting down
+// to going off
+func removeAlarmJobs(ctx *fasthttp.RequestCtx, id string) {
+	scheduler := requestcontext.Scheduler(ctx)
+	stopCountdown(id)
+
 	allJobs := scheduler.Jobs()
 	for _, job := range allJobs {
 		jobType := getJobTagValue(job, "type")
@@ -235,51 +520,104 @@ func deleteAlarm(ctx *fasthttp.RequestCtx, id string) error {
 			scheduler.RemoveByRef(job)
 		}
 	}
-
-	return nil
 }
 
 // RestoreAlarmsFromDB restores alarms into the scheduler
 func RestoreAlarmsFromDB(ctx *fasthttp.RequestCtx) error {
+	alarms, err := getAlarmsDB(ctx)
+	if err != nil {
+		return err
+	}
+
+	for _, alarm := range alarms {
+		log.Infof("restoring alarm: %+v", alarm)
+		newAlarmCron(ctx, alarm)
+	}
+
+	return nil
+}
+
+// Locations returns the time zones that alarms go off in, each once
+func Locations(ctx *fasthttp.RequestCtx) ([]*time.Location, error) {
+	alarms, err := getAlarmsDB(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	seen := map[string]bool{}
+	var locs []*time.Location
+	for _, alarm := range alarms {
+		loc, err := alarm.location()
+		if err != nil {
+			log.Errorf("loading time zone of alarm %s: %s", alarm.ID, err)
+			continue
+		}
+		if seen[loc.String()] {
+			continue
+		}
+		seen[loc.String()] = true
+		locs = append(locs, loc)
+	}
+
+	return locs, nil
+}
+
+func getAlarmsDB(ctx *fasthttp.RequestCtx) ([]Alarm, error) {
 	db := requestcontext.DB(ctx)
 
-	// query example
-	rows, err := db.Query("select id, hour, minute, repeat, days from alarms")
+	rows, err := db.Query("select id, label, hour, minute, repeat, days, timezone, plugins, integrations from alarms")
 	if err != nil {
-		return fmt.Errorf("querying existing alarms: %w", err)
+		return nil, fmt.Errorf("querying existing alarms: %w", err)
 	}
 	defer rows.Close()
+
+	alarms := []Alarm{}
 	for rows.Next() {
 		var id string
+		var label string
 		var hour int
 		var minute int
 		var repeat bool
 		var days string
+		var timezone string
+		var plugins string
+		var integrations string
 
-		err = rows.Scan(&id, &hour, &minute, &repeat, &days)
+		err = rows.Scan(&id, &label, &hour, &minute, &repeat, &days, &timezone, &plugins, &integrations)
 		if err != nil {
-			return fmt.Errorf("scanning row: %w", err)
+			return nil, fmt.Errorf("scanning row: %w", err)
 		}
 
-		daysSplit := strings.Split(days, ",")
 		alarm := Alarm{
-			ID: id,
+			ID:    id,
+			Label: label,
 			Time: Time{
 				Hour:   hour,
 				Minute: minute,
 			},
-			Repeat: repeat,
-			Days:   daysSplit,
+			Repeat:   repeat,
+			Timezone: timezone,
 		}
-		log.Infof("restoring alarm: %+v", alarm)
-		newAlarmCron(ctx, alarm)
+		if repeat {
+			alarm.Days = strings.Split(days, ",")
+		}
+		if plugins != "" {
+			alarm.Plugins = strings.Split(plugins, ",")
+		}
+		if integrations != "" {
+			err = json.Unmarshal([]byte(integrations), &alarm.Integrations)
+			if err != nil {
+				return nil, fmt.Errorf("decoding integrations of alarm %s: %w", id, err)
+			}
+		}
+		alarms = append(alarms, alarm)
 	}
 	err = rows.Err()
 	if err != nil {
-		return fmt.Errorf("iterating over alarm query results: %w", err)
+		return nil, fmt.Errorf("iterating over alarm query results: %w", err)
 	}
 
-	return nil
+	return alarms, nil
 }
 
 var dayStrToTimeDay = map[string]time.Weekday{
@@ -0,0 +1,129 @@
+package alarms
+
+import (
+	"database/sql"
+	"testing"
+	"time"
+
+	_ "github.com/mattn/go-sqlite3"
+
+	"github.com/jchorl/gowaker/integration"
+	"github.com/jchorl/gowaker/requestcontext"
+)
+
+func preAlarm(offset string) integration.Step {
+	return integration.Step{Point: integration.PreAlarm, Offset: offset, URL: "http://lights.local/on"}
+}
+
+func TestFireTime(t *testing.T) {
+	tests := []struct {
+		name         string
+		time         Time
+		steps        []integration.Step
+		wantHour     int
+		wantMinute   int
+		wantDayShift int
+		wantLead     time.Duration
+	}{
+		{name: "no steps", time: Time{7, 30}, wantHour: 7, wantMinute: 30},
+		{name: "pre-alarm", time: Time{7, 30}, steps: []integration.Step{preAlarm("10m")}, wantHour: 7, wantMinute: 20, wantLead: 10 * time.Minute},
+		{name: "rounded up to a minute", time: Time{7, 0}, steps: []integration.Step{preAlarm("90s")}, wantHour: 6, wantMinute: 58, wantLead: 2 * time.Minute},
+		{
+			name:       "earliest of several",
+			time:       Time{6, 15},
+			steps:      []integration.Step{preAlarm("10m"), {Point: integration.SongStart, URL: "http://lights.local/on"}, preAlarm("45m")},
+			wantHour:   5,
+			wantMinute: 30,
+			wantLead:   45 * time.Minute,
+		},
+		{name: "to midnight", time: Time{0, 10}, steps: []integration.Step{preAlarm("10m")}, wantHour: 0, wantMinute: 0, wantLead: 10 * time.Minute},
+		{name: "across midnight", time: Time{0, 5}, steps: []integration.Step{preAlarm("10m")}, wantHour: 23, wantMinute: 55, wantDayShift: -1, wantLead: 10 * time.Minute},
+		{name: "max lead", time: Time{1, 0}, steps: []integration.Step{preAlarm("12h")}, wantHour: 13, wantMinute: 0, wantDayShift: -1, wantLead: 12 * time.Hour},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a := Alarm{Time: tt.time, Integrations: tt.steps}
+
+			hour, minute, dayShift := a.fireTime()
+			if hour != tt.wantHour || minute != tt.wantMinute || dayShift != tt.wantDayShift {
+				t.Errorf("got %d:%02d shifted %d days, want %d:%02d shifted %d days", hour, minute, dayShift, tt.wantHour, tt.wantMinute, tt.wantDayShift)
+			}
+			if lead := a.lead(); lead != tt.wantLead {
+				t.Errorf("got lead %s, want %s", lead, tt.wantLead)
+			}
+		})
+	}
+}
+
+func TestFireWeekday(t *testing.T) {
+	tests := []struct {
+		day      time.Weekday
+		dayShift int
+		want     time.Weekday
+	}{
+		{time.Wednesday, 0, time.Wednesday},
+		{time.Wednesday, -1, time.Tuesday},
+		{time.Sunday, -1, time.Saturday},
+		{time.Monday, -1, time.Sunday},
+		{time.Saturday, 0, time.Saturday},
+	}
+
+	for _, tt := range tests {
+		if got := fireWeekday(tt.day, tt.dayShift); got != tt.want {
+			t.Errorf("fireWeekday(%s, %d) = %s, want %s", tt.day, tt.dayShift, got, tt.want)
+		}
+	}
+}
+
+func TestLocations(t *testing.T) {
+	db, err := sql.Open("sqlite3", ":memory:")
+	if err != nil {
+		t.Fatalf("opening db: %s", err)
+	}
+	db.SetMaxOpenConns(1)
+	defer db.Close()
+
+	_, err = db.Exec(`create table alarms (
+		id text not null primary key,
+		label text not null default '',
+		hour int not null,
+		minute int not null,
+		repeat bool not null,
+		days string,
+		timezone text not null default '',
+		plugins text not null default '',
+		integrations text not null default ''
+	)`)
+	if err != nil {
+		t.Fatalf("creating table: %s", err)
+	}
+
+	for i, tz := range []string{"America/Toronto", "", "Asia/Tokyo", "America/Toronto", "Mars/Olympus"} {
+		_, err = db.Exec("insert into alarms(id, hour, minute, repeat, days, timezone) values(?, 7, 0, false, '', ?)", i, tz)
+		if err != nil {
+			t.Fatalf("inserting alarm: %s", err)
+		}
+	}
+
+	ctx := requestcontext.NewInternal()
+	requestcontext.SetDB(ctx, db)
+	locs, err := Locations(ctx)
+	if err != nil {
+		t.Fatalf("listing locations: %s", err)
+	}
+
+	var got []string
+	for _, loc := range locs {
+		got = append(got, loc.String())
+	}
+	want := []string{"America/Toronto", time.Local.String(), "Asia/Tokyo"}
+	if len(got) != len(want) {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("got %v, want each valid zone once: %v", got, want)
+		}
+	}
+}
@@ -0,0 +1,228 @@
+// Package auth manages the bearer tokens that API requests are checked
+// against. Only hashes of tokens are stored, so a token is shown once, when
+// it is created.
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/valyala/fasthttp"
+)
+
+// Scope is what a token is allowed to do
+type Scope string
+
+const (
+	// ScopeRead allows GET requests, e.g. for a bedside display
+	ScopeRead Scope = "read"
+
+	// ScopeAdmin allows everything
+	ScopeAdmin Scope = "admin"
+)
+
+// tokenPrefix makes tokens easy to spot, e.g. in a leaked config file
+const tokenPrefix = "gwk_"
+
+// lastUsedResolution is how stale a token's last use may get before it is
+// written again, so that busy clients don't write on every request
+const lastUsedResolution = time.Minute
+
+// ErrInvalidToken is returned for tokens that don't exist or were revoked
+var ErrInvalidToken = errors.New("invalid token")
+
+// ParseScope checks a scope's name
+func ParseScope(s string) (Scope, error) {
+	switch Scope(s) {
+	case ScopeRead, ScopeAdmin:
+		return Scope(s), nil
+	}
+	return "", fmt.Errorf("unknown scope %q, must be %s or %s", s, ScopeRead, ScopeAdmin)
+}
+
+// Allows returns whether the scope may make a request with the method
+func (s Scope) Allows(method string) bool {
+	if s == ScopeAdmin {
+		return true
+	}
+
+	switch method {
+	case fasthttp.MethodGet, fasthttp.MethodHead, fasthttp.MethodOptions:
+		return s == ScopeRead
+	}
+	return false
+}
+
+// Token is a stored token, without its secret
+type Token struct {
+	ID       string     `json:"id"`
+	Name     string     `json:"name"`
+	Scope    Scope      `json:"scope"`
+	Created  time.Time  `json:"created"`
+	LastUsed *time.Time `json:"last_used"`
+}
+
+func hash(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(sum[:])
+}
+
+// Create stores a new token and returns it along with its secret
+func Create(db *sql.DB, name string, scope Scope) (Token, string, error) {
+	b := make([]byte, 32)
+	_, err := rand.Read(b)
+	if err != nil {
+		return Token{}, "", fmt.Errorf("generating token: %w", err)
+	}
+	secret := tokenPrefix + base64.RawURLEncoding.EncodeToString(b)
+
+	token := Token{
+		ID:      uuid.New().String(),
+		Name:    name,
+		Scope:   scope,
+		Created: time.Now(),
+	}
+
+	_, err = db.Exec(
+		"insert into tokens(id, name, hash, scope, created_at) values(?, ?, ?, ?, ?)",
+		token.ID, token.Name, hash(secret), string(token.Scope), token.Created.Unix(),
+	)
+	if err != nil {
+		return Token{}, "", fmt.Errorf("inserting token: %w", err)
+	}
+
+	return token, secret, nil
+}
+
+// List returns every token, oldest first
+func List(db *sql.DB) ([]Token, error) {
+	rows, err := db.Query("select id, name, scope, created_at, last_used_at from tokens order by created_at")
+	if err != nil {
+		return nil, fmt.Errorf("querying tokens: %w", err)
+	}
+	defer rows.Close()
+
+	tokens := []Token{}
+	for rows.Next() {
+		var t Token
+		var scope string
+		var created, lastUsed int64
+		err = rows.Scan(&t.ID, &t.Name, &scope, &created, &lastUsed)
+		if err != nil {
+			return nil, fmt.Errorf("scanning row: %w", err)
+		}
+
+		t.Scope = Scope(scope)
+		t.Created = time.Unix(created, 0)
+		if lastUsed != 0 {
+			lu := time.Unix(lastUsed, 0)
+			t.LastUsed = &lu
+		}
+		tokens = append(tokens, t)
+	}
+	err = rows.Err()
+	if err != nil {
+		return nil, fmt.Errorf("iterating over token query results: %w", err)
+	}
+
+	return tokens, nil
+}
+
+// Revoke deletes a token by id or name, returning sql.ErrNoRows if there is
+// no such token. Names aren't unique, so revoking by a shared name is refused.
+func Revoke(db *sql.DB, idOrName string) error {
+	var count int
+	err := db.QueryRow("select count(*) from tokens where name = ? and id != ?", idOrName, idOrName).Scan(&count)
+	if err != nil {
+		return fmt.Errorf("counting tokens: %w", err)
+	}
+	if count > 1 {
+		return fmt.Errorf("%d tokens are named %s, revoke one by id", count, idOrName)
+	}
+
+	res, err := db.Exec("delete from tokens where id = ? or name = ?", idOrName, idOrName)
+	if err != nil {
+		return fmt.Errorf("deleting token: %w", err)
+	}
+
+	n, err := res.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("counting deleted tokens: %w", err)
+	}
+	if n == 0 {
+		return sql.ErrNoRows
+	}
+
+	return nil
+}
+
+// Count returns how many tokens there are
+func Count(db *sql.DB) (int, error) {
+	var count int
+	err := db.QueryRow("select count(*) from tokens").Scan(&count)
+	if err != nil {
+		return 0, fmt.Errorf("counting tokens: %w", err)
+	}
+	return count, nil
+}
+
+// Authenticate looks up the token with a secret and notes that it was used,
+// to within lastUsedResolution
+func Authenticate(db *sql.DB, secret string) (Token, error) {
+	if !strings.HasPrefix(secret, tokenPrefix) {
+		return Token{}, ErrInvalidToken
+	}
+
+	var t Token
+	var scope string
+	var created, lastUsed int64
+	err := db.QueryRow("select id, name, scope, created_at, last_used_at from tokens where hash = ?", hash(secret)).Scan(&t.ID, &t.Name, &scope, &created, &lastUsed)
+	if errors.Is(err, sql.ErrNoRows) {
+		return Token{}, ErrInvalidToken
+	}
+	if err != nil {
+		return Token{}, fmt.Errorf("querying token: %w", err)
+	}
+	t.Scope = Scope(scope)
+	t.Created = time.Unix(created, 0)
+
+	now := time.Now()
+	last := time.Unix(lastUsed, 0)
+	if lastUsed != 0 && now.Sub(last) < lastUsedResolution {
+		t.LastUsed = &last
+		return t, nil
+	}
+
+	_, err = db.Exec("update tokens set last_used_at = ? where id = ?", now.Unix(), t.ID)
+	if err != nil {
+		return Token{}, fmt.Errorf("updating token last use: %w", err)
+	}
+	t.LastUsed = &now
+
+	return t, nil
+}
+
+// FromRequest returns the bearer token in a request's Authorization header
+func FromRequest(ctx *fasthttp.RequestCtx) string {
+	header := string(ctx.Request.Header.Peek(fasthttp.HeaderAuthorization))
+	if len(header) > len("Bearer ") && strings.EqualFold(header[:len("Bearer ")], "Bearer ") {
+		return strings.TrimSpace(header[len("Bearer "):])
+	}
+
+	return ""
+}
+
+// FromQuery returns the token in a request's access_token query param. Only
+// accept it where a header can't be set, like for EventSource, since urls end
+// up in logs and browser history.
+func FromQuery(ctx *fasthttp.RequestCtx) string {
+	return string(ctx.QueryArgs().Peek("access_token"))
+}
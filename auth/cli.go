@@ -0,0 +1,86 @@
+package auth
+
+import (
+	"database/sql"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"text/tabwriter"
+	"time"
+)
+
+const usage = `usage:
+  gowaker token create --name NAME [--scope read|admin]
+  gowaker token list
+  gowaker token revoke ID|NAME`
+
+// Command runs the token subcommand with the args after "token"
+func Command(db *sql.DB, args []string, out io.Writer) error {
+	if len(args) == 0 {
+		return errors.New(usage)
+	}
+
+	switch args[0] {
+	case "create":
+		fs := flag.NewFlagSet("token create", flag.ContinueOnError)
+		fs.SetOutput(out)
+		name := fs.String("name", "", "What the token is for, e.g. phone.")
+		scope := fs.String("scope", string(ScopeAdmin), "read or admin.")
+		err := fs.Parse(args[1:])
+		if err != nil {
+			return err
+		}
+		if *name == "" {
+			return errors.New("--name is required")
+		}
+
+		s, err := ParseScope(*scope)
+		if err != nil {
+			return err
+		}
+
+		token, secret, err := Create(db, *name, s)
+		if err != nil {
+			return err
+		}
+
+		fmt.Fprintf(out, "created %s token %s (%s). It won't be shown again:\n%s\n", token.Scope, token.Name, token.ID, secret)
+		return nil
+
+	case "list":
+		tokens, err := List(db)
+		if err != nil {
+			return err
+		}
+
+		w := tabwriter.NewWriter(out, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(w, "ID\tNAME\tSCOPE\tCREATED\tLAST USED")
+		for _, t := range tokens {
+			lastUsed := "never"
+			if t.LastUsed != nil {
+				lastUsed = t.LastUsed.Format(time.RFC3339)
+			}
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", t.ID, t.Name, t.Scope, t.Created.Format(time.RFC3339), lastUsed)
+		}
+		return w.Flush()
+
+	case "revoke":
+		if len(args) != 2 {
+			return errors.New(usage)
+		}
+
+		err := Revoke(db, args[1])
+		if errors.Is(err, sql.ErrNoRows) {
+			return fmt.Errorf("no token %s", args[1])
+		}
+		if err != nil {
+			return err
+		}
+
+		fmt.Fprintf(out, "revoked %s\n", args[1])
+		return nil
+	}
+
+	return errors.New(usage)
+}
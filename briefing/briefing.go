@@ -0,0 +1,407 @@
+package briefing
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"strings"
+	"text/template"
+	"time"
+
+	log "github.com/golang/glog"
+	upstreamspotify "github.com/jchorl/spotify"
+	"github.com/valyala/fasthttp"
+
+	"github.com/jchorl/gowaker/plugin"
+	"github.com/jchorl/gowaker/requestcontext"
+	"github.com/jchorl/gowaker/spotify"
+	"github.com/jchorl/gowaker/ssml"
+)
+
+const templateKey = "template"
+
+// pluginTimeout is how long each plugin gets to generate its part of the briefing
+const pluginTimeout = 30 * time.Second
+
+// DefaultTemplate reads out every plugin in order, then signs off
+const DefaultTemplate = `{{range .Segments}}{{.SSML}}{{pause "1s"}}{{end}}Have a great day!`
+
+// Data is what briefing templates are executed with
+type Data struct {
+	Alarm   Alarm
+	Weekday string
+	Track   Track
+
+	// Plugins holds each plugin's segment by plugin name
+	Plugins map[string]Segment
+
+	// Segments holds each plugin's segment in the order the plugins run
+	Segments []Segment
+}
+
+// Alarm describes the alarm that is going off
+type Alarm struct {
+	ID    string
+	Label string
+}
+
+// Track is the song that wakes the user up
+type Track struct {
+	Name   string
+	Artist string
+}
+
+// Segment is a single plugin's part of the briefing
+type Segment struct {
+	Name string
+	Text string
+
+	// SSML is the segment as an SSML fragment, or an apology if the plugin failed
+	SSML string
+
+	// Data is the plugin's structured data
+	Data interface{}
+
+	Failed bool
+}
+
+var funcs = template.FuncMap{
+	"escape": ssml.Escape,
+	"pause": func(d string) (string, error) {
+		duration, err := time.ParseDuration(d)
+		if err != nil {
+			return "", err
+		}
+		return ssml.Break(duration), nil
+	},
+	"emphasis": ssml.Emphasis,
+	"time":     ssml.Time,
+	"date":     ssml.Date,
+}
+
+// Generate runs the plugins for an alarm and renders the briefing as a single
+// SSML document. If the saved template fails, the default one is used instead.
+// The data the briefing was rendered from is returned for Spoken.
+func Generate(ctx *fasthttp.RequestCtx, run plugin.Run, track Track) (string, Data, error) {
+	data, err := collect(ctx, run, track)
+	if err != nil {
+		return "", Data{}, err
+	}
+
+	tmpl, err := getTemplate(ctx)
+	if err != nil {
+		log.Errorf("getting briefing template, using the default: %s", err)
+		tmpl = DefaultTemplate
+	}
+
+	doc, err := Render(tmpl, data)
+	if err != nil {
+		log.Errorf("rendering briefing template, using the default: %s", err)
+		doc, err = Render(DefaultTemplate, data)
+		if err != nil {
+			return "", Data{}, err
+		}
+	}
+
+	return doc, data, nil
+}
+
+// Spoken tells the plugins that acknowledge their output that the briefing
+// rendered from data has been read out
+func Spoken(ctx *fasthttp.RequestCtx, run plugin.Run, data Data) {
+	registry := requestcontext.Plugins(ctx)
+	for _, segment := range data.Segments {
+		if segment.Failed {
+			continue
+		}
+
+		p, err := registry.Plugin(segment.Name)
+		if err != nil {
+			continue
+		}
+		acknowledger, ok := p.(plugin.Acknowledger)
+		if !ok {
+			continue
+		}
+
+		output := plugin.Output{Text: segment.Text, SSML: segment.SSML, Data: segment.Data}
+		err = acknowledger.Spoken(context.Background(), run, output)
+		if err != nil {
+			log.Errorf("acknowledging output of plugin %s: %s", segment.Name, err)
+		}
+	}
+}
+
+// TrackFromSong returns the briefing track for a spotify song
+func TrackFromSong(song *upstreamspotify.FullTrack) Track {
+	track := Track{Name: song.Name}
+	if len(song.Artists) > 0 {
+		track.Artist = song.Artists[0].Name
+	}
+
+	return track
+}
+
+// Render executes a briefing template and checks that the result is a well
+// formed SSML document
+func Render(tmpl string, data Data) (string, error) {
+	t, err := parse(tmpl)
+	if err != nil {
+		return "", err
+	}
+
+	var b strings.Builder
+	err = t.Execute(&b, data)
+	if err != nil {
+		return "", fmt.Errorf("executing template: %w", err)
+	}
+
+	doc := ssml.Speak(0, b.String())
+	err = checkWellFormed(doc)
+	if err != nil {
+		return "", fmt.Errorf("checking rendered ssml: %w", err)
+	}
+
+	return doc, nil
+}
+
+func parse(tmpl string) (*template.Template, error) {
+	t, err := template.New("briefing").Funcs(funcs).Parse(tmpl)
+	if err != nil {
+		return nil, fmt.Errorf("parsing template: %w", err)
+	}
+
+	return t, nil
+}
+
+func checkWellFormed(doc string) error {
+	decoder := xml.NewDecoder(strings.NewReader(doc))
+	for {
+		_, err := decoder.Token()
+		if err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+	}
+}
+
+func collect(ctx *fasthttp.RequestCtx, run plugin.Run, track Track) (Data, error) {
+	plugins, err := requestcontext.Plugins(ctx).Select(run.Plugins)
+	if err != nil {
+		return Data{}, fmt.Errorf("selecting plugins: %w", err)
+	}
+
+	data := Data{
+		Alarm:   Alarm{ID: run.AlarmID, Label: run.Label},
+		Weekday: run.Scheduled.In(run.Location).Weekday().String(),
+		Track:   track,
+		Plugins: map[string]Segment{},
+	}
+
+	for _, result := range plugin.RunAll(context.Background(), run, plugins, pluginTimeout) {
+		segment := Segment{
+			Name: result.Name,
+			Text: result.Output.Text,
+			SSML: result.Output.SSMLFragment(),
+			Data: result.Output.Data,
+		}
+
+		// failing plugins are skipped with an apology instead of failing the whole briefing
+		if result.Err != nil {
+			log.Errorf("running plugin %s: %s", result.Name, result.Err)
+			segment.Text = fmt.Sprintf("Sorry, the %s plugin didn't respond.", result.Name)
+			segment.SSML = ssml.Escape(segment.Text)
+			segment.Failed = true
+		}
+
+		// plugins like exec and webhook pass ssml through from elsewhere, and one
+		// bad fragment would otherwise fail the default template as well
+		err = checkWellFormed(ssml.Speak(0, segment.SSML))
+		if err != nil {
+			log.Errorf("plugin %s returned malformed ssml, reading its text instead: %s", result.Name, err)
+			if segment.Text == "" {
+				segment.Text = ssml.ToText(segment.SSML)
+			}
+			segment.SSML = ssml.Escape(segment.Text)
+		}
+
+		data.Plugins[segment.Name] = segment
+		data.Segments = append(data.Segments, segment)
+	}
+
+	return data, nil
+}
+
+type templateBody struct {
+	Template string `json:"template"`
+}
+
+func HandlerGetTemplate(ctx *fasthttp.RequestCtx) {
+	tmpl, err := getTemplate(ctx)
+	if err != nil {
+		err = fmt.Errorf("getting briefing template: %w", err)
+		log.Error(err)
+		ctx.Error(err.Error(), fasthttp.StatusInternalServerError)
+		return
+	}
+
+	ctx.Response.SetStatusCode(fasthttp.StatusOK)
+	json.NewEncoder(ctx).Encode(templateBody{Template: tmpl})
+}
+
+func HandlerSetTemplate(ctx *fasthttp.RequestCtx) {
+	db := requestcontext.DB(ctx)
+
+	body := templateBody{}
+	err := json.Unmarshal(ctx.Request.Body(), &body)
+	if err != nil {
+		err = fmt.Errorf("decoding body: %w", err)
+		log.Error(err)
+		ctx.Error(err.Error(), fasthttp.StatusBadRequest)
+		return
+	}
+
+	_, err = parse(body.Template)
+	if err != nil {
+		log.Error(err)
+		ctx.Error(err.Error(), fasthttp.StatusBadRequest)
+		return
+	}
+
+	stmt, err := db.Prepare(`
+		insert into briefing_config(key, value) values(?, ?) on conflict(key) do update set value = ?
+	`,
+	)
+	if err != nil {
+		err = fmt.Errorf("preparing briefing template upsert stmt: %w", err)
+		log.Error(err)
+		ctx.Error(err.Error(), fasthttp.StatusInternalServerError)
+		return
+	}
+	defer stmt.Close()
+	_, err = stmt.Exec(templateKey, body.Template, body.Template)
+	if err != nil {
+		err = fmt.Errorf("executing briefing template upsert stmt: %w", err)
+		log.Error(err)
+		ctx.Error(err.Error(), fasthttp.StatusInternalServerError)
+		return
+	}
+
+	ctx.Response.SetStatusCode(fasthttp.StatusOK)
+	json.NewEncoder(ctx).Encode(body)
+}
+
+type previewRequest struct {
+	// Template defaults to the saved template
+	Template string   `json:"template"`
+	Label    string   `json:"label"`
+	Timezone string   `json:"timezone"`
+	Plugins  []string `json:"plugins"`
+}
+
+type previewResponse struct {
+	SSML string `json:"ssml"`
+	Text string `json:"text"`
+}
+
+// HandlerPreview renders a template with live plugin data, without saving it
+func HandlerPreview(ctx *fasthttp.RequestCtx) {
+	req := previewRequest{}
+	if len(ctx.Request.Body()) > 0 {
+		err := json.Unmarshal(ctx.Request.Body(), &req)
+		if err != nil {
+			err = fmt.Errorf("decoding body: %w", err)
+			log.Error(err)
+			ctx.Error(err.Error(), fasthttp.StatusBadRequest)
+			return
+		}
+	}
+
+	if req.Template == "" {
+		tmpl, err := getTemplate(ctx)
+		if err != nil {
+			err = fmt.Errorf("getting briefing template: %w", err)
+			log.Error(err)
+			ctx.Error(err.Error(), fasthttp.StatusInternalServerError)
+			return
+		}
+		req.Template = tmpl
+	}
+
+	loc := time.Local
+	if req.Timezone != "" {
+		var err error
+		loc, err = time.LoadLocation(req.Timezone)
+		if err != nil {
+			err = fmt.Errorf("loading timezone: %w", err)
+			log.Error(err)
+			ctx.Error(err.Error(), fasthttp.StatusBadRequest)
+			return
+		}
+	}
+
+	// catch template syntax errors before running every plugin
+	_, err := parse(req.Template)
+	if err != nil {
+		log.Error(err)
+		ctx.Error(err.Error(), fasthttp.StatusBadRequest)
+		return
+	}
+
+	var track Track
+	song, err := spotify.GetNextWakeupSong(ctx)
+	if err != nil {
+		// the preview is still useful without a track
+		log.Errorf("getting wakeup song for preview: %s", err)
+	} else {
+		track = TrackFromSong(song)
+	}
+
+	run := plugin.Run{
+		Label:     req.Label,
+		Scheduled: time.Now(),
+		Location:  loc,
+		Plugins:   req.Plugins,
+		Preview:   true,
+	}
+	data, err := collect(ctx, run, track)
+	if err != nil {
+		log.Error(err)
+		ctx.Error(err.Error(), fasthttp.StatusBadRequest)
+		return
+	}
+
+	doc, err := Render(req.Template, data)
+	if err != nil {
+		log.Error(err)
+		ctx.Error(err.Error(), fasthttp.StatusBadRequest)
+		return
+	}
+
+	ctx.Response.SetStatusCode(fasthttp.StatusOK)
+	json.NewEncoder(ctx).Encode(previewResponse{SSML: doc, Text: ssml.ToText(doc)})
+}
+
+func getTemplate(ctx *fasthttp.RequestCtx) (string, error) {
+	db := requestcontext.DB(ctx)
+
+	stmt, err := db.Prepare("select value from briefing_config where key = ?")
+	if err != nil {
+		return "", fmt.Errorf("preparing briefing template select stmt: %w", err)
+	}
+	defer stmt.Close()
+
+	var tmpl string
+	err = stmt.QueryRow(templateKey).Scan(&tmpl)
+	if err == sql.ErrNoRows {
+		return DefaultTemplate, nil
+	} else if err != nil {
+		return "", fmt.Errorf("querying/scanning briefing template: %w", err)
+	}
+
+	return tmpl, nil
+}
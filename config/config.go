@@ -4,9 +4,12 @@ const (
 	// SpotifyDeviceName is the name of the device to play spotify songs on
 	SpotifyDeviceName = "raspotify (orangepizero)"
 
-	// OWMID is an OpenWeatherMap place ID to get weather for
+	// OWMID is the default OpenWeatherMap place ID to get weather for, until
+	// a location is configured through the weather plugin's settings
 	OWMID = 5391959
 
-	// GoogleCalendars is a csv of calendar names to play events of
+	// GoogleCalendars is a csv of the names of the google calendars that were
+	// read before calendars could be picked at runtime. They seed the calendar
+	// plugin's settings on first run.
 	GoogleCalendars = "Birthdays,On Call Schedule for Josh Chorlton,Default,Toronto Maple Leafs,choo@stripe.com"
 )
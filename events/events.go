@@ -0,0 +1,212 @@
+// Package events fans alarm lifecycle events out to whoever is listening,
+// like the MQTT bridge and the /events stream
+package events
+
+import (
+	"sync"
+	"time"
+
+	log "github.com/golang/glog"
+)
+
+// Type is the kind of an event
+type Type string
+
+const (
+	// Scheduled is published when an alarm is created
+	Scheduled Type = "scheduled"
+
+	// Updated is published when an alarm is edited
+	Updated Type = "updated"
+
+	// Deleted is published when an alarm is deleted
+	Deleted Type = "deleted"
+
+	// Firing is published when an alarm goes off
+	Firing Type = "firing"
+
+	// Snoozed is published when a firing alarm is snoozed
+	Snoozed Type = "snoozed"
+
+	// Dismissed is published when a firing alarm is dismissed
+	Dismissed Type = "dismissed"
+
+	// Finished is published when an alarm run completes on its own
+	Finished Type = "finished"
+
+	// Failed is published when an alarm run fails
+	Failed Type = "failed"
+
+	// Step is published as an alarm run moves on to its next step, e.g.
+	// playing_song, with the step in the data
+	Step Type = "step"
+
+	// Track is published when a track starts playing, with the track as the data
+	Track Type = "track"
+
+	// Volume is published when the volume is set, with the percent in the data
+	Volume Type = "volume"
+)
+
+// Types lists every type of event
+var Types = []Type{Scheduled, Updated, Deleted, Firing, Snoozed, Dismissed, Finished, Failed, Step, Track, Volume}
+
+// Lifecycle lists the types of events about the alarms themselves, leaving out
+// the busier Step, Track and Volume events about the progress of a run
+var Lifecycle = []Type{Scheduled, Updated, Deleted, Firing, Snoozed, Dismissed, Finished, Failed}
+
+// Known returns whether t is one of Types
+func Known(t Type) bool {
+	for _, known := range Types {
+		if t == known {
+			return true
+		}
+	}
+	return false
+}
+
+// Event is something that happened to an alarm
+type Event struct {
+	Type    Type      `json:"type"`
+	AlarmID string    `json:"alarm_id"`
+	Label   string    `json:"label"`
+	Time    time.Time `json:"time"`
+
+	// Data depends on the type, e.g. the error for failed events
+	Data interface{} `json:"data,omitempty"`
+}
+
+// Bus delivers published events to every subscriber
+type Bus struct {
+	mu     sync.Mutex
+	subs   map[int]chan Event
+	queues map[int]*queue
+	nextID int
+}
+
+// NewBus creates a bus with no subscribers
+func NewBus() *Bus {
+	return &Bus{subs: map[int]chan Event{}, queues: map[int]*queue{}}
+}
+
+// Publish sends an event to every subscriber. It never blocks: subscribers
+// that have fallen behind miss the event, unless they subscribed with
+// SubscribeQueued.
+func (b *Bus) Publish(e Event) {
+	if e.Time.IsZero() {
+		e.Time = time.Now()
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	for id, ch := range b.subs {
+		select {
+		case ch <- e:
+		default:
+			log.Errorf("event subscriber %d is full, dropping %s event", id, e.Type)
+		}
+	}
+	for _, q := range b.queues {
+		q.push(e)
+	}
+}
+
+// Subscribe returns a channel of events, buffered to size, and a function
+// that unsubscribes and closes the channel
+func (b *Bus) Subscribe(size int) (<-chan Event, func()) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	id := b.nextID
+	b.nextID++
+	ch := make(chan Event, size)
+	b.subs[id] = ch
+
+	var once sync.Once
+	return ch, func() {
+		once.Do(func() {
+			b.mu.Lock()
+			defer b.mu.Unlock()
+
+			delete(b.subs, id)
+			close(ch)
+		})
+	}
+}
+
+// SubscribeQueued returns a channel of events and a function that
+// unsubscribes and closes the channel. Unlike with Subscribe, events are
+// queued for as long as the subscriber is behind, so none are missed.
+func (b *Bus) SubscribeQueued() (<-chan Event, func()) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	id := b.nextID
+	b.nextID++
+	q := &queue{ready: make(chan struct{}, 1), done: make(chan struct{})}
+	b.queues[id] = q
+
+	ch := make(chan Event)
+	go q.forward(ch)
+
+	var once sync.Once
+	return ch, func() {
+		once.Do(func() {
+			b.mu.Lock()
+			defer b.mu.Unlock()
+
+			delete(b.queues, id)
+			close(q.done)
+		})
+	}
+}
+
+// queue holds the events that a queued subscriber hasn't received yet
+type queue struct {
+	mu     sync.Mutex
+	events []Event
+
+	// ready is signalled when events are pushed, and done is closed when
+	// the subscriber unsubscribes
+	ready chan struct{}
+	done  chan struct{}
+}
+
+func (q *queue) push(e Event) {
+	q.mu.Lock()
+	q.events = append(q.events, e)
+	q.mu.Unlock()
+
+	select {
+	case q.ready <- struct{}{}:
+	default:
+		// already signalled
+	}
+}
+
+// forward sends queued events to ch in order, closing it once unsubscribed
+func (q *queue) forward(ch chan<- Event) {
+	defer close(ch)
+
+	for {
+		select {
+		case <-q.ready:
+		case <-q.done:
+			return
+		}
+
+		q.mu.Lock()
+		events := q.events
+		q.events = nil
+		q.mu.Unlock()
+
+		for _, e := range events {
+			select {
+			case ch <- e:
+			case <-q.done:
+				return
+			}
+		}
+	}
+}
@@ -0,0 +1,55 @@
+package events
+
+import (
+	"testing"
+	"time"
+)
+
+func TestSubscribeDrops(t *testing.T) {
+	b := NewBus()
+	ch, unsubscribe := b.Subscribe(1)
+
+	b.Publish(Event{Type: Firing})
+	b.Publish(Event{Type: Finished})
+	unsubscribe()
+
+	var got []Type
+	for e := range ch {
+		got = append(got, e.Type)
+	}
+	if len(got) != 1 || got[0] != Firing {
+		t.Errorf("got %v, want only the event that fit in the buffer", got)
+	}
+}
+
+func TestSubscribeQueued(t *testing.T) {
+	b := NewBus()
+	ch, unsubscribe := b.SubscribeQueued()
+	defer unsubscribe()
+
+	const n = 1000
+	for i := 0; i < n; i++ {
+		b.Publish(Event{Type: Step, Data: i})
+	}
+
+	for i := 0; i < n; i++ {
+		select {
+		case e := <-ch:
+			if e.Data != i {
+				t.Fatalf("got event %v, want %d", e.Data, i)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("timed out waiting for event %d", i)
+		}
+	}
+
+	unsubscribe()
+	select {
+	case _, ok := <-ch:
+		if ok {
+			t.Error("got an event after unsubscribing")
+		}
+	case <-time.After(5 * time.Second):
+		t.Error("channel wasn't closed after unsubscribing")
+	}
+}
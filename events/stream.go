@@ -0,0 +1,80 @@
+package events
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	log "github.com/golang/glog"
+	"github.com/valyala/fasthttp"
+)
+
+// keepAlive is how often a comment is sent on an idle stream, so proxies
+// don't close it and disconnected clients are noticed
+const keepAlive = 15 * time.Second
+
+// HandlerStream streams events as server-sent events until the client goes
+// away. Each event's type is the SSE event name and its JSON is the data. The
+// types query param limits which are sent, e.g. ?types=step,track.
+func (b *Bus) HandlerStream(ctx *fasthttp.RequestCtx) {
+	wanted := map[Type]bool{}
+	if types := string(ctx.QueryArgs().Peek("types")); types != "" {
+		for _, t := range strings.Split(types, ",") {
+			if !Known(Type(t)) {
+				ctx.Error(fmt.Sprintf("unknown event type %q", t), fasthttp.StatusBadRequest)
+				return
+			}
+			wanted[Type(t)] = true
+		}
+	}
+
+	ch, unsubscribe := b.Subscribe(64)
+
+	ctx.SetContentType("text/event-stream")
+	ctx.Response.Header.Set("Cache-Control", "no-cache")
+	// stop nginx and friends from buffering the stream
+	ctx.Response.Header.Set("X-Accel-Buffering", "no")
+	ctx.SetBodyStreamWriter(func(w *bufio.Writer) {
+		defer unsubscribe()
+
+		ticker := time.NewTicker(keepAlive)
+		defer ticker.Stop()
+
+		// let the client know the stream is up before anything happens
+		fmt.Fprint(w, ": connected\n\n")
+		err := w.Flush()
+		for err == nil {
+			select {
+			case e := <-ch:
+				if len(wanted) > 0 && !wanted[e.Type] {
+					continue
+				}
+				err = writeEvent(w, e)
+			case <-ticker.C:
+				_, err = fmt.Fprint(w, ": keep-alive\n\n")
+				if err == nil {
+					err = w.Flush()
+				}
+			}
+		}
+
+		log.Infof("event stream closed: %s", err)
+	})
+}
+
+func writeEvent(w *bufio.Writer, e Event) error {
+	data, err := json.Marshal(e)
+	if err != nil {
+		log.Errorf("encoding %s event: %s", e.Type, err)
+		return nil
+	}
+
+	// json.Marshal escapes newlines, so the data fits on one line
+	_, err = fmt.Fprintf(w, "event: %s\ndata: %s\n\n", e.Type, data)
+	if err != nil {
+		return err
+	}
+	return w.Flush()
+}
@@ -1,10 +1,10 @@
 module github.com/jchorl/gowaker
 
-go 1.12
+go 1.16
 
 require (
 	cloud.google.com/go v0.49.0
-	github.com/briandowns/openweathermap v0.13.0
+	github.com/eclipse/paho.mqtt.golang v1.2.0
 	github.com/faiface/beep v1.0.2
 	github.com/fasthttp/router v0.5.0
 	github.com/golang/glog v0.0.0-20160126235308-23def4e6c14b
@@ -16,6 +16,7 @@ require (
 	github.com/jchorl/watchdog v0.0.0-20190211034837-3b422e85f408
 	github.com/mattn/go-sqlite3 v1.11.0
 	github.com/pkg/errors v0.8.1
+	github.com/teambition/rrule-go v1.8.2
 	github.com/valyala/fasthttp v1.4.0
 	go.opencensus.io v0.22.2 // indirect
 	golang.org/x/net v0.0.0-20191125084936-ffdde1057850 // indirect
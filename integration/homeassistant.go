@@ -0,0 +1,185 @@
+package integration
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	log "github.com/golang/glog"
+	"github.com/valyala/fasthttp"
+
+	"github.com/jchorl/gowaker/requestcontext"
+)
+
+const (
+	homeAssistantURLKey   = "home_assistant_url"
+	homeAssistantTokenKey = "home_assistant_token"
+)
+
+// maskedToken stands in for the token when settings are read back
+const maskedToken = "********"
+
+// HomeAssistant is the Home Assistant instance that service steps call
+type HomeAssistant struct {
+	// URL is the base URL, e.g. http://homeassistant.local:8123
+	URL string `json:"url"`
+
+	// Token is a long-lived access token
+	Token string `json:"token"`
+}
+
+func (ha HomeAssistant) request(service string, data json.RawMessage) (*http.Request, error) {
+	if ha.URL == "" {
+		return nil, errors.New("home assistant isn't configured")
+	}
+
+	if len(data) == 0 {
+		data = json.RawMessage("{}")
+	}
+
+	sp := strings.SplitN(service, ".", 2)
+	u := fmt.Sprintf("%s/api/services/%s/%s", strings.TrimRight(ha.URL, "/"), sp[0], sp[1])
+	req, err := http.NewRequest(http.MethodPost, u, bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("creating request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer "+ha.Token)
+
+	return req, nil
+}
+
+func HandlerGetHomeAssistant(ctx *fasthttp.RequestCtx) {
+	ha, err := getHomeAssistant(ctx)
+	if err != nil {
+		err = fmt.Errorf("getting home assistant settings: %w", err)
+		log.Error(err)
+		ctx.Error(err.Error(), fasthttp.StatusInternalServerError)
+		return
+	}
+
+	if ha.Token != "" {
+		ha.Token = maskedToken
+	}
+
+	ctx.Response.SetStatusCode(fasthttp.StatusOK)
+	json.NewEncoder(ctx).Encode(ha)
+}
+
+func HandlerSetHomeAssistant(ctx *fasthttp.RequestCtx) {
+	ha, err := getHomeAssistant(ctx)
+	if err != nil {
+		err = fmt.Errorf("getting home assistant settings: %w", err)
+		log.Error(err)
+		ctx.Error(err.Error(), fasthttp.StatusInternalServerError)
+		return
+	}
+
+	// decode over the current settings so partial updates work
+	token := ha.Token
+	err = json.Unmarshal(ctx.Request.Body(), &ha)
+	if err != nil {
+		err = fmt.Errorf("decoding body: %w", err)
+		log.Error(err)
+		ctx.Error(err.Error(), fasthttp.StatusBadRequest)
+		return
+	}
+
+	// settings that were read back still have the masked token
+	if ha.Token == maskedToken {
+		ha.Token = token
+	}
+
+	if ha.URL != "" && !strings.HasPrefix(ha.URL, "http://") && !strings.HasPrefix(ha.URL, "https://") {
+		ctx.Error("url must be http(s)", fasthttp.StatusBadRequest)
+		return
+	}
+
+	err = setHomeAssistant(ctx, ha)
+	if err != nil {
+		err = fmt.Errorf("saving home assistant settings: %w", err)
+		log.Error(err)
+		ctx.Error(err.Error(), fasthttp.StatusInternalServerError)
+		return
+	}
+
+	if ha.Token != "" {
+		ha.Token = maskedToken
+	}
+
+	ctx.Response.SetStatusCode(fasthttp.StatusOK)
+	json.NewEncoder(ctx).Encode(ha)
+}
+
+func getHomeAssistant(ctx *fasthttp.RequestCtx) (HomeAssistant, error) {
+	db := requestcontext.DB(ctx)
+
+	rows, err := db.Query("select key, value from integration_config")
+	if err != nil {
+		return HomeAssistant{}, fmt.Errorf("querying integration config: %w", err)
+	}
+	defer rows.Close()
+
+	ha := HomeAssistant{}
+	for rows.Next() {
+		var key, value string
+		err = rows.Scan(&key, &value)
+		if err != nil {
+			return HomeAssistant{}, fmt.Errorf("scanning row: %w", err)
+		}
+
+		switch key {
+		case homeAssistantURLKey:
+			ha.URL = value
+		case homeAssistantTokenKey:
+			ha.Token = value
+		}
+	}
+	err = rows.Err()
+	if err != nil {
+		return HomeAssistant{}, fmt.Errorf("iterating over integration config query results: %w", err)
+	}
+
+	return ha, nil
+}
+
+func setHomeAssistant(ctx *fasthttp.RequestCtx, ha HomeAssistant) error {
+	db := requestcontext.DB(ctx)
+
+	tx, err := db.Begin()
+	if err != nil {
+		return fmt.Errorf("beginning tx: %w", err)
+	}
+
+	stmt, err := tx.Prepare(`
+		insert into integration_config(key, value) values(?, ?) on conflict(key) do update set value = ?
+	`,
+	)
+	if err != nil {
+		tx.Rollback()
+		return fmt.Errorf("preparing integration config upsert stmt: %w", err)
+	}
+	defer stmt.Close()
+
+	values := map[string]string{
+		homeAssistantURLKey:   ha.URL,
+		homeAssistantTokenKey: ha.Token,
+	}
+	for key, value := range values {
+		_, err = stmt.Exec(key, value, value)
+		if err != nil {
+			tx.Rollback()
+			return fmt.Errorf("executing integration config upsert stmt for %s: %w", key, err)
+		}
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return fmt.Errorf("committing tx: %w", err)
+	}
+
+	return nil
+}
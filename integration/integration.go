@@ -0,0 +1,231 @@
+// Package integration calls out to home automation, like Home Assistant, at
+// points of an alarm run
+package integration
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+
+	log "github.com/golang/glog"
+	"github.com/valyala/fasthttp"
+
+	"github.com/jchorl/gowaker/plugin"
+)
+
+// Point is a point in an alarm run that steps can be attached to
+type Point string
+
+const (
+	// PreAlarm steps run before the alarm goes off, by their offset
+	PreAlarm Point = "pre_alarm"
+
+	// SongStart steps run as the wakeup song starts playing
+	SongStart Point = "song_start"
+
+	// BriefingStart steps run as the briefing starts being spoken
+	BriefingStart Point = "briefing_start"
+
+	// Dismiss steps run once the alarm is over
+	Dismiss Point = "dismiss"
+)
+
+// MaxLead is the furthest ahead of an alarm that a pre-alarm step can run
+const MaxLead = 12 * time.Hour
+
+const stepTimeout = 10 * time.Second
+
+// Step is a single call made at a point of an alarm run. It either calls a
+// Home Assistant service, or makes an arbitrary HTTP request.
+type Step struct {
+	Point Point `json:"point"`
+
+	// Offset is how long before the alarm a pre-alarm step runs, e.g. "10m"
+	Offset string `json:"offset,omitempty"`
+
+	// Service is a Home Assistant service like light.turn_on, called with
+	// Data on the configured Home Assistant
+	Service string          `json:"service,omitempty"`
+	Data    json.RawMessage `json:"data,omitempty"`
+
+	// URL, Method, Headers and Body describe any other HTTP request. Method
+	// defaults to POST.
+	URL     string            `json:"url,omitempty"`
+	Method  string            `json:"method,omitempty"`
+	Headers map[string]string `json:"headers,omitempty"`
+	Body    json.RawMessage   `json:"body,omitempty"`
+}
+
+// Validate checks that a step can be run
+func (s Step) Validate() error {
+	switch s.Point {
+	case PreAlarm, SongStart, BriefingStart, Dismiss:
+	default:
+		return fmt.Errorf("unknown point %q", s.Point)
+	}
+
+	if s.Offset != "" {
+		if s.Point != PreAlarm {
+			return errors.New("only pre_alarm steps have an offset")
+		}
+		d, err := time.ParseDuration(s.Offset)
+		if err != nil {
+			return fmt.Errorf("parsing offset: %w", err)
+		}
+		if d < 0 || d > MaxLead {
+			return fmt.Errorf("offset must be between 0 and %s", MaxLead)
+		}
+	}
+
+	switch {
+	case s.Service != "" && s.URL != "":
+		return errors.New("a step has either a service or a url, not both")
+	case s.Service != "":
+		if len(strings.Split(s.Service, ".")) != 2 {
+			return fmt.Errorf("service %s should look like domain.service", s.Service)
+		}
+	case s.URL != "":
+		u, err := url.Parse(s.URL)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+			return errors.New("url must be http(s)")
+		}
+	default:
+		return errors.New("a step needs a service or a url")
+	}
+
+	return nil
+}
+
+func (s Step) offset() time.Duration {
+	d, _ := time.ParseDuration(s.Offset)
+	return d
+}
+
+// Lead returns how far ahead of the alarm the earliest pre-alarm step runs
+func Lead(steps []Step) time.Duration {
+	var lead time.Duration
+	for _, s := range steps {
+		if s.Point == PreAlarm && s.offset() > lead {
+			lead = s.offset()
+		}
+	}
+	return lead
+}
+
+// Fire runs the steps attached to point, in order. Failing steps are logged
+// so that they never hold up the alarm.
+func Fire(ctx *fasthttp.RequestCtx, steps []Step, point Point, run plugin.Run) {
+	var ha HomeAssistant
+	for _, s := range steps {
+		if s.Point != point {
+			continue
+		}
+
+		if s.Service != "" && ha.URL == "" {
+			var err error
+			ha, err = getHomeAssistant(ctx)
+			if err != nil {
+				log.Errorf("getting home assistant settings: %s", err)
+				continue
+			}
+		}
+
+		err := s.call(ha)
+		if err != nil {
+			log.Errorf("running %s step for alarm %s: %s", point, run.AlarmID, err)
+		}
+	}
+}
+
+// Countdown runs the pre-alarm steps as their offsets from alarmTime come up,
+// earliest first, then waits for alarmTime. It returns false if stop is
+// closed first, e.g. because the alarm was deleted.
+func Countdown(ctx *fasthttp.RequestCtx, steps []Step, alarmTime time.Time, run plugin.Run, stop <-chan struct{}) bool {
+	var pre []Step
+	for _, s := range steps {
+		if s.Point == PreAlarm {
+			pre = append(pre, s)
+		}
+	}
+	sort.SliceStable(pre, func(i, j int) bool {
+		return pre[i].offset() > pre[j].offset()
+	})
+
+	for _, s := range pre {
+		if !wait(time.Until(alarmTime.Add(-s.offset())), stop) {
+			return false
+		}
+		Fire(ctx, []Step{s}, PreAlarm, run)
+	}
+
+	return wait(time.Until(alarmTime), stop)
+}
+
+// wait sleeps for d, returning false if stop is closed first
+func wait(d time.Duration, stop <-chan struct{}) bool {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+		return true
+	case <-stop:
+		return false
+	}
+}
+
+func (s Step) call(ha HomeAssistant) error {
+	// the alarm's ctx isn't from a server, so it can't be used as a context.Context
+	ctx, cancel := context.WithTimeout(context.Background(), stepTimeout)
+	defer cancel()
+
+	var req *http.Request
+	var err error
+	if s.Service != "" {
+		req, err = ha.request(s.Service, s.Data)
+	} else {
+		req, err = s.request()
+	}
+	if err != nil {
+		return err
+	}
+
+	resp, err := http.DefaultClient.Do(req.WithContext(ctx))
+	if err != nil {
+		return fmt.Errorf("requesting %s: %w", req.URL.Host, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("requesting %s: unexpected status %s", req.URL.Host, resp.Status)
+	}
+
+	return nil
+}
+
+func (s Step) request() (*http.Request, error) {
+	method := strings.ToUpper(s.Method)
+	if method == "" {
+		method = http.MethodPost
+	}
+
+	req, err := http.NewRequest(method, s.URL, bytes.NewReader(s.Body))
+	if err != nil {
+		return nil, fmt.Errorf("creating request: %w", err)
+	}
+	if len(s.Body) > 0 {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	for k, v := range s.Headers {
+		req.Header.Set(k, v)
+	}
+
+	return req, nil
+}
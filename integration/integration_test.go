@@ -0,0 +1,154 @@
+package integration
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/valyala/fasthttp"
+
+	"github.com/jchorl/gowaker/plugin"
+)
+
+func TestStepValidate(t *testing.T) {
+	tests := []struct {
+		name string
+		step Step
+		want string
+	}{
+		{name: "service", step: Step{Point: SongStart, Service: "light.turn_on"}},
+		{name: "url", step: Step{Point: PreAlarm, Offset: "10m", URL: "https://example.com/hook"}},
+		{name: "max offset", step: Step{Point: PreAlarm, Offset: "12h", URL: "https://example.com/hook"}},
+		{name: "unknown point", step: Step{Point: "lunch", URL: "https://example.com/hook"}, want: "unknown point"},
+		{name: "offset on another point", step: Step{Point: Dismiss, Offset: "10m", URL: "https://example.com/hook"}, want: "only pre_alarm steps"},
+		{name: "bad offset", step: Step{Point: PreAlarm, Offset: "soon", URL: "https://example.com/hook"}, want: "parsing offset"},
+		{name: "negative offset", step: Step{Point: PreAlarm, Offset: "-5m", URL: "https://example.com/hook"}, want: "offset must be between"},
+		{name: "offset over max", step: Step{Point: PreAlarm, Offset: "13h", URL: "https://example.com/hook"}, want: "offset must be between"},
+		{name: "service and url", step: Step{Point: Dismiss, Service: "light.turn_off", URL: "https://example.com/hook"}, want: "not both"},
+		{name: "neither", step: Step{Point: Dismiss}, want: "needs a service or a url"},
+		{name: "bad service", step: Step{Point: Dismiss, Service: "lights"}, want: "domain.service"},
+		{name: "bad url", step: Step{Point: Dismiss, URL: "ftp://example.com"}, want: "http(s)"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.step.Validate()
+			if tt.want == "" && err != nil {
+				t.Errorf("got error %s, want none", err)
+			}
+			if tt.want != "" && (err == nil || !strings.Contains(err.Error(), tt.want)) {
+				t.Errorf("got error %v, want %q", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestLead(t *testing.T) {
+	steps := []Step{
+		{Point: PreAlarm, Offset: "10m"},
+		{Point: SongStart},
+		{Point: PreAlarm, Offset: "1h"},
+		{Point: PreAlarm},
+	}
+	if lead := Lead(steps); lead != time.Hour {
+		t.Errorf("got %s, want the earliest step's 1h", lead)
+	}
+	if lead := Lead(steps[1:2]); lead != 0 {
+		t.Errorf("got %s, want none without pre-alarm steps", lead)
+	}
+}
+
+// recorder is an endpoint that records the paths of the steps that call it
+type recorder struct {
+	*httptest.Server
+
+	mu     sync.Mutex
+	paths  []string
+	called func(path string)
+}
+
+func newRecorder(t *testing.T) *recorder {
+	t.Helper()
+
+	r := &recorder{}
+	r.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		r.mu.Lock()
+		r.paths = append(r.paths, req.URL.Path)
+		called := r.called
+		r.mu.Unlock()
+
+		if called != nil {
+			called(req.URL.Path)
+		}
+	}))
+	t.Cleanup(r.Close)
+
+	return r
+}
+
+func (r *recorder) calls() []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return append([]string(nil), r.paths...)
+}
+
+func (r *recorder) step(path, offset string) Step {
+	return Step{Point: PreAlarm, Offset: offset, URL: r.URL + path}
+}
+
+func TestCountdown(t *testing.T) {
+	r := newRecorder(t)
+	steps := []Step{
+		r.step("/late", "100ms"),
+		{Point: SongStart, URL: r.URL + "/song"},
+		r.step("/early", "250ms"),
+	}
+
+	alarmTime := time.Now().Add(300 * time.Millisecond)
+	ok := Countdown(&fasthttp.RequestCtx{}, steps, alarmTime, plugin.Run{}, make(chan struct{}))
+	if !ok {
+		t.Fatal("countdown was stopped")
+	}
+	if time.Now().Before(alarmTime) {
+		t.Error("countdown returned before the alarm time")
+	}
+
+	calls := r.calls()
+	if len(calls) != 2 || calls[0] != "/early" || calls[1] != "/late" {
+		t.Errorf("got calls %v, want only the pre-alarm steps, earliest first", calls)
+	}
+}
+
+func TestCountdownStopped(t *testing.T) {
+	r := newRecorder(t)
+	stop := make(chan struct{})
+	r.called = func(string) { close(stop) }
+
+	// the first step is already due, and stops the countdown before the second
+	steps := []Step{
+		r.step("/first", "2h"),
+		r.step("/second", "30m"),
+	}
+
+	done := make(chan bool)
+	go func() {
+		done <- Countdown(&fasthttp.RequestCtx{}, steps, time.Now().Add(time.Hour), plugin.Run{}, stop)
+	}()
+
+	select {
+	case ok := <-done:
+		if ok {
+			t.Error("countdown went on after being stopped")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("countdown didn't return after being stopped")
+	}
+
+	if calls := r.calls(); len(calls) != 1 || calls[0] != "/first" {
+		t.Errorf("got calls %v, want only the step before the stop", calls)
+	}
+}
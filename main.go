@@ -1,9 +1,11 @@
 package main
 
 import (
+	"context"
 	"database/sql"
 	"encoding/csv"
 	"flag"
+	"fmt"
 	"math/rand"
 	"os"
 	"strings"
@@ -17,12 +19,29 @@ import (
 	"github.com/pkg/errors"
 	"github.com/valyala/fasthttp"
 
+	"github.com/jchorl/gowaker/alarmrun"
 	"github.com/jchorl/gowaker/alarms"
+	"github.com/jchorl/gowaker/auth"
+	"github.com/jchorl/gowaker/briefing"
 	"github.com/jchorl/gowaker/config"
+	"github.com/jchorl/gowaker/events"
+	"github.com/jchorl/gowaker/integration"
+	"github.com/jchorl/gowaker/mqtt"
+	"github.com/jchorl/gowaker/plugin"
 	"github.com/jchorl/gowaker/plugin/calendar"
+	"github.com/jchorl/gowaker/plugin/commute"
+	"github.com/jchorl/gowaker/plugin/exec"
+	"github.com/jchorl/gowaker/plugin/news"
+	"github.com/jchorl/gowaker/plugin/reminders"
+	"github.com/jchorl/gowaker/plugin/sun"
 	"github.com/jchorl/gowaker/plugin/weather"
+	"github.com/jchorl/gowaker/plugin/webhook"
+	"github.com/jchorl/gowaker/plugins"
+	"github.com/jchorl/gowaker/requestcontext"
 	"github.com/jchorl/gowaker/speech"
 	"github.com/jchorl/gowaker/spotify"
+	"github.com/jchorl/gowaker/ui"
+	"github.com/jchorl/gowaker/webhooks"
 )
 
 var (
@@ -30,8 +49,27 @@ var (
 	gcalCredFile          = flag.String("gcal-cred-file", "./gcalcreds.json", "File to cache gcal credentials.")
 	gcalConfigFile        = flag.String("gcal-config-file", "./gcalconfig.json", "Oauth config file provided by google.")
 	ttsServiceAccountFile = flag.String("tts-service-account-file", "./tts-service-account-key.json", "Service account file provided by google.")
+	speechCacheDir        = flag.String("speech-cache-dir", "./speechcache", "Directory to cache synthesized speech in. Empty disables the cache.")
+	speechCacheMaxBytes   = flag.Int64("speech-cache-max-bytes", 100<<20, "Maximum size of the speech cache.")
+	mqttBroker            = flag.String("mqtt-broker", "", "MQTT broker to bridge alarms to, e.g. tcp://localhost:1883. Empty disables MQTT.")
+	mqttClientID          = flag.String("mqtt-client-id", "gowaker", "MQTT client ID.")
+	mqttTopicPrefix       = flag.String("mqtt-topic-prefix", "gowaker", "Prefix of every MQTT topic.")
+	execAllow             = flag.String("exec-allow", "", "Comma separated paths of the programs that the exec plugin may run. Empty disables the exec plugin's commands.")
+	requireAuth           = flag.Bool("require-auth", true, "Reject API requests without a bearer token. Tokens are managed with the token subcommand.")
 )
 
+// eventsPath serves the event stream
+const eventsPath = "/events"
+
+// migrations bring tables created by older versions up to date
+var migrations = []string{
+	`alter table alarms add column timezone text not null default ''`,
+	`alter table alarms add column plugins text not null default ''`,
+	`alter table alarms add column label text not null default ''`,
+	`alter table alarms add column integrations text not null default ''`,
+	`alter table reminders add column starts_at int not null default 0`,
+}
+
 func initDB() (*sql.DB, error) {
 	db, err := sql.Open("sqlite3", "./waker.db")
 	if err != nil {
@@ -41,15 +79,91 @@ func initDB() (*sql.DB, error) {
 	sqlStmt := `
 	create table if not exists alarms (
 		id text not null primary key,
+		label text not null default '',
 		hour int not null,
 		minute int not null,
 		repeat bool not null,
-		days string -- csv of days to repeat
+		days string, -- csv of days to repeat
+		timezone text not null default '',
+		plugins text not null default '', -- csv of plugins to run, empty for all enabled plugins
+		integrations text not null default '' -- json list of integration steps
 	);
 	create table if not exists spotify_config (
 		key text not null primary key,
 		value text not null
 	);
+	create table if not exists plugin_config (
+		name text not null primary key,
+		enabled bool not null,
+		position int not null,
+		settings text not null -- json, specific to each plugin
+	);
+	create table if not exists briefing_config (
+		key text not null primary key,
+		value text not null
+	);
+	create table if not exists weather_cache (
+		key text not null primary key, -- identifies the provider, location and unit
+		fetched_at int not null, -- unix timestamp
+		forecast text not null -- json
+	);
+	create table if not exists speech_config (
+		key text not null primary key,
+		value text not null
+	);
+	create table if not exists integration_config (
+		key text not null primary key,
+		value text not null
+	);
+	create table if not exists reminders (
+		id text not null primary key,
+		text text not null,
+		due_at int not null, -- unix timestamp
+		recurrence text not null default '', -- RRULE, empty for one-shot reminders
+		starts_at int not null default 0, -- unix timestamp of the first occurrence, 0 for due_at
+		delivered_at int not null default 0, -- unix timestamp, 0 until delivered
+		created_at int not null -- unix timestamp
+	);
+	create table if not exists runs (
+		id text not null primary key,
+		alarm_id text not null,
+		label text not null default '',
+		scheduled_at int not null, -- unix timestamp
+		started_at int not null, -- unix timestamp
+		ended_at int, -- unix timestamp, null while running
+		outcome text not null, -- running, finished, dismissed, snoozed or failed
+		error text not null default '',
+		track text not null default '' -- the song that was played
+	);
+	create table if not exists tokens (
+		id text not null primary key,
+		name text not null,
+		hash text not null unique, -- sha256 of the token, which isn't stored
+		scope text not null, -- read or admin
+		created_at int not null, -- unix timestamp
+		last_used_at int not null default 0 -- unix timestamp, 0 until used
+	);
+	create table if not exists webhooks (
+		id text not null primary key,
+		url text not null,
+		secret text not null default '', -- empty for unsigned deliveries
+		events text not null default '', -- csv of event types, empty for all
+		created_at int not null -- unix timestamp
+	);
+	create table if not exists webhook_deliveries (
+		id text not null primary key,
+		webhook_id text not null,
+		event text not null,
+		payload text not null, -- json
+		status text not null, -- pending, succeeded or failed
+		attempts int not null default 0,
+		response_status int not null default 0, -- from the last attempt
+		response text not null default '', -- start of the last response body
+		error text not null default '',
+		created_at int not null, -- unix timestamp
+		updated_at int not null -- unix timestamp
+	);
+	create index if not exists webhook_deliveries_webhook_id on webhook_deliveries(webhook_id);
 	`
 	_, err = db.Exec(sqlStmt)
 	if err != nil {
@@ -57,6 +171,15 @@ func initDB() (*sql.DB, error) {
 		return nil, err
 	}
 
+	for _, migration := range migrations {
+		_, err = db.Exec(migration)
+		// sqlite can't add a column only if it doesn't exist, so ignore the error when it does
+		if err != nil && !strings.Contains(err.Error(), "duplicate column name") {
+			err = errors.Wrapf(err, "error executing migration: %s", migration)
+			return nil, err
+		}
+	}
+
 	return db, nil
 }
 
@@ -69,6 +192,25 @@ func main() {
 	}
 	defer db.Close()
 
+	if flag.Arg(0) == "token" {
+		err = auth.Command(db, flag.Args()[1:], os.Stdout)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(2)
+		}
+		return
+	}
+
+	if *requireAuth {
+		count, err := auth.Count(db)
+		if err != nil {
+			log.Fatalf("counting tokens: %s", err)
+		}
+		if count == 0 {
+			log.Warning("no API tokens exist, so every request will be rejected. Create one with: gowaker token create --name NAME")
+		}
+	}
+
 	scheduler := gocron.NewScheduler()
 	scheduler.ChangeLoc(time.UTC) // all timestamps are in UTC
 	job := scheduler.Every(1).Hour()
@@ -85,33 +227,93 @@ func main() {
 
 	rng := rand.New(rand.NewSource(time.Now().UnixNano()))
 
-	speechClient, err := speech.New(*ttsServiceAccountFile)
+	speechProvider, err := speech.New(*ttsServiceAccountFile)
 	if err != nil {
-		log.Fatalf("creating speech client: %s", err)
+		log.Fatalf("creating speech provider: %s", err)
 	}
 
-	weatherPlugin := weather.Weather{
-		APIKey:   os.Getenv("OPENWEATHERMAP_API_KEY"),
-		TempUnit: weather.Celsius,
-		OWMID:    config.OWMID,
+	if *speechCacheDir != "" {
+		speechProvider, err = speech.NewCache(speechProvider, *speechCacheDir, *speechCacheMaxBytes)
+		if err != nil {
+			log.Fatalf("creating speech cache: %s", err)
+		}
 	}
 
-	calendars, err := csv.NewReader(strings.NewReader(config.GoogleCalendars)).Read()
+	weatherPlugin, err := weather.New(
+		db,
+		weather.Settings{
+			Unit:   weather.Celsius,
+			CityID: config.OWMID,
+		},
+		weather.NewOWM(os.Getenv("OPENWEATHERMAP_API_KEY")),
+		weather.NewOpenMeteo(),
+	)
 	if err != nil {
-		log.Fatalf("parsing calendars: %s", err)
+		log.Fatalf("creating weather plugin: %s", err)
+	}
+
+	// keep a recent forecast cached so the briefing doesn't depend on the provider being up at alarm time
+	refreshWeather := func() {
+		// a forecast for each zone that alarms are in, since the zone decides which day is today
+		ctx := requestcontext.NewInternal()
+		requestcontext.SetDB(ctx, db)
+		locs, err := alarms.Locations(ctx)
+		if err != nil {
+			log.Errorf("listing alarm time zones: %s", err)
+		}
+
+		err = weatherPlugin.Refresh(context.Background(), locs...)
+		if err != nil {
+			log.Errorf("refreshing weather: %s", err)
+		}
 	}
-	calendarPlugin, err := calendar.New(calendars, *gcalConfigFile, *gcalCredFile)
+	job = scheduler.Every(1).Hour()
+	job.Tag("weather")
+	job.Do(func() {
+		// don't hold up the scheduler, alarms may be due
+		go refreshWeather()
+	})
+	go refreshWeather()
+
+	var calendarSources []calendar.Source
+	// google calendar is optional, since calendars can also be added through the plugin's settings
+	if _, err := os.Stat(*gcalConfigFile); err == nil {
+		googleCalendar, err := calendar.NewGoogle(*gcalConfigFile, *gcalCredFile)
+		if err != nil {
+			log.Fatalf("creating google calendar source: %s", err)
+		}
+		calendarSources = append(calendarSources, googleCalendar)
+	}
+	calendarPlugin := calendar.New(calendarSources...)
+
+	pluginRegistry, err := plugin.NewRegistry(db, weatherPlugin, calendarPlugin, news.New(), reminders.New(db), sun.New(), commute.New(), exec.New(strings.Split(*execAllow, ",")...), webhook.New())
 	if err != nil {
-		log.Fatalf("creating calendar plugin: %s", err)
+		log.Fatalf("creating plugin registry: %s", err)
 	}
 
+	if len(calendarSources) > 0 {
+		calendars, err := csv.NewReader(strings.NewReader(config.GoogleCalendars)).Read()
+		if err != nil {
+			log.Fatalf("parsing calendars: %s", err)
+		}
+		err = calendar.SeedCalendars(pluginRegistry, calendars)
+		if err != nil {
+			log.Fatalf("seeding calendar settings: %s", err)
+		}
+	}
+
+	eventBus := events.NewBus()
+
 	middlewares := []middleware{
 		dbMiddleware(db),
 		schedulerMiddleware(scheduler),
 		spotifyMiddleware(spotifyClient),
 		randMiddleware(rng),
-		speechMiddleware(speechClient),
-		pluginsMiddleware(weatherPlugin, calendarPlugin),
+		speechMiddleware(speechProvider),
+		pluginsMiddleware(pluginRegistry),
+		eventsMiddleware(eventBus),
+		// EventSource can't set headers, so the stream takes its token in the url
+		authMiddleware(db, *requireAuth, eventsPath),
 		logMiddleware(),
 	}
 
@@ -130,12 +332,43 @@ func main() {
 			log.Fatalf("restoring db: %s", err)
 		}
 	})
-	fakeHandler(&fasthttp.RequestCtx{})
+	fakeHandler(requestcontext.NewInternal())
+
+	middlewareApplier(func(ctx *fasthttp.RequestCtx) {
+		err = webhooks.Start(ctx)
+		if err != nil {
+			log.Fatalf("starting webhooks: %s", err)
+		}
+	})(requestcontext.NewInternal())
+
+	if *mqttBroker != "" {
+		bridge := mqtt.New(mqtt.Options{
+			Broker:   *mqttBroker,
+			ClientID: *mqttClientID,
+			Username: os.Getenv("MQTT_USERNAME"),
+			Password: os.Getenv("MQTT_PASSWORD"),
+			Prefix:   *mqttTopicPrefix,
+		})
+		middlewareApplier(func(ctx *fasthttp.RequestCtx) {
+			err = bridge.Start(requestcontext.Clone(ctx))
+			if err != nil {
+				log.Fatalf("starting mqtt bridge: %s", err)
+			}
+		})(requestcontext.NewInternal())
+	}
 
 	r := router.New()
+	// the app is static, so it doesn't need the middlewares
+	r.GET("/", ui.HandlerRedirect)
+	r.GET(ui.Prefix+"*filepath", ui.HandlerStatic)
+
 	r.GET("/alarms", middlewareApplier(alarms.HandlerGet))
 	r.DELETE("/alarms", middlewareApplier(alarms.HandlerDelete))
 	r.POST("/alarms", middlewareApplier(alarms.HandlerPost))
+	r.PUT("/alarms/:id", middlewareApplier(alarms.HandlerPut))
+	r.POST("/alarms/snooze", middlewareApplier(alarmrun.HandlerSnooze))
+	r.POST("/alarms/dismiss", middlewareApplier(alarmrun.HandlerDismiss))
+	r.GET("/alarms/history", middlewareApplier(alarmrun.HandlerGetHistory))
 
 	r.GET("/spotify/playlists", middlewareApplier(spotify.HandlerGetPlaylists))
 	r.GET("/spotify/default_playlist", middlewareApplier(spotify.HandlerGetDefaultPlaylist))
@@ -145,6 +378,42 @@ func main() {
 	r.GET("/spotify/search", middlewareApplier(spotify.HandlerSearch))
 	r.GET("/spotify/devices", middlewareApplier(spotify.HandlerDevices))
 
+	r.GET("/plugins", middlewareApplier(plugins.HandlerGet))
+	r.PUT("/plugins/:name", middlewareApplier(plugins.HandlerPut))
+
+	r.GET("/briefing/template", middlewareApplier(briefing.HandlerGetTemplate))
+	r.PUT("/briefing/template", middlewareApplier(briefing.HandlerSetTemplate))
+	r.POST("/briefing/preview", middlewareApplier(briefing.HandlerPreview))
+
+	r.GET("/calendar/calendars", middlewareApplier(calendar.HandlerGetCalendars))
+	r.PUT("/calendar/calendars", middlewareApplier(calendar.HandlerSetCalendars))
+
+	r.GET("/news/feeds", middlewareApplier(news.HandlerGetFeeds))
+	r.POST("/news/feeds", middlewareApplier(news.HandlerAddFeed))
+	r.DELETE("/news/feeds", middlewareApplier(news.HandlerDeleteFeed))
+
+	r.GET("/reminders", middlewareApplier(reminders.HandlerGet))
+	r.POST("/reminders", middlewareApplier(reminders.HandlerPost))
+	r.PUT("/reminders/:id", middlewareApplier(reminders.HandlerPut))
+	r.DELETE("/reminders/:id", middlewareApplier(reminders.HandlerDelete))
+
+	r.GET("/integrations/home_assistant", middlewareApplier(integration.HandlerGetHomeAssistant))
+	r.PUT("/integrations/home_assistant", middlewareApplier(integration.HandlerSetHomeAssistant))
+
+	r.GET(eventsPath, middlewareApplier(eventBus.HandlerStream))
+
+	r.GET("/webhooks", middlewareApplier(webhooks.HandlerGet))
+	r.POST("/webhooks", middlewareApplier(webhooks.HandlerPost))
+	r.DELETE("/webhooks/:id", middlewareApplier(webhooks.HandlerDelete))
+	r.GET("/webhooks/:id/deliveries", middlewareApplier(webhooks.HandlerGetDeliveries))
+	r.POST("/webhooks/:id/deliveries/:delivery/redeliver", middlewareApplier(webhooks.HandlerRedeliver))
+
+	r.GET("/speech/settings", middlewareApplier(speech.HandlerGetSettings))
+	r.PUT("/speech/settings", middlewareApplier(speech.HandlerSetSettings))
+	r.GET("/speech/voices", middlewareApplier(speech.HandlerGetVoices))
+	r.GET("/speech/cache", middlewareApplier(speech.HandlerGetCache))
+	r.DELETE("/speech/cache", middlewareApplier(speech.HandlerInvalidateCache))
+
 	serverDone := make(chan struct{})
 	go func() {
 		port := ":8080"
@@ -157,8 +426,7 @@ func main() {
 	go func() {
 		log.Info("starting the job processor")
 		ticker := time.NewTicker(30 * time.Second)
-		for {
-			<-ticker.C
+		for range ticker.C {
 			scheduler.RunPending()
 		}
 		schedulerDone <- struct{}{}
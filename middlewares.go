@@ -2,16 +2,20 @@ package main
 
 import (
 	"database/sql"
+	"errors"
+	"fmt"
 	"math/rand"
 
-	texttospeech "cloud.google.com/go/texttospeech/apiv1"
 	log "github.com/golang/glog"
 	"github.com/jasonlvhit/gocron"
 	upstreamspotify "github.com/jchorl/spotify"
 	"github.com/valyala/fasthttp"
 
+	"github.com/jchorl/gowaker/auth"
+	"github.com/jchorl/gowaker/events"
 	"github.com/jchorl/gowaker/plugin"
 	"github.com/jchorl/gowaker/requestcontext"
+	"github.com/jchorl/gowaker/speech/provider"
 )
 
 type middleware func(fasthttp.RequestHandler) fasthttp.RequestHandler
@@ -52,19 +56,79 @@ func randMiddleware(r *rand.Rand) middleware {
 	}
 }
 
-func speechMiddleware(client *texttospeech.Client) middleware {
+func speechMiddleware(p provider.Provider) middleware {
 	return func(handler fasthttp.RequestHandler) fasthttp.RequestHandler {
 		return func(ctx *fasthttp.RequestCtx) {
-			requestcontext.SetSpeech(ctx, client)
+			requestcontext.SetSpeech(ctx, p)
 			handler(ctx)
 		}
 	}
 }
 
-func pluginsMiddleware(plugins ...plugin.Plugin) middleware {
+func pluginsMiddleware(registry *plugin.Registry) middleware {
 	return func(handler fasthttp.RequestHandler) fasthttp.RequestHandler {
 		return func(ctx *fasthttp.RequestCtx) {
-			requestcontext.SetPlugins(ctx, plugins)
+			requestcontext.SetPlugins(ctx, registry)
+			handler(ctx)
+		}
+	}
+}
+
+func eventsMiddleware(bus *events.Bus) middleware {
+	return func(handler fasthttp.RequestHandler) fasthttp.RequestHandler {
+		return func(ctx *fasthttp.RequestCtx) {
+			requestcontext.SetEvents(ctx, bus)
+			handler(ctx)
+		}
+	}
+}
+
+// authMiddleware checks the bearer token of every request against the
+// tokens table. With required false, requests without a token are let
+// through, so clients can be moved over to tokens after upgrading; it is only
+// false when started with -require-auth=false. The token may be in the
+// access_token query param instead for requests to queryTokenPath.
+func authMiddleware(db *sql.DB, required bool, queryTokenPath string) middleware {
+	return func(handler fasthttp.RequestHandler) fasthttp.RequestHandler {
+		return func(ctx *fasthttp.RequestCtx) {
+			// the server's own ctxs, like the one used to restore alarms, don't have a token
+			if requestcontext.IsInternal(ctx) {
+				handler(ctx)
+				return
+			}
+
+			secret := auth.FromRequest(ctx)
+			if secret == "" && string(ctx.Path()) == queryTokenPath {
+				secret = auth.FromQuery(ctx)
+			}
+			if secret == "" && !required {
+				handler(ctx)
+				return
+			}
+			if secret == "" {
+				ctx.Response.Header.Set(fasthttp.HeaderWWWAuthenticate, "Bearer")
+				ctx.Error("missing bearer token", fasthttp.StatusUnauthorized)
+				return
+			}
+
+			token, err := auth.Authenticate(db, secret)
+			if errors.Is(err, auth.ErrInvalidToken) {
+				ctx.Response.Header.Set(fasthttp.HeaderWWWAuthenticate, `Bearer error="invalid_token"`)
+				ctx.Error(err.Error(), fasthttp.StatusUnauthorized)
+				return
+			}
+			if err != nil {
+				err = fmt.Errorf("authenticating: %w", err)
+				log.Error(err)
+				ctx.Error(err.Error(), fasthttp.StatusInternalServerError)
+				return
+			}
+
+			if !token.Scope.Allows(string(ctx.Method())) {
+				ctx.Error(fmt.Sprintf("token %s has %s scope, which can't %s", token.Name, token.Scope, ctx.Method()), fasthttp.StatusForbidden)
+				return
+			}
+
 			handler(ctx)
 		}
 	}
@@ -0,0 +1,196 @@
+// Package mqtt bridges alarms to an MQTT broker. Lifecycle events are
+// published, and snooze, dismiss and create alarm commands are subscribed to.
+package mqtt
+
+import (
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+
+	paho "github.com/eclipse/paho.mqtt.golang"
+	log "github.com/golang/glog"
+	"github.com/valyala/fasthttp"
+
+	"github.com/jchorl/gowaker/alarmrun"
+	"github.com/jchorl/gowaker/alarms"
+	"github.com/jchorl/gowaker/events"
+	"github.com/jchorl/gowaker/requestcontext"
+)
+
+const qos = 1
+
+const connectTimeout = 10 * time.Second
+
+// Options describe the broker to connect to
+type Options struct {
+	// Broker is a URL like tcp://localhost:1883
+	Broker   string
+	ClientID string
+	Username string
+	Password string
+
+	// Prefix is prepended to every topic, e.g. gowaker
+	Prefix string
+}
+
+// Bridge publishes alarm events to a broker and runs commands sent to it
+type Bridge struct {
+	opts   Options
+	client paho.Client
+
+	events      <-chan events.Event
+	unsubscribe func()
+}
+
+// New creates a bridge. It doesn't connect until Start.
+func New(opts Options) *Bridge {
+	return &Bridge{opts: opts}
+}
+
+func (b *Bridge) topic(suffix string) string {
+	return b.opts.Prefix + "/" + suffix
+}
+
+// Start connects to the broker, then forwards events from the bus on ctx and
+// runs commands with ctx until Stop. ctx must outlive the request it came
+// from, e.g. a clone. Start returns once commands are subscribed to.
+func (b *Bridge) Start(ctx *fasthttp.RequestCtx) error {
+	statusTopic := b.topic("status")
+	subscribed := make(chan struct{})
+	var once sync.Once
+
+	opts := paho.NewClientOptions().
+		AddBroker(b.opts.Broker).
+		SetClientID(b.opts.ClientID).
+		SetUsername(b.opts.Username).
+		SetPassword(b.opts.Password).
+		SetAutoReconnect(true).
+		SetWill(statusTopic, "offline", qos, true).
+		SetConnectionLostHandler(func(_ paho.Client, err error) {
+			log.Errorf("lost connection to mqtt broker: %s", err)
+		}).
+		// subscriptions don't survive reconnecting with a clean session
+		SetOnConnectHandler(func(client paho.Client) {
+			log.Infof("connected to mqtt broker %s", b.opts.Broker)
+			client.Publish(statusTopic, qos, true, "online")
+			b.subscribe(ctx, client)
+			once.Do(func() { close(subscribed) })
+		})
+
+	b.client = paho.NewClient(opts)
+	token := b.client.Connect()
+	if !token.WaitTimeout(connectTimeout) {
+		return fmt.Errorf("connecting to %s: timed out", b.opts.Broker)
+	}
+	if err := token.Error(); err != nil {
+		return fmt.Errorf("connecting to %s: %w", b.opts.Broker, err)
+	}
+
+	select {
+	case <-subscribed:
+	case <-time.After(connectTimeout):
+		return fmt.Errorf("subscribing to commands on %s: timed out", b.opts.Broker)
+	}
+
+	// subscribe before returning, so no events published after Start are missed
+	b.events, b.unsubscribe = requestcontext.Events(ctx).Subscribe(64)
+	go b.forward()
+	return nil
+}
+
+// Stop marks gowaker offline and disconnects from the broker
+func (b *Bridge) Stop() {
+	b.unsubscribe()
+
+	token := b.client.Publish(b.topic("status"), qos, true, "offline")
+	token.WaitTimeout(connectTimeout)
+	b.client.Disconnect(250)
+}
+
+// forward publishes every event to <prefix>/alarm/<type>
+func (b *Bridge) forward() {
+	for e := range b.events {
+		payload, err := json.Marshal(e)
+		if err != nil {
+			log.Errorf("encoding %s event: %s", e.Type, err)
+			continue
+		}
+
+		// don't wait on the broker, so a slow one can't back up the bus
+		b.client.Publish(b.topic("alarm/"+string(e.Type)), qos, false, payload)
+	}
+}
+
+func (b *Bridge) subscribe(ctx *fasthttp.RequestCtx, client paho.Client) {
+	handlers := map[string]paho.MessageHandler{
+		b.topic("command/snooze"):       b.handleSnooze,
+		b.topic("command/dismiss"):      b.handleDismiss,
+		b.topic("command/alarm/create"): func(_ paho.Client, msg paho.Message) { b.handleCreate(ctx, msg) },
+	}
+
+	for topic, handler := range handlers {
+		token := client.Subscribe(topic, qos, handler)
+		if token.WaitTimeout(connectTimeout) && token.Error() != nil {
+			log.Errorf("subscribing to %s: %s", topic, token.Error())
+		}
+	}
+}
+
+// handleSnooze takes an optional {"minutes": 5}
+func (b *Bridge) handleSnooze(_ paho.Client, msg paho.Message) {
+	req := alarmrun.SnoozeRequest{}
+	if len(msg.Payload()) > 0 {
+		err := json.Unmarshal(msg.Payload(), &req)
+		if err != nil {
+			b.reportError(msg, fmt.Errorf("decoding payload: %w", err))
+			return
+		}
+	}
+
+	err := alarmrun.Snooze(req.Duration())
+	if err != nil {
+		b.reportError(msg, err)
+	}
+}
+
+func (b *Bridge) handleDismiss(_ paho.Client, msg paho.Message) {
+	err := alarmrun.Dismiss()
+	if err != nil {
+		b.reportError(msg, err)
+	}
+}
+
+// handleCreate takes the same alarm as POST /alarms and publishes the created
+// alarm as a scheduled event
+func (b *Bridge) handleCreate(ctx *fasthttp.RequestCtx, msg paho.Message) {
+	alarm := alarms.Alarm{}
+	err := json.Unmarshal(msg.Payload(), &alarm)
+	if err != nil {
+		b.reportError(msg, fmt.Errorf("decoding payload: %w", err))
+		return
+	}
+
+	err = alarms.Validate(ctx, alarm)
+	if err != nil {
+		b.reportError(msg, err)
+		return
+	}
+
+	_, err = alarms.Create(ctx, alarm)
+	if err != nil {
+		b.reportError(msg, fmt.Errorf("creating alarm: %w", err))
+	}
+}
+
+// reportError logs a failed command and publishes it to <prefix>/error, since
+// the sender has no response to read
+func (b *Bridge) reportError(msg paho.Message, err error) {
+	log.Errorf("handling mqtt command on %s: %s", msg.Topic(), err)
+
+	payload, _ := json.Marshal(map[string]string{
+		"topic": msg.Topic(),
+		"error": err.Error(),
+	})
+	b.client.Publish(b.topic("error"), qos, false, payload)
+}
@@ -0,0 +1,242 @@
+package mqtt
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	paho "github.com/eclipse/paho.mqtt.golang"
+	"github.com/jasonlvhit/gocron"
+	_ "github.com/mattn/go-sqlite3"
+	"github.com/valyala/fasthttp"
+
+	"github.com/jchorl/gowaker/alarms"
+	"github.com/jchorl/gowaker/events"
+	"github.com/jchorl/gowaker/plugin"
+	"github.com/jchorl/gowaker/requestcontext"
+)
+
+// These tests need a broker, e.g. a local mosquitto:
+//
+//	mosquitto -p 1883 &
+//	MQTT_TEST_BROKER=tcp://localhost:1883 go test ./mqtt
+const brokerEnv = "MQTT_TEST_BROKER"
+
+const waitTimeout = 5 * time.Second
+
+func newTestCtx(t *testing.T) *fasthttp.RequestCtx {
+	t.Helper()
+
+	db, err := sql.Open("sqlite3", ":memory:")
+	if err != nil {
+		t.Fatalf("opening db: %s", err)
+	}
+	// every connection to :memory: is its own db
+	db.SetMaxOpenConns(1)
+	t.Cleanup(func() { db.Close() })
+
+	_, err = db.Exec(`
+	create table alarms (
+		id text not null primary key,
+		label text not null default '',
+		hour int not null,
+		minute int not null,
+		repeat bool not null,
+		days string,
+		timezone text not null default '',
+		plugins text not null default '',
+		integrations text not null default ''
+	);
+	create table plugin_config (
+		name text not null primary key,
+		enabled bool not null,
+		position int not null,
+		settings text not null
+	);
+	`)
+	if err != nil {
+		t.Fatalf("creating tables: %s", err)
+	}
+
+	registry, err := plugin.NewRegistry(db)
+	if err != nil {
+		t.Fatalf("creating plugin registry: %s", err)
+	}
+
+	scheduler := gocron.NewScheduler()
+	scheduler.ChangeLoc(time.UTC)
+
+	ctx := &fasthttp.RequestCtx{}
+	requestcontext.SetDB(ctx, db)
+	requestcontext.SetScheduler(ctx, scheduler)
+	requestcontext.SetPlugins(ctx, registry)
+	requestcontext.SetEvents(ctx, events.NewBus())
+	return ctx
+}
+
+// startBridge starts a bridge on a topic prefix of its own, and subscribes to
+// everything under it
+func startBridge(t *testing.T, ctx *fasthttp.RequestCtx) (*Bridge, paho.Client, <-chan paho.Message) {
+	t.Helper()
+
+	broker := os.Getenv(brokerEnv)
+	if broker == "" {
+		t.Skipf("set %s, e.g. tcp://localhost:1883, to test against a broker", brokerEnv)
+	}
+
+	prefix := fmt.Sprintf("gowaker-test-%d", time.Now().UnixNano())
+	bridge := New(Options{Broker: broker, ClientID: prefix + "-bridge", Prefix: prefix})
+	err := bridge.Start(ctx)
+	if err != nil {
+		t.Fatalf("starting bridge: %s", err)
+	}
+
+	client := paho.NewClient(paho.NewClientOptions().AddBroker(broker).SetClientID(prefix + "-test"))
+	token := client.Connect()
+	if !token.WaitTimeout(waitTimeout) || token.Error() != nil {
+		t.Fatalf("connecting test client: %v", token.Error())
+	}
+
+	received := make(chan paho.Message, 64)
+	token = client.Subscribe(prefix+"/#", qos, func(_ paho.Client, msg paho.Message) {
+		received <- msg
+	})
+	if !token.WaitTimeout(waitTimeout) || token.Error() != nil {
+		t.Fatalf("subscribing test client: %v", token.Error())
+	}
+
+	t.Cleanup(func() {
+		bridge.Stop()
+		// clear the retained status so test prefixes don't pile up on the broker
+		client.Publish(prefix+"/status", qos, true, "").WaitTimeout(waitTimeout)
+		client.Disconnect(250)
+	})
+
+	return bridge, client, received
+}
+
+// expect waits for a message on the topic, skipping messages on others
+func expect(t *testing.T, received <-chan paho.Message, topic string) paho.Message {
+	t.Helper()
+
+	timeout := time.After(waitTimeout)
+	for {
+		select {
+		case msg := <-received:
+			if msg.Topic() == topic {
+				return msg
+			}
+		case <-timeout:
+			t.Fatalf("no message on %s", topic)
+			return nil
+		}
+	}
+}
+
+func publish(t *testing.T, client paho.Client, topic, payload string) {
+	t.Helper()
+
+	token := client.Publish(topic, qos, false, payload)
+	if !token.WaitTimeout(waitTimeout) || token.Error() != nil {
+		t.Fatalf("publishing to %s: %v", topic, token.Error())
+	}
+}
+
+func TestStatus(t *testing.T) {
+	ctx := newTestCtx(t)
+	bridge, _, received := startBridge(t, ctx)
+
+	msg := expect(t, received, bridge.topic("status"))
+	if string(msg.Payload()) != "online" {
+		t.Errorf("status is %q, want online", msg.Payload())
+	}
+}
+
+func TestForwardsEvents(t *testing.T) {
+	ctx := newTestCtx(t)
+	bridge, _, received := startBridge(t, ctx)
+
+	requestcontext.Events(ctx).Publish(events.Event{Type: events.Firing, AlarmID: "abc", Label: "Work"})
+
+	msg := expect(t, received, bridge.topic("alarm/firing"))
+	var e events.Event
+	err := json.Unmarshal(msg.Payload(), &e)
+	if err != nil {
+		t.Fatalf("decoding event: %s", err)
+	}
+	if e.Type != events.Firing || e.AlarmID != "abc" || e.Label != "Work" {
+		t.Errorf("got event %+v", e)
+	}
+}
+
+func TestCreateCommand(t *testing.T) {
+	ctx := newTestCtx(t)
+	bridge, client, received := startBridge(t, ctx)
+
+	publish(t, client, bridge.topic("command/alarm/create"), `{"label":"Work","time":{"hour":7,"minute":30},"repeat":true,"days":["monday","friday"]}`)
+
+	msg := expect(t, received, bridge.topic("alarm/scheduled"))
+	var e struct {
+		AlarmID string       `json:"alarm_id"`
+		Data    alarms.Alarm `json:"data"`
+	}
+	err := json.Unmarshal(msg.Payload(), &e)
+	if err != nil {
+		t.Fatalf("decoding event: %s", err)
+	}
+	if e.AlarmID == "" || e.Data.ID != e.AlarmID {
+		t.Errorf("scheduled alarm id %q doesn't match event alarm id %q", e.Data.ID, e.AlarmID)
+	}
+	if e.Data.Label != "Work" || e.Data.Time.Hour != 7 || e.Data.Time.Minute != 30 {
+		t.Errorf("got alarm %+v", e.Data)
+	}
+
+	var count int
+	err = requestcontext.DB(ctx).QueryRow("select count(*) from alarms where id = ?", e.AlarmID).Scan(&count)
+	if err != nil {
+		t.Fatalf("counting alarms: %s", err)
+	}
+	if count != 1 {
+		t.Errorf("alarm wasn't saved")
+	}
+	if jobs := len(requestcontext.Scheduler(ctx).Jobs()); jobs != 2 {
+		t.Errorf("got %d scheduled jobs, want one for each day", jobs)
+	}
+}
+
+func TestCommandErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		topic   string
+		payload string
+		want    string
+	}{
+		{name: "invalid alarm", topic: "command/alarm/create", payload: `{"time":{"hour":25,"minute":0}}`, want: "invalid time"},
+		{name: "malformed alarm", topic: "command/alarm/create", payload: `{`, want: "decoding payload"},
+		{name: "snooze nothing", topic: "command/snooze", payload: `{"minutes":5}`, want: "no alarm is running"},
+		{name: "dismiss nothing", topic: "command/dismiss", want: "no alarm is running"},
+	}
+
+	ctx := newTestCtx(t)
+	bridge, client, received := startBridge(t, ctx)
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			publish(t, client, bridge.topic(tt.topic), tt.payload)
+
+			msg := expect(t, received, bridge.topic("error"))
+			var report map[string]string
+			err := json.Unmarshal(msg.Payload(), &report)
+			if err != nil {
+				t.Fatalf("decoding error: %s", err)
+			}
+			if report["topic"] != bridge.topic(tt.topic) || !strings.Contains(report["error"], tt.want) {
+				t.Errorf("got error %v, want one on %s containing %q", report, tt.topic, tt.want)
+			}
+		})
+	}
+}
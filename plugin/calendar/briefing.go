@@ -0,0 +1,173 @@
+package calendar
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/jchorl/gowaker/ssml"
+)
+
+// Conflict is a pair of timed events that overlap or run back to back
+type Conflict struct {
+	First   Event `json:"first"`
+	Second  Event `json:"second"`
+	Overlap bool  `json:"overlap"`
+}
+
+// visible merges events that appear on several calendars, drops declined
+// events and sorts the rest. emails are the addresses that count as you.
+func visible(events []Event, emails []string) []Event {
+	me := map[string]bool{}
+	for _, email := range emails {
+		me[strings.ToLower(email)] = true
+	}
+
+	var keys []string
+	merged := map[string]Event{}
+	for _, e := range events {
+		for _, address := range e.declinedBy {
+			if me[address] {
+				e.Declined = true
+			}
+		}
+
+		key := fmt.Sprintf("%s|%d|%d|%t", strings.ToLower(strings.TrimSpace(e.Summary)), e.Start.Unix(), e.End.Unix(), e.AllDay)
+		existing, ok := merged[key]
+		if !ok {
+			keys = append(keys, key)
+			merged[key] = e
+			continue
+		}
+
+		if existing.Location == "" {
+			existing.Location = e.Location
+		}
+		existing.Declined = existing.Declined || e.Declined
+		merged[key] = existing
+	}
+
+	filtered := []Event{}
+	for _, key := range keys {
+		if e := merged[key]; !e.Declined {
+			filtered = append(filtered, e)
+		}
+	}
+
+	sortEvents(filtered)
+	return filtered
+}
+
+// sortEvents puts all-day events first, then orders by start, end and summary
+// so that the order doesn't depend on which calendar answered first
+func sortEvents(events []Event) {
+	sort.Slice(events, func(i, j int) bool {
+		a, b := events[i], events[j]
+		if a.AllDay != b.AllDay {
+			return a.AllDay
+		}
+		if !a.Start.Equal(b.Start) {
+			return a.Start.Before(b.Start)
+		}
+		if !a.End.Equal(b.End) {
+			return a.End.Before(b.End)
+		}
+		return a.Summary < b.Summary
+	})
+}
+
+// conflicts finds timed events that overlap or start right as another ends.
+// events must be sorted.
+func conflicts(events []Event) []Conflict {
+	var found []Conflict
+	for i, a := range events {
+		if a.AllDay {
+			continue
+		}
+
+		for _, b := range events[i+1:] {
+			if b.AllDay || b.Start.After(a.End) {
+				continue
+			}
+
+			found = append(found, Conflict{First: a, Second: b, Overlap: b.Start.Before(a.End)})
+		}
+	}
+
+	return found
+}
+
+func say(b Briefing, w window) string {
+	str := ""
+	if len(b.Events) == 0 {
+		str = fmt.Sprintf("There are no calendar events %s. ", w)
+	} else {
+		str = fmt.Sprintf("Here are the upcoming calendar events %s.", w) + ssml.Break(500*time.Millisecond)
+		for _, e := range b.Events {
+			str += sayEvent(e, w.Start) + ssml.Break(300*time.Millisecond)
+		}
+	}
+
+	for _, c := range b.Conflicts {
+		if c.Overlap {
+			str += fmt.Sprintf("%s overlaps with %s. ", ssml.Escape(c.First.Summary), ssml.Escape(c.Second.Summary))
+			continue
+		}
+		str += fmt.Sprintf("%s and %s are back to back. ", ssml.Escape(c.First.Summary), ssml.Escape(c.Second.Summary))
+	}
+
+	if b.First != nil {
+		str += fmt.Sprintf("Your first meeting is in %s. ", spokenDuration(b.First.Start.Sub(w.Start)))
+	}
+
+	if b.Tomorrow != nil {
+		str += fmt.Sprintf("Tomorrow starts with %s at %s. ", ssml.Escape(b.Tomorrow.Summary), ssml.Time(b.Tomorrow.Start))
+	}
+
+	return strings.TrimSpace(str)
+}
+
+func sayEvent(e Event, now time.Time) string {
+	str := ssml.Escape(e.Summary)
+	switch {
+	case e.AllDay:
+	case e.Start.Before(now):
+		str += " started at " + ssml.Time(e.Start) + " and runs for another " + spokenDuration(e.End.Sub(now))
+	default:
+		str += " at " + ssml.Time(e.Start)
+		if d := e.End.Sub(e.Start); d > 0 {
+			str += " for " + spokenDuration(d)
+		}
+	}
+	// video call links aren't worth reading out
+	if e.Location != "" && !strings.Contains(e.Location, "://") {
+		str += ", in " + ssml.Escape(e.Location)
+	}
+
+	return str + ". "
+}
+
+// spokenDuration reads out a duration to the nearest minute, like "1 hour and 30 minutes"
+func spokenDuration(d time.Duration) string {
+	minutes := int(math.Round(d.Minutes()))
+	hours, minutes := minutes/60, minutes%60
+
+	var parts []string
+	if hours > 0 {
+		parts = append(parts, plural(hours, "hour"))
+	}
+	if minutes > 0 || hours == 0 {
+		parts = append(parts, plural(minutes, "minute"))
+	}
+
+	return strings.Join(parts, " and ")
+}
+
+func plural(n int, unit string) string {
+	if n == 1 {
+		return "1 " + unit
+	}
+	return fmt.Sprintf("%d %ss", n, unit)
+}
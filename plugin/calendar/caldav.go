@@ -0,0 +1,99 @@
+package calendar
+
+import (
+	"context"
+	"encoding/xml"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// CalDAV is a source of events from a CalDAV calendar collection, e.g. on
+// Fastmail or Nextcloud
+type CalDAV struct {
+	// URL is the calendar collection, e.g.
+	// https://caldav.fastmail.com/dav/calendars/user/me@fastmail.com/Default/
+	URL      string
+	Username string
+	Password string
+	Client   *http.Client
+}
+
+// NewCalDAV creates a CalDAV source
+func NewCalDAV(url, username, password string) *CalDAV {
+	return &CalDAV{URL: url, Username: username, Password: password, Client: &http.Client{}}
+}
+
+const calendarQuery = `<?xml version="1.0" encoding="utf-8" ?>
+<C:calendar-query xmlns:D="DAV:" xmlns:C="urn:ietf:params:xml:ns:caldav">
+  <D:prop>
+    <C:calendar-data/>
+  </D:prop>
+  <C:filter>
+    <C:comp-filter name="VCALENDAR">
+      <C:comp-filter name="VEVENT">
+        <C:time-range start="%s" end="%s"/>
+      </C:comp-filter>
+    </C:comp-filter>
+  </C:filter>
+</C:calendar-query>`
+
+type multistatus struct {
+	Responses []struct {
+		Href      string `xml:"DAV: href"`
+		Propstats []struct {
+			Status string `xml:"DAV: status"`
+			Prop   struct {
+				CalendarData string `xml:"urn:ietf:params:xml:ns:caldav calendar-data"`
+			} `xml:"DAV: prop"`
+		} `xml:"DAV: propstat"`
+	} `xml:"DAV: response"`
+}
+
+// Events queries the collection for events in [start, end) and expands their recurrences
+func (c *CalDAV) Events(ctx context.Context, start, end time.Time, loc *time.Location) ([]Event, error) {
+	body := fmt.Sprintf(calendarQuery, start.UTC().Format("20060102T150405Z"), end.UTC().Format("20060102T150405Z"))
+	req, err := http.NewRequest("REPORT", c.URL, strings.NewReader(body))
+	if err != nil {
+		return nil, fmt.Errorf("creating request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/xml; charset=utf-8")
+	req.Header.Set("Depth", "1")
+	if c.Username != "" {
+		req.SetBasicAuth(c.Username, c.Password)
+	}
+
+	resp, err := c.Client.Do(req.WithContext(ctx))
+	if err != nil {
+		return nil, fmt.Errorf("querying %s: %w", c.URL, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusMultiStatus {
+		return nil, fmt.Errorf("querying %s: unexpected status %s", c.URL, resp.Status)
+	}
+
+	ms := multistatus{}
+	err = xml.NewDecoder(resp.Body).Decode(&ms)
+	if err != nil {
+		return nil, fmt.Errorf("decoding multistatus: %w", err)
+	}
+
+	var vevents []vevent
+	for _, r := range ms.Responses {
+		for _, ps := range r.Propstats {
+			if ps.Prop.CalendarData == "" {
+				continue
+			}
+
+			v, err := parseICS(strings.NewReader(ps.Prop.CalendarData))
+			if err != nil {
+				return nil, fmt.Errorf("parsing %s: %w", r.Href, err)
+			}
+			vevents = append(vevents, v...)
+		}
+	}
+
+	return expand(vevents, start, end, loc)
+}
@@ -0,0 +1,52 @@
+package calendar
+
+import (
+	"context"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jchorl/gowaker/plugin/calendar/calendartest"
+)
+
+func TestCalDAVEvents(t *testing.T) {
+	loc := loadLocation(t)
+	server := calendartest.NewCalDAVServer("me", "secret", testICS, `BEGIN:VCALENDAR
+BEGIN:VEVENT
+UID:dentist
+SUMMARY:Dentist
+DTSTART;TZID=America/Toronto:20210304T140000
+DTEND;TZID=America/Toronto:20210304T150000
+END:VEVENT
+END:VCALENDAR
+`)
+	t.Cleanup(server.Close)
+
+	start := time.Date(2021, 3, 4, 0, 0, 0, 0, loc)
+	end := start.AddDate(0, 0, 1)
+
+	c := NewCalDAV(server.URL+"/dav/calendars/me/Default/", "me", "secret")
+	events, err := c.Events(context.Background(), start, end, loc)
+	if err != nil {
+		t.Fatalf("getting events: %s", err)
+	}
+	sort.Slice(events, func(i, j int) bool { return events[i].Start.Before(events[j].Start) })
+
+	// the fake returns every object, so events outside the day are left out
+	// by the client
+	if len(events) != 1 || events[0].Summary != "Dentist" || !events[0].Start.Equal(start.Add(14*time.Hour)) {
+		t.Errorf("got events %+v, want only the dentist", events)
+	}
+
+	queries := server.Queries()
+	if len(queries) != 1 || !strings.Contains(queries[0], `<C:time-range start="20210304T050000Z" end="20210305T050000Z"/>`) {
+		t.Errorf("got queries %q, want one for the day in utc", queries)
+	}
+
+	c = NewCalDAV(server.URL+"/dav/calendars/me/Default/", "me", "wrong")
+	_, err = c.Events(context.Background(), start, end, loc)
+	if err == nil || !strings.Contains(err.Error(), "401") {
+		t.Errorf("got error %v, want unauthorized", err)
+	}
+}
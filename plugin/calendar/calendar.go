@@ -2,112 +2,405 @@ package calendar
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
-	"io/ioutil"
-	"sort"
+	"sync"
 	"time"
 
-	"golang.org/x/oauth2/google"
-	"google.golang.org/api/calendar/v3"
+	log "github.com/golang/glog"
+	"github.com/valyala/fasthttp"
 
-	"github.com/jchorl/gowaker/util"
+	"github.com/jchorl/gowaker/plugin"
+	"github.com/jchorl/gowaker/requestcontext"
+	"github.com/jchorl/gowaker/ssml"
 )
 
-// Calendar is a plugin that interacts with google calendar
+// Calendar is a plugin that reads out upcoming events from calendar sources
 type Calendar struct {
-	calendars map[string]bool
-	client    *calendar.Service
+	// static sources are set up once, e.g. google calendar which needs oauth
+	static []Source
+
+	mu         sync.RWMutex
+	configured []Source
+	settings   Settings
 }
 
-// New creates a new calendar client.
-func New(calendars []string, oauthConfigFile, oauthCredFile string) (Calendar, error) {
-	b, err := ioutil.ReadFile(oauthConfigFile)
-	if err != nil {
-		return Calendar{}, fmt.Errorf("reading %s: %w", oauthConfigFile, err)
+// Settings pick calendars and add calendar sources at runtime
+type Settings struct {
+	// Calendars are the IDs of the calendars to read from sources that hold
+	// several, like google calendar. Google calendars can also be picked by
+	// name, which is how they were picked before they had settings.
+	Calendars []string `json:"calendars"`
+
+	// ICS are iCalendar feed URLs or file paths
+	ICS []string `json:"ics"`
+
+	CalDAV []CalDAVSettings `json:"caldav"`
+
+	// Emails are your addresses, to skip events you declined on ICS and
+	// CalDAV calendars
+	Emails []string `json:"emails"`
+
+	// Tomorrow adds a line about the first event tomorrow
+	Tomorrow bool `json:"tomorrow"`
+
+	// Lookahead is how far ahead of the alarm to read events. It is "today"
+	// for the rest of the day in the alarm's time zone, or a duration like
+	// "12h". Defaults to today.
+	Lookahead string `json:"lookahead"`
+}
+
+// CalDAVSettings point at a single CalDAV calendar collection
+type CalDAVSettings struct {
+	URL      string `json:"url"`
+	Username string `json:"username"`
+	Password string `json:"password"`
+}
+
+// New creates a calendar plugin that reads events from sources
+func New(sources ...Source) *Calendar {
+	return &Calendar{static: sources}
+}
+
+const pluginName = "calendar"
+
+// Name returns the name of the plugin
+func (c *Calendar) Name() string {
+	return pluginName
+}
+
+// Configure replaces the sources that were added through settings
+func (c *Calendar) Configure(raw json.RawMessage) error {
+	settings := Settings{}
+	if len(raw) > 0 {
+		err := json.Unmarshal(raw, &settings)
+		if err != nil {
+			return fmt.Errorf("decoding settings: %w", err)
+		}
 	}
 
-	config, err := google.ConfigFromJSON(b, calendar.CalendarReadonlyScope)
+	_, err := parseLookahead(settings.Lookahead)
 	if err != nil {
-		return Calendar{}, fmt.Errorf("google.ConfigFromJSON: %w", err)
+		return err
 	}
 
-	httpClient, err := util.GetOauthClient(context.TODO(), config, oauthCredFile)
-	if err != nil {
-		return Calendar{}, fmt.Errorf("GetOauthClient(): %w", err)
+	var sources []Source
+	for _, location := range settings.ICS {
+		if location == "" {
+			return errors.New("ics location is required")
+		}
+		sources = append(sources, NewICS(location))
+	}
+	for _, s := range settings.CalDAV {
+		if s.URL == "" {
+			return errors.New("caldav url is required")
+		}
+		sources = append(sources, NewCalDAV(s.URL, s.Username, s.Password))
+	}
+
+	for _, source := range c.static {
+		if l, ok := source.(Lister); ok {
+			l.Select(settings.Calendars)
+		}
 	}
 
-	srv, err := calendar.New(httpClient)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.configured = sources
+	c.settings = settings
+
+	return nil
+}
+
+// MaskSecrets masks CalDAV passwords
+func (c *Calendar) MaskSecrets(raw json.RawMessage) (json.RawMessage, error) {
+	settings := Settings{}
+	err := json.Unmarshal(raw, &settings)
 	if err != nil {
-		return Calendar{}, fmt.Errorf("calendar.New(): %w", err)
+		return nil, fmt.Errorf("decoding settings: %w", err)
 	}
 
-	cals := map[string]bool{}
-	for _, cal := range calendars {
-		cals[cal] = true
+	for i, s := range settings.CalDAV {
+		if s.Password != "" {
+			settings.CalDAV[i].Password = plugin.MaskedSecret
+		}
 	}
 
-	return Calendar{client: srv, calendars: cals}, nil
+	return json.Marshal(settings)
 }
 
-// Text returns a string of upcoming calendar events
-func (c Calendar) Text() (string, error) {
-	cals, err := c.client.CalendarList.List().ShowHidden(true).Do()
+// RestoreSecrets puts back masked CalDAV passwords, matching calendars by URL
+// and username
+func (c *Calendar) RestoreSecrets(raw, current json.RawMessage) (json.RawMessage, error) {
+	settings := Settings{}
+	err := json.Unmarshal(raw, &settings)
 	if err != nil {
-		return "", fmt.Errorf("fetching cals: %w", err)
+		return nil, fmt.Errorf("decoding settings: %w", err)
 	}
 
-	start := time.Now()
+	saved := Settings{}
+	if len(current) > 0 {
+		err = json.Unmarshal(current, &saved)
+		if err != nil {
+			return nil, fmt.Errorf("decoding current settings: %w", err)
+		}
+	}
+
+	masked := false
+	for i, s := range settings.CalDAV {
+		if s.Password != plugin.MaskedSecret {
+			continue
+		}
+
+		masked = true
+		found := false
+		for _, prev := range saved.CalDAV {
+			if prev.URL == s.URL && prev.Username == s.Username {
+				settings.CalDAV[i].Password = prev.Password
+				found = true
+				break
+			}
+		}
+		if !found {
+			return nil, fmt.Errorf("caldav %s has a masked password, but no password is saved for it", s.URL)
+		}
+	}
 
-	// go to midnight Local time
-	year, month, day := start.Date()
-	end := time.Date(year, month, day, 23, 59, 59, 0, time.Local)
+	if !masked {
+		return raw, nil
+	}
+	return json.Marshal(settings)
+}
 
-	var events []*calendar.Event
-	for _, cal := range cals.Items {
-		if _, ok := c.calendars[cal.Summary]; !ok {
+// Calendars lists the calendars of every source that holds several
+func (c *Calendar) Calendars(ctx context.Context) ([]Info, error) {
+	infos := []Info{}
+	for _, source := range c.sources() {
+		l, ok := source.(Lister)
+		if !ok {
 			continue
 		}
 
-		calEvents, err := c.client.Events.List(cal.Id).ShowDeleted(false).SingleEvents(true).
-			TimeMin(start.Format(time.RFC3339)).TimeMax(end.Format(time.RFC3339)).Do()
+		sourceInfos, err := l.Calendars(ctx)
 		if err != nil {
-			return "", fmt.Errorf("listing events: %w", err)
+			return nil, err
 		}
+		infos = append(infos, sourceInfos...)
+	}
 
-		for _, e := range calEvents.Items {
-			events = append(events, e)
-		}
+	return infos, nil
+}
+
+func (c *Calendar) sources() []Source {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	return append(append([]Source(nil), c.static...), c.configured...)
+}
+
+// Briefing is the data behind the spoken calendar briefing
+type Briefing struct {
+	Events    []Event    `json:"events"`
+	Conflicts []Conflict `json:"conflicts"`
+
+	// First is the first meeting that hasn't started yet
+	First *Event `json:"first"`
+
+	// Tomorrow is the first timed event tomorrow, if the preview is enabled
+	Tomorrow *Event `json:"tomorrow"`
+}
+
+// Generate returns the calendar events in the lookahead window from the alarm
+func (c *Calendar) Generate(ctx context.Context, run plugin.Run) (plugin.Output, error) {
+	settings := c.currentSettings()
+	w, err := newWindow(run, settings.Lookahead)
+	if err != nil {
+		return plugin.Output{}, err
 	}
 
-	if len(events) == 0 {
-		return "There are no calendar events today.", nil
+	events, err := c.events(ctx, w.Start, w.End, run.Location)
+	if err != nil {
+		return plugin.Output{}, err
 	}
+	events = visible(events, settings.Emails)
 
-	sort.Slice(events, func(i, j int) bool {
-		if events[i].Start.DateTime != "" && events[j].Start.DateTime != "" {
-			evI, _ := time.Parse(time.RFC3339, events[i].Start.DateTime)
-			evJ, _ := time.Parse(time.RFC3339, events[j].Start.DateTime)
-			return evI.Before(evJ)
+	b := Briefing{Events: events, Conflicts: conflicts(events)}
+	for i, e := range events {
+		if !e.AllDay && e.Start.After(w.Start) {
+			b.First = &events[i]
+			break
 		}
+	}
 
-		// if i is an all-day event
-		if events[i].Start.Date != "" {
-			return true
+	if settings.Tomorrow {
+		year, month, day := w.Start.Date()
+		tomorrowStart := time.Date(year, month, day+1, 0, 0, 0, 0, run.Location)
+		tomorrowEvents, err := c.events(ctx, tomorrowStart, tomorrowStart.AddDate(0, 0, 1), run.Location)
+		if err != nil {
+			log.Errorf("getting tomorrow's events: %s", err)
 		}
+		for _, e := range visible(tomorrowEvents, settings.Emails) {
+			if !e.AllDay && !e.Start.Before(tomorrowStart) {
+				e := e
+				b.Tomorrow = &e
+				break
+			}
+		}
+	}
+
+	str := say(b, w)
+	return plugin.Output{
+		Text: ssml.ToText(str),
+		SSML: str,
+		Data: b,
+	}, nil
+}
+
+func (c *Calendar) currentSettings() Settings {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	return c.settings
+}
 
-		return false
-	})
+// events collects events from every source. An unreachable calendar is
+// logged and read around, so one flaky ICS url doesn't hide the rest of the
+// day, but when nothing answers the briefing reports it.
+func (c *Calendar) events(ctx context.Context, start, end time.Time, loc *time.Location) ([]Event, error) {
+	sources := c.sources()
 
-	str := "Here are the upcoming calendar events for today. "
-	for _, item := range events {
-		if item.Start.DateTime == "" {
-			str += item.Summary + ". "
+	var events []Event
+	var lastErr error
+	failures := 0
+	for _, source := range sources {
+		sourceEvents, err := source.Events(ctx, start, end, loc)
+		if err != nil {
+			log.Errorf("listing calendar events: %s", err)
+			lastErr = err
+			failures++
 			continue
 		}
+		events = append(events, sourceEvents...)
+	}
+
+	if failures > 0 && failures == len(sources) {
+		return nil, fmt.Errorf("listing events: %w", lastErr)
+	}
+
+	return events, nil
+}
+
+// HandlerGetCalendars lists the calendars that can be selected
+func HandlerGetCalendars(ctx *fasthttp.RequestCtx) {
+	c, err := fromRegistry(ctx)
+	if err != nil {
+		log.Error(err)
+		ctx.Error(err.Error(), fasthttp.StatusInternalServerError)
+		return
+	}
+
+	infos, err := c.Calendars(context.TODO())
+	if err != nil {
+		err = fmt.Errorf("listing calendars: %w", err)
+		log.Error(err)
+		ctx.Error(err.Error(), fasthttp.StatusInternalServerError)
+		return
+	}
+
+	ctx.Response.SetStatusCode(fasthttp.StatusOK)
+	json.NewEncoder(ctx).Encode(infos)
+}
+
+// HandlerSetCalendars saves the selected calendar IDs into the plugin's settings
+func HandlerSetCalendars(ctx *fasthttp.RequestCtx) {
+	registry := requestcontext.Plugins(ctx)
+
+	var ids []string
+	err := json.Unmarshal(ctx.Request.Body(), &ids)
+	if err != nil {
+		err = fmt.Errorf("decoding body: %w", err)
+		log.Error(err)
+		ctx.Error(err.Error(), fasthttp.StatusBadRequest)
+		return
+	}
+
+	pluginSettings, err := registry.Get(pluginName)
+	if err != nil {
+		err = fmt.Errorf("getting plugin settings: %w", err)
+		log.Error(err)
+		ctx.Error(err.Error(), fasthttp.StatusInternalServerError)
+		return
+	}
+
+	settings := Settings{}
+	if len(pluginSettings.Settings) > 0 {
+		err = json.Unmarshal(pluginSettings.Settings, &settings)
+		if err != nil {
+			err = fmt.Errorf("decoding calendar settings: %w", err)
+			log.Error(err)
+			ctx.Error(err.Error(), fasthttp.StatusInternalServerError)
+			return
+		}
+	}
+	settings.Calendars = ids
+
+	pluginSettings.Settings, err = json.Marshal(settings)
+	if err != nil {
+		err = fmt.Errorf("encoding calendar settings: %w", err)
+		log.Error(err)
+		ctx.Error(err.Error(), fasthttp.StatusInternalServerError)
+		return
+	}
+
+	err = registry.Update(pluginSettings)
+	if err != nil {
+		err = fmt.Errorf("updating calendar settings: %w", err)
+		log.Error(err)
+		ctx.Error(err.Error(), fasthttp.StatusInternalServerError)
+		return
+	}
+
+	ctx.Response.SetStatusCode(fasthttp.StatusOK)
+	json.NewEncoder(ctx).Encode(ids)
+}
+
+// SeedCalendars picks calendars by name in the plugin's settings, if it has
+// no settings yet. It carries over the calendars that were picked in config
+// before they could be picked at runtime.
+func SeedCalendars(registry *plugin.Registry, names []string) error {
+	pluginSettings, err := registry.Get(pluginName)
+	if err != nil {
+		return fmt.Errorf("getting plugin settings: %w", err)
+	}
+	if len(pluginSettings.Settings) > 0 || len(names) == 0 {
+		return nil
+	}
+
+	pluginSettings.Settings, err = json.Marshal(Settings{Calendars: names})
+	if err != nil {
+		return fmt.Errorf("encoding calendar settings: %w", err)
+	}
+
+	err = registry.Update(pluginSettings)
+	if err != nil {
+		return fmt.Errorf("updating calendar settings: %w", err)
+	}
+
+	log.Infof("seeded calendar settings with the calendars %v from config", names)
+	return nil
+}
+
+func fromRegistry(ctx *fasthttp.RequestCtx) (*Calendar, error) {
+	p, err := requestcontext.Plugins(ctx).Plugin(pluginName)
+	if err != nil {
+		return nil, fmt.Errorf("getting calendar plugin: %w", err)
+	}
 
-		t, _ := time.Parse(time.RFC3339, item.Start.DateTime)
-		str += item.Summary + " at " + t.In(time.Local).Format("15:04") + ". "
+	c, ok := p.(*Calendar)
+	if !ok {
+		return nil, fmt.Errorf("plugin %s is a %T, not a calendar", pluginName, p)
 	}
 
-	return str, nil
+	return c, nil
 }
@@ -0,0 +1,92 @@
+package calendartest
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+)
+
+// CalDAVServer is a fake CalDAV calendar collection. It answers every
+// calendar-query REPORT with all of its objects, like a server that ignores
+// the time range, so clients have to filter the events themselves.
+type CalDAVServer struct {
+	*httptest.Server
+
+	username string
+	password string
+
+	mu      sync.Mutex
+	objects []string
+	queries []string
+}
+
+// NewCalDAVServer starts a fake collection that serves objects, which are
+// iCalendar documents, to clients that log in with username and password
+func NewCalDAVServer(username, password string, objects ...string) *CalDAVServer {
+	s := &CalDAVServer{username: username, password: password, objects: objects}
+	s.Server = httptest.NewServer(http.HandlerFunc(s.report))
+	return s
+}
+
+// Queries returns the body of every REPORT made so far
+func (s *CalDAVServer) Queries() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return append([]string(nil), s.queries...)
+}
+
+type multistatus struct {
+	XMLName   xml.Name   `xml:"D:multistatus"`
+	DAV       string     `xml:"xmlns:D,attr"`
+	CalDAV    string     `xml:"xmlns:C,attr"`
+	Responses []response `xml:"D:response"`
+}
+
+type response struct {
+	Href     string `xml:"D:href"`
+	Propstat struct {
+		Prop struct {
+			CalendarData string `xml:"C:calendar-data"`
+		} `xml:"D:prop"`
+		Status string `xml:"D:status"`
+	} `xml:"D:propstat"`
+}
+
+func (s *CalDAVServer) report(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "REPORT" {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if username, password, ok := r.BasicAuth(); !ok || username != s.username || password != s.password {
+		w.Header().Set("WWW-Authenticate", `Basic realm="calendartest"`)
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.queries = append(s.queries, string(body))
+
+	ms := multistatus{DAV: "DAV:", CalDAV: "urn:ietf:params:xml:ns:caldav"}
+	for i, object := range s.objects {
+		resp := response{Href: fmt.Sprintf("%sevent%d.ics", r.URL.Path, i)}
+		resp.Propstat.Prop.CalendarData = object
+		resp.Propstat.Status = "HTTP/1.1 200 OK"
+		ms.Responses = append(ms.Responses, resp)
+	}
+
+	w.Header().Set("Content-Type", "application/xml; charset=utf-8")
+	w.WriteHeader(http.StatusMultiStatus)
+	fmt.Fprint(w, xml.Header)
+	xml.NewEncoder(w).Encode(ms)
+}
@@ -0,0 +1,184 @@
+// Package calendartest fakes the Google Calendar API and CalDAV collections at
+// the HTTP level, so the calendar plugin can be exercised offline
+package calendartest
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Calendar is a calendar in the fake's calendar list
+type Calendar struct {
+	ID       string
+	Summary  string
+	Selected bool
+	Events   []Event
+}
+
+// Event is an event on a fake calendar. All-day events only use the dates of
+// Start and End.
+type Event struct {
+	ID       string
+	Summary  string
+	Location string
+	Start    time.Time
+	End      time.Time
+	AllDay   bool
+	Declined bool
+}
+
+// Server is a fake of the Google Calendar API. Point a calendar source at
+// BasePath to use it.
+type Server struct {
+	*httptest.Server
+
+	mu        sync.Mutex
+	calendars []Calendar
+	requests  []string
+}
+
+// NewServer starts a fake that serves calendars
+func NewServer(calendars ...Calendar) *Server {
+	s := &Server{calendars: calendars}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/calendar/v3/users/me/calendarList", s.calendarList)
+	mux.HandleFunc("/calendar/v3/calendars/", s.events)
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.mu.Lock()
+		s.requests = append(s.requests, r.URL.RequestURI())
+		s.mu.Unlock()
+		mux.ServeHTTP(w, r)
+	}))
+
+	return s
+}
+
+// BasePath is the API URL to hand to the calendar client
+func (s *Server) BasePath() string {
+	return s.URL + "/calendar/v3/"
+}
+
+// Requests returns the path and query of every request made so far
+func (s *Server) Requests() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return append([]string(nil), s.requests...)
+}
+
+// AddEvent adds an event to the calendar with the ID calendarID
+func (s *Server) AddEvent(calendarID string, e Event) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for i := range s.calendars {
+		if s.calendars[i].ID == calendarID {
+			s.calendars[i].Events = append(s.calendars[i].Events, e)
+		}
+	}
+}
+
+func (s *Server) calendarList(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	items := []map[string]interface{}{}
+	for _, c := range s.calendars {
+		items = append(items, map[string]interface{}{
+			"id":       c.ID,
+			"summary":  c.Summary,
+			"selected": c.Selected,
+		})
+	}
+
+	writeJSON(w, map[string]interface{}{
+		"kind":  "calendar#calendarList",
+		"items": items,
+	})
+}
+
+// events serves /calendar/v3/calendars/{id}/events, filtered like the real
+// API: events that end after timeMin and start before timeMax
+func (s *Server) events(w http.ResponseWriter, r *http.Request) {
+	id := strings.TrimSuffix(strings.TrimPrefix(r.URL.Path, "/calendar/v3/calendars/"), "/events")
+
+	var timeMin, timeMax time.Time
+	var err error
+	if v := r.URL.Query().Get("timeMin"); v != "" {
+		timeMin, err = time.Parse(time.RFC3339, v)
+		if err != nil {
+			http.Error(w, `{"error":{"code":400,"message":"bad timeMin"}}`, http.StatusBadRequest)
+			return
+		}
+	}
+	if v := r.URL.Query().Get("timeMax"); v != "" {
+		timeMax, err = time.Parse(time.RFC3339, v)
+		if err != nil {
+			http.Error(w, `{"error":{"code":400,"message":"bad timeMax"}}`, http.StatusBadRequest)
+			return
+		}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, c := range s.calendars {
+		if c.ID != id {
+			continue
+		}
+
+		items := []map[string]interface{}{}
+		for _, e := range c.Events {
+			if !timeMin.IsZero() && !e.End.After(timeMin) {
+				continue
+			}
+			if !timeMax.IsZero() && !e.Start.Before(timeMax) {
+				continue
+			}
+			items = append(items, event(e))
+		}
+
+		writeJSON(w, map[string]interface{}{
+			"kind":  "calendar#events",
+			"items": items,
+		})
+		return
+	}
+
+	http.Error(w, `{"error":{"code":404,"message":"Not Found"}}`, http.StatusNotFound)
+}
+
+func event(e Event) map[string]interface{} {
+	item := map[string]interface{}{
+		"id":       e.ID,
+		"summary":  e.Summary,
+		"location": e.Location,
+		"status":   "confirmed",
+	}
+
+	if e.AllDay {
+		item["start"] = map[string]string{"date": e.Start.Format("2006-01-02")}
+		item["end"] = map[string]string{"date": e.End.Format("2006-01-02")}
+	} else {
+		item["start"] = map[string]string{"dateTime": e.Start.Format(time.RFC3339)}
+		item["end"] = map[string]string{"dateTime": e.End.Format(time.RFC3339)}
+	}
+
+	if e.Declined {
+		item["attendees"] = []map[string]interface{}{
+			{"email": "me@example.com", "self": true, "responseStatus": "declined"},
+		}
+	}
+
+	return item
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(v)
+}
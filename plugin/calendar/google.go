@@ -0,0 +1,173 @@
+package calendar
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"sync"
+	"time"
+
+	"golang.org/x/oauth2/google"
+	"google.golang.org/api/calendar/v3"
+
+	"github.com/jchorl/gowaker/util"
+)
+
+// Google is a source of events from google calendar
+type Google struct {
+	client *calendar.Service
+
+	mu sync.RWMutex
+	// selected are calendar IDs, or names as calendars were picked in config
+	// before they had settings. When empty, the calendars that are selected in
+	// the google calendar UI are used.
+	selected map[string]bool
+}
+
+// NewGoogle creates a google calendar source
+func NewGoogle(oauthConfigFile, oauthCredFile string) (*Google, error) {
+	b, err := ioutil.ReadFile(oauthConfigFile)
+	if err != nil {
+		return nil, fmt.Errorf("reading %s: %w", oauthConfigFile, err)
+	}
+
+	config, err := google.ConfigFromJSON(b, calendar.CalendarReadonlyScope)
+	if err != nil {
+		return nil, fmt.Errorf("google.ConfigFromJSON: %w", err)
+	}
+
+	httpClient, err := util.GetOauthClient(context.TODO(), config, oauthCredFile)
+	if err != nil {
+		return nil, fmt.Errorf("GetOauthClient(): %w", err)
+	}
+
+	return NewGoogleClient(httpClient, "")
+}
+
+// NewGoogleClient creates a google calendar source that makes requests with
+// httpClient. basePath overrides the API's URL, e.g. to point at a fake.
+func NewGoogleClient(httpClient *http.Client, basePath string) (*Google, error) {
+	srv, err := calendar.New(httpClient)
+	if err != nil {
+		return nil, fmt.Errorf("calendar.New(): %w", err)
+	}
+	if basePath != "" {
+		srv.BasePath = basePath
+	}
+
+	return &Google{client: srv}, nil
+}
+
+// Select picks calendars by ID, or by name
+func (g *Google) Select(ids []string) {
+	selected := map[string]bool{}
+	for _, id := range ids {
+		selected[id] = true
+	}
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.selected = selected
+}
+
+func (g *Google) isSelected(cal *calendar.CalendarListEntry) bool {
+	g.mu.RLock()
+	defer g.mu.RUnlock()
+
+	if len(g.selected) == 0 {
+		return cal.Selected
+	}
+	return g.selected[cal.Id] || g.selected[cal.Summary]
+}
+
+// Calendars lists every calendar in the user's calendar list
+func (g *Google) Calendars(ctx context.Context) ([]Info, error) {
+	cals, err := g.client.CalendarList.List().ShowHidden(true).Context(ctx).Do()
+	if err != nil {
+		return nil, fmt.Errorf("fetching cals: %w", err)
+	}
+
+	infos := make([]Info, 0, len(cals.Items))
+	for _, cal := range cals.Items {
+		name := cal.Summary
+		if cal.SummaryOverride != "" {
+			name = cal.SummaryOverride
+		}
+
+		infos = append(infos, Info{
+			ID:       cal.Id,
+			Name:     name,
+			Selected: g.isSelected(cal),
+		})
+	}
+
+	return infos, nil
+}
+
+// Events returns the events of every selected calendar
+func (g *Google) Events(ctx context.Context, start, end time.Time, loc *time.Location) ([]Event, error) {
+	cals, err := g.client.CalendarList.List().ShowHidden(true).Context(ctx).Do()
+	if err != nil {
+		return nil, fmt.Errorf("fetching cals: %w", err)
+	}
+
+	var events []Event
+	for _, cal := range cals.Items {
+		if !g.isSelected(cal) {
+			continue
+		}
+
+		calEvents, err := g.client.Events.List(cal.Id).ShowDeleted(false).SingleEvents(true).
+			TimeMin(start.Format(time.RFC3339)).TimeMax(end.Format(time.RFC3339)).Context(ctx).Do()
+		if err != nil {
+			return nil, fmt.Errorf("listing events: %w", err)
+		}
+
+		for _, item := range calEvents.Items {
+			event, err := googleEvent(item, loc)
+			if err != nil {
+				return nil, fmt.Errorf("parsing event %s: %w", item.Id, err)
+			}
+			events = append(events, event)
+		}
+	}
+
+	return events, nil
+}
+
+func googleEvent(item *calendar.Event, loc *time.Location) (Event, error) {
+	event := Event{Summary: item.Summary, Location: item.Location}
+	for _, attendee := range item.Attendees {
+		if attendee.Self && attendee.ResponseStatus == "declined" {
+			event.Declined = true
+		}
+	}
+
+	// all-day events only have dates
+	if item.Start.DateTime == "" {
+		start, err := time.ParseInLocation("2006-01-02", item.Start.Date, loc)
+		if err != nil {
+			return Event{}, fmt.Errorf("parsing start date: %w", err)
+		}
+		end, err := time.ParseInLocation("2006-01-02", item.End.Date, loc)
+		if err != nil {
+			return Event{}, fmt.Errorf("parsing end date: %w", err)
+		}
+
+		event.Start, event.End, event.AllDay = start, end, true
+		return event, nil
+	}
+
+	start, err := time.Parse(time.RFC3339, item.Start.DateTime)
+	if err != nil {
+		return Event{}, fmt.Errorf("parsing start: %w", err)
+	}
+	end, err := time.Parse(time.RFC3339, item.End.DateTime)
+	if err != nil {
+		return Event{}, fmt.Errorf("parsing end: %w", err)
+	}
+
+	event.Start, event.End = start.In(loc), end.In(loc)
+	return event, nil
+}
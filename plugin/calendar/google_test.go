@@ -0,0 +1,183 @@
+package calendar
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jchorl/gowaker/plugin"
+	"github.com/jchorl/gowaker/plugin/calendar/calendartest"
+)
+
+func newTestGoogle(t *testing.T, calendars ...calendartest.Calendar) (*Google, *calendartest.Server) {
+	t.Helper()
+
+	server := calendartest.NewServer(calendars...)
+	t.Cleanup(server.Close)
+
+	g, err := NewGoogleClient(server.Client(), server.BasePath())
+	if err != nil {
+		t.Fatalf("creating google source: %s", err)
+	}
+
+	return g, server
+}
+
+func loadLocation(t *testing.T) *time.Location {
+	t.Helper()
+
+	loc, err := time.LoadLocation("America/Toronto")
+	if err != nil {
+		t.Fatalf("loading location: %s", err)
+	}
+	return loc
+}
+
+// eventRequests returns the IDs of the calendars that events were requested from
+func eventRequests(server *calendartest.Server) []string {
+	var ids []string
+	for _, r := range server.Requests() {
+		if strings.HasPrefix(r, "/calendar/v3/calendars/") {
+			path := strings.SplitN(r, "?", 2)[0]
+			ids = append(ids, strings.TrimSuffix(strings.TrimPrefix(path, "/calendar/v3/calendars/"), "/events"))
+		}
+	}
+	return ids
+}
+
+func TestGoogleIsSelected(t *testing.T) {
+	g, server := newTestGoogle(t,
+		calendartest.Calendar{ID: "work", Summary: "Work", Selected: true},
+		calendartest.Calendar{ID: "holidays", Summary: "Holidays"},
+	)
+	ctx := context.Background()
+	start := time.Now()
+
+	infos, err := g.Calendars(ctx)
+	if err != nil {
+		t.Fatalf("listing calendars: %s", err)
+	}
+	if len(infos) != 2 || !infos[0].Selected || infos[1].Selected {
+		t.Errorf("got calendars %+v, want only the one selected in the UI", infos)
+	}
+
+	_, err = g.Events(ctx, start, start.Add(time.Hour), time.UTC)
+	if err != nil {
+		t.Fatalf("listing events: %s", err)
+	}
+	if ids := eventRequests(server); len(ids) != 1 || ids[0] != "work" {
+		t.Errorf("read calendars %v, want work", ids)
+	}
+
+	g.Select([]string{"holidays"})
+	infos, err = g.Calendars(ctx)
+	if err != nil {
+		t.Fatalf("listing calendars: %s", err)
+	}
+	if infos[0].Selected || !infos[1].Selected {
+		t.Errorf("got calendars %+v, want only the picked one selected", infos)
+	}
+
+	_, err = g.Events(ctx, start, start.Add(time.Hour), time.UTC)
+	if err != nil {
+		t.Fatalf("listing events: %s", err)
+	}
+	if ids := eventRequests(server); len(ids) != 2 || ids[1] != "holidays" {
+		t.Errorf("read calendars %v, want holidays after picking it", ids)
+	}
+
+	// calendars picked in config before they had settings are picked by name
+	g.Select([]string{"Holidays"})
+	infos, err = g.Calendars(ctx)
+	if err != nil {
+		t.Fatalf("listing calendars: %s", err)
+	}
+	if infos[0].Selected || !infos[1].Selected {
+		t.Errorf("got calendars %+v, want holidays picked by name", infos)
+	}
+
+	// picking nothing goes back to the UI's selection
+	g.Select(nil)
+	infos, err = g.Calendars(ctx)
+	if err != nil {
+		t.Fatalf("listing calendars: %s", err)
+	}
+	if !infos[0].Selected || infos[1].Selected {
+		t.Errorf("got calendars %+v, want the UI's selection back", infos)
+	}
+}
+
+func TestGoogleEvents(t *testing.T) {
+	loc := loadLocation(t)
+	day := time.Date(2021, time.March, 3, 0, 0, 0, 0, loc)
+
+	g, _ := newTestGoogle(t, calendartest.Calendar{
+		ID:       "work",
+		Selected: true,
+		Events: []calendartest.Event{
+			{ID: "standup", Summary: "Standup", Location: "Room 1", Start: day.Add(9 * time.Hour), End: day.Add(9*time.Hour + 15*time.Minute)},
+			{ID: "offsite", Summary: "Offsite", Start: day, End: day.AddDate(0, 0, 1), AllDay: true},
+			{ID: "review", Summary: "Review", Start: day.Add(14 * time.Hour), End: day.Add(15 * time.Hour), Declined: true},
+			{ID: "tomorrow", Summary: "Tomorrow", Start: day.Add(33 * time.Hour), End: day.Add(34 * time.Hour)},
+		},
+	})
+
+	events, err := g.Events(context.Background(), day.Add(7*time.Hour), day.AddDate(0, 0, 1), loc)
+	if err != nil {
+		t.Fatalf("listing events: %s", err)
+	}
+	if len(events) != 3 {
+		t.Fatalf("got events %+v, want the three today", events)
+	}
+
+	standup := events[0]
+	if standup.Summary != "Standup" || standup.Location != "Room 1" || standup.AllDay || standup.Declined {
+		t.Errorf("got standup %+v", standup)
+	}
+	if !standup.Start.Equal(day.Add(9*time.Hour)) || standup.Start.Location() != loc {
+		t.Errorf("standup starts at %s, want 9am in %s", standup.Start, loc)
+	}
+
+	offsite := events[1]
+	if !offsite.AllDay || !offsite.Start.Equal(day) || !offsite.End.Equal(day.AddDate(0, 0, 1)) {
+		t.Errorf("got offsite %+v, want all day in %s", offsite, loc)
+	}
+
+	if review := events[2]; !review.Declined {
+		t.Errorf("got review %+v, want it declined", review)
+	}
+}
+
+func TestGoogleBriefingDedupes(t *testing.T) {
+	loc := loadLocation(t)
+	day := time.Date(2021, time.March, 3, 0, 0, 0, 0, loc)
+	planning := calendartest.Event{ID: "planning", Summary: "Planning", Start: day.Add(10 * time.Hour), End: day.Add(11 * time.Hour)}
+
+	g, _ := newTestGoogle(t,
+		calendartest.Calendar{ID: "work", Selected: true, Events: []calendartest.Event{
+			planning,
+			{ID: "review", Summary: "Review", Start: day.Add(14 * time.Hour), End: day.Add(15 * time.Hour), Declined: true},
+		}},
+		calendartest.Calendar{ID: "team", Selected: true, Events: []calendartest.Event{
+			planning,
+			{ID: "offsite", Summary: "Offsite", Start: day, End: day.AddDate(0, 0, 1), AllDay: true},
+		}},
+	)
+
+	output, err := New(g).Generate(context.Background(), plugin.Run{Scheduled: day.Add(7 * time.Hour), Location: loc})
+	if err != nil {
+		t.Fatalf("generating briefing: %s", err)
+	}
+
+	b := output.Data.(Briefing)
+	if len(b.Events) != 2 || b.Events[0].Summary != "Offsite" || b.Events[1].Summary != "Planning" {
+		t.Errorf("got events %+v, want the all-day offsite then planning once, without the declined review", b.Events)
+	}
+	if b.First == nil || b.First.Summary != "Planning" {
+		t.Errorf("got first meeting %+v, want planning", b.First)
+	}
+	if strings.Count(output.Text, "Planning") != 1 || strings.Contains(output.Text, "Review") {
+		t.Errorf("got briefing %q, want planning read once and no review", output.Text)
+	}
+}
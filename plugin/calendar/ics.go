@@ -0,0 +1,428 @@
+package calendar
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/teambition/rrule-go"
+)
+
+// ICS is a source of events from an iCalendar feed, either a URL or a local file
+type ICS struct {
+	// Location is an http(s) URL or a file path
+	Location string
+	Client   *http.Client
+}
+
+// NewICS creates an iCalendar source
+func NewICS(location string) *ICS {
+	return &ICS{Location: location, Client: &http.Client{}}
+}
+
+// Events returns the events in the feed that overlap [start, end)
+func (i *ICS) Events(ctx context.Context, start, end time.Time, loc *time.Location) ([]Event, error) {
+	r, err := i.open(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+
+	vevents, err := parseICS(r)
+	if err != nil {
+		return nil, fmt.Errorf("parsing %s: %w", i.Location, err)
+	}
+
+	return expand(vevents, start, end, loc)
+}
+
+func (i *ICS) open(ctx context.Context) (io.ReadCloser, error) {
+	if !strings.HasPrefix(i.Location, "http://") && !strings.HasPrefix(i.Location, "https://") {
+		f, err := os.Open(i.Location)
+		if err != nil {
+			return nil, fmt.Errorf("opening %s: %w", i.Location, err)
+		}
+		return f, nil
+	}
+
+	req, err := http.NewRequest(http.MethodGet, i.Location, nil)
+	if err != nil {
+		return nil, fmt.Errorf("creating request: %w", err)
+	}
+
+	resp, err := i.Client.Do(req.WithContext(ctx))
+	if err != nil {
+		return nil, fmt.Errorf("fetching %s: %w", i.Location, err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		return nil, fmt.Errorf("fetching %s: unexpected status %s", i.Location, resp.Status)
+	}
+
+	return resp.Body, nil
+}
+
+// property is a single content line of an iCalendar object
+type property struct {
+	params map[string]string
+	value  string
+}
+
+// vevent holds the properties of a VEVENT by name. Properties like EXDATE can
+// appear multiple times.
+type vevent map[string][]property
+
+func (v vevent) get(name string) (property, bool) {
+	props := v[name]
+	if len(props) == 0 {
+		return property{}, false
+	}
+	return props[0], true
+}
+
+// parseICS parses the VEVENTs out of an iCalendar stream (RFC 5545)
+func parseICS(r io.Reader) ([]vevent, error) {
+	lines, err := unfold(r)
+	if err != nil {
+		return nil, err
+	}
+
+	var vevents []vevent
+	var current vevent
+	depth := 0 // of components nested in the current VEVENT, e.g. VALARM
+	for _, line := range lines {
+		name, prop, err := parseLine(line)
+		if err != nil {
+			return nil, err
+		}
+
+		switch {
+		case name == "BEGIN" && prop.value == "VEVENT" && current == nil:
+			current = vevent{}
+		case name == "BEGIN" && current != nil:
+			depth++
+		case name == "END" && current != nil && depth > 0:
+			depth--
+		case name == "END" && prop.value == "VEVENT" && current != nil:
+			vevents = append(vevents, current)
+			current = nil
+		case current != nil && depth == 0:
+			current[name] = append(current[name], prop)
+		}
+	}
+
+	return vevents, nil
+}
+
+// unfold joins lines that were folded onto continuation lines
+func unfold(r io.Reader) ([]string, error) {
+	var lines []string
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		line := strings.TrimRight(scanner.Text(), "\r")
+		if (strings.HasPrefix(line, " ") || strings.HasPrefix(line, "\t")) && len(lines) > 0 {
+			lines[len(lines)-1] += line[1:]
+			continue
+		}
+		if line != "" {
+			lines = append(lines, line)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("reading lines: %w", err)
+	}
+
+	return lines, nil
+}
+
+// parseLine parses a content line like DTSTART;TZID=America/Toronto:20200302T090000
+func parseLine(line string) (string, property, error) {
+	// the value starts at the first colon that isn't in a quoted param value
+	inQuotes := false
+	colon := -1
+	for i, c := range line {
+		if c == '"' {
+			inQuotes = !inQuotes
+		} else if c == ':' && !inQuotes {
+			colon = i
+			break
+		}
+	}
+	if colon < 0 {
+		return "", property{}, fmt.Errorf("malformed line %q", line)
+	}
+
+	prop := property{params: map[string]string{}, value: line[colon+1:]}
+	parts := strings.Split(line[:colon], ";")
+	for _, param := range parts[1:] {
+		kv := strings.SplitN(param, "=", 2)
+		if len(kv) == 2 {
+			prop.params[strings.ToUpper(kv[0])] = strings.Trim(kv[1], `"`)
+		}
+	}
+
+	return strings.ToUpper(parts[0]), prop, nil
+}
+
+var textUnescaper = strings.NewReplacer(`\n`, " ", `\N`, " ", `\,`, ",", `\;`, ";", `\\`, `\`)
+
+// parseTime parses a DATE or DATE-TIME property. Floating times and dates are in loc.
+func parseTime(prop property, loc *time.Location) (t time.Time, allDay bool, err error) {
+	if prop.params["VALUE"] == "DATE" || len(prop.value) == len("20060102") {
+		t, err = time.ParseInLocation("20060102", prop.value, loc)
+		return t, true, err
+	}
+
+	if strings.HasSuffix(prop.value, "Z") {
+		t, err = time.Parse("20060102T150405Z", prop.value)
+		return t.In(loc), false, err
+	}
+
+	if tzid, ok := prop.params["TZID"]; ok {
+		tz, tzErr := time.LoadLocation(tzid)
+		if tzErr == nil {
+			loc = tz
+		}
+	}
+
+	t, err = time.ParseInLocation("20060102T150405", prop.value, loc)
+	return t, false, err
+}
+
+// parseTimes parses a property that holds a comma separated list of times, like EXDATE
+func parseTimes(prop property, loc *time.Location) ([]time.Time, error) {
+	var times []time.Time
+	for _, value := range strings.Split(prop.value, ",") {
+		t, _, err := parseTime(property{params: prop.params, value: value}, loc)
+		if err != nil {
+			return nil, err
+		}
+		times = append(times, t)
+	}
+	return times, nil
+}
+
+// expand turns VEVENTs into the occurrences that overlap [start, end)
+func expand(vevents []vevent, start, end time.Time, loc *time.Location) ([]Event, error) {
+	// occurrences of a recurring event can be overridden by another VEVENT
+	// with the same UID and a RECURRENCE-ID of the original start
+	overridden := map[string]map[int64]bool{}
+	for _, v := range vevents {
+		uid, _ := v.get("UID")
+		recurrenceID, ok := v.get("RECURRENCE-ID")
+		if !ok {
+			continue
+		}
+
+		t, _, err := parseTime(recurrenceID, loc)
+		if err != nil {
+			return nil, fmt.Errorf("parsing RECURRENCE-ID of %s: %w", uid.value, err)
+		}
+		if overridden[uid.value] == nil {
+			overridden[uid.value] = map[int64]bool{}
+		}
+		overridden[uid.value][t.Unix()] = true
+	}
+
+	var events []Event
+	for _, v := range vevents {
+		if status, ok := v.get("STATUS"); ok && strings.EqualFold(status.value, "CANCELLED") {
+			continue
+		}
+
+		occurrences, err := occurrences(v, start, end, loc)
+		if err != nil {
+			uid, _ := v.get("UID")
+			return nil, fmt.Errorf("expanding %s: %w", uid.value, err)
+		}
+
+		uid, _ := v.get("UID")
+		_, isOverride := v.get("RECURRENCE-ID")
+		for _, e := range occurrences {
+			if !isOverride && overridden[uid.value][e.Start.Unix()] {
+				continue
+			}
+			events = append(events, e)
+		}
+	}
+
+	return events, nil
+}
+
+// occurrences returns the occurrences of a single VEVENT that overlap [start, end)
+func occurrences(v vevent, start, end time.Time, loc *time.Location) ([]Event, error) {
+	dtstart, ok := v.get("DTSTART")
+	if !ok {
+		return nil, fmt.Errorf("missing DTSTART")
+	}
+
+	first, allDay, err := parseTime(dtstart, loc)
+	if err != nil {
+		return nil, fmt.Errorf("parsing DTSTART: %w", err)
+	}
+
+	duration, err := eventDuration(v, first, allDay, loc)
+	if err != nil {
+		return nil, err
+	}
+
+	summary := ""
+	if s, ok := v.get("SUMMARY"); ok {
+		summary = textUnescaper.Replace(s.value)
+	}
+
+	location := ""
+	if l, ok := v.get("LOCATION"); ok {
+		location = textUnescaper.Replace(l.value)
+	}
+
+	var declinedBy []string
+	for _, attendee := range v["ATTENDEE"] {
+		if strings.EqualFold(attendee.params["PARTSTAT"], "DECLINED") {
+			address := strings.TrimPrefix(strings.ToLower(attendee.value), "mailto:")
+			declinedBy = append(declinedBy, address)
+		}
+	}
+
+	starts := []time.Time{first}
+	if rule, ok := v.get("RRULE"); ok {
+		starts, err = recurrences(v, rule, first, start.Add(-duration), end)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	var events []Event
+	for _, s := range starts {
+		e := s.Add(duration)
+		if allDay {
+			// keep all-day events on whole days, even across daylight saving changes
+			days := int(duration.Hours()/24 + 0.5)
+			e = s.AddDate(0, 0, days)
+		}
+
+		if !e.After(start) || !s.Before(end) {
+			continue
+		}
+
+		events = append(events, Event{
+			Summary:    summary,
+			Location:   location,
+			Start:      s.In(loc),
+			End:        e.In(loc),
+			AllDay:     allDay,
+			declinedBy: declinedBy,
+		})
+	}
+
+	return events, nil
+}
+
+func eventDuration(v vevent, first time.Time, allDay bool, loc *time.Location) (time.Duration, error) {
+	if dtend, ok := v.get("DTEND"); ok {
+		last, _, err := parseTime(dtend, loc)
+		if err != nil {
+			return 0, fmt.Errorf("parsing DTEND: %w", err)
+		}
+		return last.Sub(first), nil
+	}
+
+	if duration, ok := v.get("DURATION"); ok {
+		d, err := parseDuration(duration.value)
+		if err != nil {
+			return 0, fmt.Errorf("parsing DURATION: %w", err)
+		}
+		return d, nil
+	}
+
+	// without an end, all-day events last the day and others are instantaneous
+	if allDay {
+		return 24 * time.Hour, nil
+	}
+	return 0, nil
+}
+
+// recurrences returns the starts of a recurring event's occurrences between after and before
+func recurrences(v vevent, rule property, first, after, before time.Time) ([]time.Time, error) {
+	opt, err := rrule.StrToROptionInLocation(rule.value, first.Location())
+	if err != nil {
+		return nil, fmt.Errorf("parsing RRULE: %w", err)
+	}
+	opt.Dtstart = first
+
+	r, err := rrule.NewRRule(*opt)
+	if err != nil {
+		return nil, fmt.Errorf("creating rrule: %w", err)
+	}
+
+	set := rrule.Set{}
+	set.RRule(r)
+
+	for _, prop := range v["RDATE"] {
+		rdates, err := parseTimes(prop, first.Location())
+		if err != nil {
+			return nil, fmt.Errorf("parsing RDATE: %w", err)
+		}
+		for _, t := range rdates {
+			set.RDate(t)
+		}
+	}
+
+	for _, prop := range v["EXDATE"] {
+		exdates, err := parseTimes(prop, first.Location())
+		if err != nil {
+			return nil, fmt.Errorf("parsing EXDATE: %w", err)
+		}
+		for _, t := range exdates {
+			set.ExDate(t)
+		}
+	}
+
+	return set.Between(after, before, true), nil
+}
+
+// parseDuration parses an RFC 5545 duration like P1DT2H30M or PT15M
+func parseDuration(s string) (time.Duration, error) {
+	sign := time.Duration(1)
+	if strings.HasPrefix(s, "-") {
+		sign = -1
+	}
+	s = strings.TrimLeft(s, "+-")
+	if !strings.HasPrefix(s, "P") {
+		return 0, fmt.Errorf("malformed duration %q", s)
+	}
+
+	var d time.Duration
+	var n int
+	inTime := false
+	for _, c := range s[1:] {
+		switch {
+		case c >= '0' && c <= '9':
+			n = n*10 + int(c-'0')
+			continue
+		case c == 'T':
+			inTime = true
+		case c == 'W':
+			d += time.Duration(n) * 7 * 24 * time.Hour
+		case c == 'D':
+			d += time.Duration(n) * 24 * time.Hour
+		case c == 'H' && inTime:
+			d += time.Duration(n) * time.Hour
+		case c == 'M' && inTime:
+			d += time.Duration(n) * time.Minute
+		case c == 'S' && inTime:
+			d += time.Duration(n) * time.Second
+		default:
+			return 0, fmt.Errorf("malformed duration %q", s)
+		}
+		n = 0
+	}
+
+	return sign * d, nil
+}
@@ -0,0 +1,188 @@
+package calendar
+
+import (
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestUnfold(t *testing.T) {
+	tests := []struct {
+		name string
+		in   string
+		want []string
+	}{
+		{"crlf", "BEGIN:VEVENT\r\nSUMMARY:Standup\r\nEND:VEVENT\r\n", []string{"BEGIN:VEVENT", "SUMMARY:Standup", "END:VEVENT"}},
+		{"lf", "BEGIN:VEVENT\nEND:VEVENT", []string{"BEGIN:VEVENT", "END:VEVENT"}},
+		{"space", "SUMMARY:A very\r\n  long summary\r\n", []string{"SUMMARY:A very long summary"}},
+		{"tab", "SUMMARY:Split\r\n\tin the mid\r\n dle\r\n", []string{"SUMMARY:Splitin the middle"}},
+		{"blank lines", "BEGIN:VEVENT\r\n\r\nEND:VEVENT\r\n\r\n", []string{"BEGIN:VEVENT", "END:VEVENT"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := unfold(strings.NewReader(tt.in))
+			if err != nil {
+				t.Fatalf("unfolding: %s", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseTime(t *testing.T) {
+	loc := loadLocation(t)
+	london, err := time.LoadLocation("Europe/London")
+	if err != nil {
+		t.Fatalf("loading location: %s", err)
+	}
+
+	tests := []struct {
+		name       string
+		line       string
+		want       time.Time
+		wantAllDay bool
+	}{
+		{"tzid", "DTSTART;TZID=Europe/London:20210301T090000", time.Date(2021, 3, 1, 9, 0, 0, 0, london), false},
+		{"quoted tzid", `DTSTART;TZID="Europe/London":20210301T090000`, time.Date(2021, 3, 1, 9, 0, 0, 0, london), false},
+		{"unknown tzid", "DTSTART;TZID=Mars/Olympus:20210301T090000", time.Date(2021, 3, 1, 9, 0, 0, 0, loc), false},
+		{"utc", "DTSTART:20210301T140000Z", time.Date(2021, 3, 1, 14, 0, 0, 0, time.UTC), false},
+		{"floating", "DTSTART:20210301T090000", time.Date(2021, 3, 1, 9, 0, 0, 0, loc), false},
+		{"date", "DTSTART;VALUE=DATE:20210301", time.Date(2021, 3, 1, 0, 0, 0, 0, loc), true},
+		{"bare date", "DTSTART:20210301", time.Date(2021, 3, 1, 0, 0, 0, 0, loc), true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, prop, err := parseLine(tt.line)
+			if err != nil {
+				t.Fatalf("parsing line: %s", err)
+			}
+
+			got, allDay, err := parseTime(prop, loc)
+			if err != nil {
+				t.Fatalf("parsing time: %s", err)
+			}
+			if !got.Equal(tt.want) || allDay != tt.wantAllDay {
+				t.Errorf("got %s, all day %t, want %s, all day %t", got, allDay, tt.want, tt.wantAllDay)
+			}
+		})
+	}
+}
+
+// testICS has a weekly standup with an exception, an override and an extra
+// date, plus one-off events in and out of the window
+const testICS = `BEGIN:VCALENDAR
+VERSION:2.0
+PRODID:-//gowaker//test//EN
+BEGIN:VEVENT
+UID:standup
+SUMMARY:Standup
+DTSTART;TZID=America/Toronto:20210301T090000
+DTEND;TZID=America/Toronto:20210301T093000
+RRULE:FREQ=WEEKLY;BYDAY=MO,WE
+EXDATE;TZID=America/Toronto:20210303T090000
+RDATE;TZID=America/Toronto:20210312T090000
+BEGIN:VALARM
+ACTION:DISPLAY
+SUMMARY:Not the event's summary
+TRIGGER:-PT10M
+END:VALARM
+END:VEVENT
+BEGIN:VEVENT
+UID:standup
+RECURRENCE-ID;TZID=America/Toronto:20210308T090000
+SUMMARY:Standup\, moved
+DTSTART;TZID=America/Toronto:20210308T110000
+DTEND;TZID=America/Toronto:20210308T113000
+END:VEVENT
+BEGIN:VEVENT
+UID:holiday
+SUMMARY:Holi
+ day
+DTSTART;VALUE=DATE:20210305
+DTEND;VALUE=DATE:20210306
+END:VEVENT
+BEGIN:VEVENT
+UID:call
+SUMMARY:Call
+LOCATION:Room 1\; east
+DTSTART:20210302T150000Z
+DURATION:PT1H
+END:VEVENT
+BEGIN:VEVENT
+UID:cancelled
+SUMMARY:Cancelled
+STATUS:CANCELLED
+DTSTART:20210302T150000Z
+END:VEVENT
+BEGIN:VEVENT
+UID:later
+SUMMARY:Later
+DTSTART:20210401T150000Z
+END:VEVENT
+END:VCALENDAR
+`
+
+func TestExpand(t *testing.T) {
+	loc := loadLocation(t)
+	at := func(day, hour, min int) time.Time {
+		return time.Date(2021, 3, day, hour, min, 0, 0, loc)
+	}
+
+	vevents, err := parseICS(strings.NewReader(strings.ReplaceAll(testICS, "\n", "\r\n")))
+	if err != nil {
+		t.Fatalf("parsing: %s", err)
+	}
+
+	events, err := expand(vevents, at(1, 0, 0), at(15, 0, 0), loc)
+	if err != nil {
+		t.Fatalf("expanding: %s", err)
+	}
+	sort.Slice(events, func(i, j int) bool { return events[i].Start.Before(events[j].Start) })
+
+	want := []Event{
+		{Summary: "Standup", Start: at(1, 9, 0), End: at(1, 9, 30)},
+		{Summary: "Call", Location: "Room 1; east", Start: at(2, 10, 0), End: at(2, 11, 0)},
+		{Summary: "Holiday", Start: at(5, 0, 0), End: at(6, 0, 0), AllDay: true},
+		{Summary: "Standup, moved", Start: at(8, 11, 0), End: at(8, 11, 30)},
+		{Summary: "Standup", Start: at(10, 9, 0), End: at(10, 9, 30)},
+		{Summary: "Standup", Start: at(12, 9, 0), End: at(12, 9, 30)},
+	}
+
+	if len(events) != len(want) {
+		t.Fatalf("got %d events %+v, want %d", len(events), events, len(want))
+	}
+	for i := range want {
+		got := events[i]
+		if got.Summary != want[i].Summary || got.Location != want[i].Location || !got.Start.Equal(want[i].Start) || !got.End.Equal(want[i].End) || got.AllDay != want[i].AllDay {
+			t.Errorf("event %d: got %+v, want %+v", i, got, want[i])
+		}
+	}
+}
+
+func TestParseDuration(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    time.Duration
+		wantErr bool
+	}{
+		{"PT15M", 15 * time.Minute, false},
+		{"P1DT2H30M", 26*time.Hour + 30*time.Minute, false},
+		{"P1W", 7 * 24 * time.Hour, false},
+		{"-PT5M", -5 * time.Minute, false},
+		{"15M", 0, true},
+		{"P1H", 0, true},
+	}
+
+	for _, tt := range tests {
+		got, err := parseDuration(tt.in)
+		if (err != nil) != tt.wantErr || got != tt.want {
+			t.Errorf("parseDuration(%q) = %s, %v, want %s, error %t", tt.in, got, err, tt.want, tt.wantErr)
+		}
+	}
+}
@@ -0,0 +1,48 @@
+package calendar
+
+import (
+	"context"
+	"time"
+)
+
+// Source provides calendar events
+type Source interface {
+	// Events returns the events that overlap [start, end). Recurring events
+	// are expanded into their occurrences. All-day events are in loc.
+	Events(ctx context.Context, start, end time.Time, loc *time.Location) ([]Event, error)
+}
+
+// Event is a single occurrence of a calendar event
+type Event struct {
+	Summary  string    `json:"summary"`
+	Location string    `json:"location"`
+	Start    time.Time `json:"start"`
+	End      time.Time `json:"end"`
+	AllDay   bool      `json:"all_day"`
+
+	// Declined is set when the calendar knows that you declined the event
+	Declined bool `json:"declined"`
+
+	// declinedBy are the addresses of attendees that declined, for sources
+	// that don't know which attendee you are
+	declinedBy []string
+}
+
+// Lister is a Source that holds several calendars to pick from
+type Lister interface {
+	Source
+
+	// Calendars lists the calendars that can be selected
+	Calendars(ctx context.Context) ([]Info, error)
+
+	// Select picks the calendars to read events from by ID. With no IDs, the
+	// source picks its own defaults.
+	Select(ids []string)
+}
+
+// Info describes a calendar that can be selected
+type Info struct {
+	ID       string `json:"id"`
+	Name     string `json:"name"`
+	Selected bool   `json:"selected"`
+}
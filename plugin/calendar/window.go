@@ -0,0 +1,74 @@
+package calendar
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/jchorl/gowaker/plugin"
+)
+
+// LookaheadToday reads events until the end of the alarm's day
+const LookaheadToday = "today"
+
+// window is the span of time that the briefing covers. Events that overlap it
+// are read, including ones that started before the alarm and are still going.
+type window struct {
+	Start time.Time
+	End   time.Time
+
+	// Duration is zero when the window runs to the end of the day
+	Duration time.Duration
+}
+
+// parseLookahead returns the lookahead duration, or zero for the rest of today
+func parseLookahead(lookahead string) (time.Duration, error) {
+	if lookahead == "" || lookahead == LookaheadToday {
+		return 0, nil
+	}
+
+	d, err := time.ParseDuration(lookahead)
+	if err != nil {
+		return 0, fmt.Errorf("parsing lookahead %q: %w", lookahead, err)
+	}
+	if d <= 0 || d > 7*24*time.Hour {
+		return 0, fmt.Errorf("lookahead %s must be positive and at most a week", d)
+	}
+
+	return d, nil
+}
+
+// newWindow starts the window at the time the alarm was scheduled, in the
+// alarm's time zone
+func newWindow(run plugin.Run, lookahead string) (window, error) {
+	d, err := parseLookahead(lookahead)
+	if err != nil {
+		return window{}, err
+	}
+
+	loc := run.Location
+	if loc == nil {
+		loc = time.Local
+	}
+
+	start := run.Scheduled
+	if start.IsZero() {
+		start = time.Now()
+	}
+	start = start.In(loc)
+
+	if d > 0 {
+		return window{Start: start, End: start.Add(d), Duration: d}, nil
+	}
+
+	// go to midnight in the alarm's time zone
+	year, month, day := start.Date()
+	return window{Start: start, End: time.Date(year, month, day+1, 0, 0, 0, 0, loc)}, nil
+}
+
+// String describes the window, like "today" or "in the next 12 hours"
+func (w window) String() string {
+	if w.Duration == 0 {
+		return "today"
+	}
+	return "in the next " + spokenDuration(w.Duration)
+}
@@ -0,0 +1,274 @@
+// Package commute reads out the travel time to work from an OSRM-compatible
+// routing service
+package commute
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jchorl/gowaker/plugin"
+	"github.com/jchorl/gowaker/ssml"
+)
+
+// defaultSlowFactor is how much longer than the baseline travel has to take to be slow
+const defaultSlowFactor = 1.25
+
+var defaultWeekdays = []string{"monday", "tuesday", "wednesday", "thursday", "friday"}
+
+var weekdays = map[string]time.Weekday{
+	"sunday":    time.Sunday,
+	"monday":    time.Monday,
+	"tuesday":   time.Tuesday,
+	"wednesday": time.Wednesday,
+	"thursday":  time.Thursday,
+	"friday":    time.Friday,
+	"saturday":  time.Saturday,
+}
+
+// Commute is a plugin that reads out the travel time from home to work
+type Commute struct {
+	Client *http.Client
+
+	mu       sync.RWMutex
+	settings Settings
+}
+
+// Settings describe the commute and when to read it
+type Settings struct {
+	// URL is the base URL of an OSRM-compatible routing service. There is no
+	// default, since the home and work coordinates are sent to it.
+	URL string `json:"url"`
+
+	// Profile is the OSRM profile, e.g. driving, cycling or foot
+	Profile string `json:"profile"`
+
+	Home Point `json:"home"`
+	Work Point `json:"work"`
+
+	// Baseline is the usual travel time, e.g. "25m". Without it, the travel
+	// time is read without a comparison.
+	Baseline string `json:"baseline"`
+
+	// SlowFactor is how many times the baseline travel has to take before
+	// warning that it's slow
+	SlowFactor float64 `json:"slow_factor"`
+
+	// Weekdays are the lowercase days to read the commute on
+	Weekdays []string `json:"weekdays"`
+}
+
+// Point is a coordinate
+type Point struct {
+	Lat float64 `json:"lat"`
+	Lon float64 `json:"lon"`
+}
+
+func (p Point) isZero() bool {
+	return p.Lat == 0 && p.Lon == 0
+}
+
+// Route is the structured data behind the commute plugin's output
+type Route struct {
+	Duration time.Duration `json:"duration"`
+
+	// Distance is in meters
+	Distance float64 `json:"distance"`
+
+	Baseline time.Duration `json:"baseline"`
+	Slow     bool          `json:"slow"`
+}
+
+// New creates a commute plugin with no route
+func New() *Commute {
+	return &Commute{
+		Client:   &http.Client{},
+		settings: defaultSettings(),
+	}
+}
+
+func defaultSettings() Settings {
+	return Settings{
+		Profile:    "driving",
+		SlowFactor: defaultSlowFactor,
+		// copied, since decoding settings reuses the slice
+		Weekdays: append([]string(nil), defaultWeekdays...),
+	}
+}
+
+// Name returns the name of the plugin
+func (c *Commute) Name() string {
+	return "commute"
+}
+
+// Configure sets the route and when to read it
+func (c *Commute) Configure(raw json.RawMessage) error {
+	settings := defaultSettings()
+	if len(raw) > 0 {
+		err := json.Unmarshal(raw, &settings)
+		if err != nil {
+			return fmt.Errorf("decoding settings: %w", err)
+		}
+	}
+
+	if settings.URL == "" && !settings.Home.isZero() && !settings.Work.isZero() {
+		return errors.New("a routing service url is required")
+	}
+	if settings.Profile == "" {
+		return errors.New("profile is required")
+	}
+	if settings.Baseline != "" {
+		_, err := time.ParseDuration(settings.Baseline)
+		if err != nil {
+			return fmt.Errorf("parsing baseline: %w", err)
+		}
+	}
+	if settings.SlowFactor < 1 {
+		return fmt.Errorf("slow_factor must be at least 1, got %f", settings.SlowFactor)
+	}
+	for _, day := range settings.Weekdays {
+		if _, ok := weekdays[day]; !ok {
+			return fmt.Errorf("unknown weekday %s", day)
+		}
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.settings = settings
+
+	return nil
+}
+
+func (c *Commute) currentSettings() Settings {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	return c.settings
+}
+
+// Generate reads out the travel time on commute days
+func (c *Commute) Generate(ctx context.Context, run plugin.Run) (plugin.Output, error) {
+	settings := c.currentSettings()
+	if settings.Home.isZero() || settings.Work.isZero() {
+		return plugin.Output{}, nil
+	}
+
+	scheduled := run.Scheduled
+	if scheduled.IsZero() {
+		scheduled = time.Now()
+	}
+	if run.Location != nil {
+		scheduled = scheduled.In(run.Location)
+	}
+	if !commuteDay(settings.Weekdays, scheduled.Weekday()) {
+		return plugin.Output{}, nil
+	}
+
+	route, err := c.route(ctx, settings)
+	if err != nil {
+		return plugin.Output{}, err
+	}
+
+	if settings.Baseline != "" {
+		route.Baseline, _ = time.ParseDuration(settings.Baseline)
+		route.Slow = float64(route.Duration) > float64(route.Baseline)*settings.SlowFactor
+	}
+
+	str := say(route)
+	return plugin.Output{
+		Text: ssml.ToText(str),
+		SSML: str,
+		Data: route,
+	}, nil
+}
+
+func commuteDay(days []string, day time.Weekday) bool {
+	for _, d := range days {
+		if weekdays[d] == day {
+			return true
+		}
+	}
+	return false
+}
+
+// route asks the routing service for the fastest route from home to work
+func (c *Commute) route(ctx context.Context, settings Settings) (Route, error) {
+	url := fmt.Sprintf("%s/route/v1/%s/%f,%f;%f,%f?overview=false",
+		strings.TrimRight(settings.URL, "/"), settings.Profile,
+		settings.Home.Lon, settings.Home.Lat, settings.Work.Lon, settings.Work.Lat)
+
+	req, err := http.NewRequest(http.MethodGet, url, nil)
+	if err != nil {
+		return Route{}, fmt.Errorf("creating request: %w", err)
+	}
+
+	resp, err := c.Client.Do(req.WithContext(ctx))
+	if err != nil {
+		return Route{}, fmt.Errorf("requesting route: %w", err)
+	}
+	defer resp.Body.Close()
+
+	// osrm reports errors like NoRoute in the body, with a 400
+	body := struct {
+		Code    string `json:"code"`
+		Message string `json:"message"`
+		Routes  []struct {
+			Duration float64 `json:"duration"`
+			Distance float64 `json:"distance"`
+		} `json:"routes"`
+	}{}
+	err = json.NewDecoder(resp.Body).Decode(&body)
+	if err != nil && resp.StatusCode != http.StatusOK {
+		return Route{}, fmt.Errorf("requesting route: unexpected status %s", resp.Status)
+	}
+	if err != nil {
+		return Route{}, fmt.Errorf("decoding route response: %w", err)
+	}
+
+	if body.Code != "Ok" {
+		return Route{}, fmt.Errorf("requesting route: %s: %s", body.Code, body.Message)
+	}
+	if len(body.Routes) == 0 {
+		return Route{}, errors.New("requesting route: no routes")
+	}
+
+	return Route{
+		Duration: time.Duration(body.Routes[0].Duration * float64(time.Second)),
+		Distance: body.Routes[0].Distance,
+	}, nil
+}
+
+func say(r Route) string {
+	str := fmt.Sprintf("Your commute to work is about %s", minutes(r.Duration))
+
+	if r.Baseline > 0 {
+		diff := int(math.Round((r.Duration - r.Baseline).Minutes()))
+		switch {
+		case diff > 0:
+			str += fmt.Sprintf(", %s slower than usual", minutes(time.Duration(diff)*time.Minute))
+		case diff < 0:
+			str += fmt.Sprintf(", %s faster than usual", minutes(time.Duration(-diff)*time.Minute))
+		}
+	}
+	str += ". "
+
+	if r.Slow {
+		str += ssml.Emphasis("moderate", "Travel is unusually slow, so leave early.")
+	}
+
+	return strings.TrimSpace(str)
+}
+
+func minutes(d time.Duration) string {
+	m := int(math.Round(d.Minutes()))
+	if m == 1 {
+		return "1 minute"
+	}
+	return fmt.Sprintf("%d minutes", m)
+}
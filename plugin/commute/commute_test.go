@@ -0,0 +1,155 @@
+package commute
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jchorl/gowaker/plugin"
+	"github.com/jchorl/gowaker/plugin/commute/commutetest"
+)
+
+// monday is a commute day by default, and saturday isn't
+var (
+	monday   = time.Date(2021, time.March, 1, 7, 0, 0, 0, time.UTC)
+	saturday = time.Date(2021, time.March, 6, 7, 0, 0, 0, time.UTC)
+)
+
+func newTestCommute(t *testing.T, settings map[string]interface{}) *Commute {
+	t.Helper()
+
+	raw, err := json.Marshal(settings)
+	if err != nil {
+		t.Fatalf("encoding settings: %s", err)
+	}
+
+	c := New()
+	err = c.Configure(raw)
+	if err != nil {
+		t.Fatalf("configuring: %s", err)
+	}
+
+	return c
+}
+
+func TestGenerate(t *testing.T) {
+	server := commutetest.NewServer(35*time.Minute, 12000)
+	defer server.Close()
+
+	c := newTestCommute(t, map[string]interface{}{
+		"url":      server.URL,
+		"profile":  "cycling",
+		"home":     map[string]float64{"lat": 43.65, "lon": -79.38},
+		"work":     map[string]float64{"lat": 43.7, "lon": -79.4},
+		"baseline": "25m",
+	})
+
+	output, err := c.Generate(context.Background(), plugin.Run{Scheduled: monday})
+	if err != nil {
+		t.Fatalf("generating: %s", err)
+	}
+
+	route := output.Data.(Route)
+	if route.Duration != 35*time.Minute || route.Distance != 12000 || route.Baseline != 25*time.Minute || !route.Slow {
+		t.Errorf("got route %+v, want a slow 35 minutes", route)
+	}
+	if !strings.Contains(output.Text, "about 35 minutes, 10 minutes slower than usual") || !strings.Contains(output.Text, "leave early") {
+		t.Errorf("got text %q", output.Text)
+	}
+
+	// osrm takes lon,lat
+	requests := server.Requests()
+	if len(requests) != 1 || !strings.HasPrefix(requests[0], "/route/v1/cycling/-79.380000,43.650000;-79.400000,43.700000") {
+		t.Errorf("got requests %v", requests)
+	}
+
+	server.SetDuration(20 * time.Minute)
+	output, err = c.Generate(context.Background(), plugin.Run{Scheduled: monday})
+	if err != nil {
+		t.Fatalf("generating: %s", err)
+	}
+	if output.Data.(Route).Slow || !strings.Contains(output.Text, "5 minutes faster than usual") {
+		t.Errorf("got text %q, want a fast commute", output.Text)
+	}
+}
+
+func TestGenerateSkips(t *testing.T) {
+	server := commutetest.NewServer(30*time.Minute, 12000)
+	defer server.Close()
+
+	route := map[string]interface{}{
+		"url":  server.URL,
+		"home": map[string]float64{"lat": 43.65, "lon": -79.38},
+		"work": map[string]float64{"lat": 43.7, "lon": -79.4},
+	}
+
+	tests := []struct {
+		name     string
+		settings map[string]interface{}
+		run      plugin.Run
+	}{
+		{name: "no route", settings: map[string]interface{}{"url": server.URL}, run: plugin.Run{Scheduled: monday}},
+		{name: "weekend", settings: route, run: plugin.Run{Scheduled: saturday}},
+		// still sunday night in the alarm's zone, though it's monday in UTC
+		{name: "weekend in the alarm's zone", settings: route, run: plugin.Run{Scheduled: time.Date(2021, time.March, 1, 3, 0, 0, 0, time.UTC), Location: time.FixedZone("EST", -5*60*60)}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			output, err := newTestCommute(t, tt.settings).Generate(context.Background(), tt.run)
+			if err != nil {
+				t.Fatalf("generating: %s", err)
+			}
+			if output.Text != "" || output.SSML != "" {
+				t.Errorf("got output %+v, want nothing", output)
+			}
+		})
+	}
+
+	if requests := server.Requests(); len(requests) != 0 {
+		t.Errorf("got requests %v, want none", requests)
+	}
+}
+
+func TestGenerateRoutingError(t *testing.T) {
+	server := commutetest.NewServer(30*time.Minute, 12000)
+	defer server.Close()
+
+	c := newTestCommute(t, map[string]interface{}{
+		// no profile segment, so the fake answers like osrm does to a bad url
+		"url":     server.URL + "/route/v1/driving",
+		"profile": "x",
+		"home":    map[string]float64{"lat": 43.65, "lon": -79.38},
+		"work":    map[string]float64{"lat": 43.7, "lon": -79.4},
+	})
+
+	_, err := c.Generate(context.Background(), plugin.Run{Scheduled: monday})
+	if err == nil || !strings.Contains(err.Error(), "InvalidUrl") {
+		t.Errorf("got error %v, want osrm's error code", err)
+	}
+}
+
+func TestConfigure(t *testing.T) {
+	tests := []struct {
+		name string
+		raw  string
+		want string
+	}{
+		{name: "bad baseline", raw: `{"baseline":"soon"}`, want: "parsing baseline"},
+		{name: "slow factor", raw: `{"slow_factor":0.5}`, want: "slow_factor"},
+		{name: "weekday", raw: `{"weekdays":["funday"]}`, want: "unknown weekday funday"},
+		{name: "profile", raw: `{"profile":""}`, want: "profile is required"},
+		{name: "url", raw: `{"home":{"lat":43.65,"lon":-79.38},"work":{"lat":43.7,"lon":-79.4}}`, want: "url is required"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := New().Configure(json.RawMessage(tt.raw))
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("got error %v, want %q", err, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,81 @@
+// Package commutetest fakes an OSRM routing service at the HTTP level, so the
+// commute plugin can be exercised offline
+package commutetest
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Server is a fake of OSRM's route service. Point the commute plugin's URL at
+// URL to use it.
+type Server struct {
+	*httptest.Server
+
+	mu       sync.Mutex
+	duration time.Duration
+	distance float64
+	requests []string
+}
+
+// NewServer starts a fake that routes every request in duration over distance meters
+func NewServer(duration time.Duration, distance float64) *Server {
+	s := &Server{duration: duration, distance: distance}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/route/v1/", s.route)
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.mu.Lock()
+		s.requests = append(s.requests, r.URL.RequestURI())
+		s.mu.Unlock()
+		mux.ServeHTTP(w, r)
+	}))
+
+	return s
+}
+
+// SetDuration changes the travel time of later routes, e.g. to simulate traffic
+func (s *Server) SetDuration(d time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.duration = d
+}
+
+// Requests returns the path and query of every request made so far
+func (s *Server) Requests() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return append([]string(nil), s.requests...)
+}
+
+// route serves /route/v1/{profile}/{lon},{lat};{lon},{lat}
+func (s *Server) route(w http.ResponseWriter, r *http.Request) {
+	parts := strings.Split(strings.TrimPrefix(r.URL.Path, "/route/v1/"), "/")
+	if len(parts) != 2 || len(strings.Split(parts[1], ";")) < 2 {
+		w.WriteHeader(http.StatusBadRequest)
+		writeJSON(w, map[string]string{"code": "InvalidUrl", "message": "URL string malformed"})
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	writeJSON(w, map[string]interface{}{
+		"code": "Ok",
+		"routes": []map[string]interface{}{{
+			"duration": s.duration.Seconds(),
+			"distance": s.distance,
+		}},
+	})
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(v)
+}
@@ -0,0 +1,279 @@
+// Package exec speaks the output of local commands, for one-off briefing items
+package exec
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	osexec "os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/golang/glog"
+
+	"github.com/jchorl/gowaker/plugin"
+	"github.com/jchorl/gowaker/ssml"
+)
+
+const defaultTimeout = 10 * time.Second
+
+// maxOutput caps how much of a command's stdout is kept
+const maxOutput = 64 << 10
+
+// Exec is a plugin that runs commands and speaks what they print
+type Exec struct {
+	// allowed are the programs that commands may run. Settings can be changed
+	// over the API, so anything else is refused.
+	allowed map[string]bool
+
+	mu       sync.RWMutex
+	settings Settings
+}
+
+// Settings are the commands to run, in order
+type Settings struct {
+	Commands []Command `json:"commands"`
+}
+
+// Command is a single local command
+type Command struct {
+	// Name identifies the command in logs and in the plugin's data
+	Name string `json:"name"`
+
+	// Args are the program and its arguments. They aren't run through a
+	// shell, so put pipes and the like in a script and allow the script.
+	Args []string `json:"args"`
+
+	// Dir is the working directory. Defaults to gowaker's.
+	Dir string `json:"dir"`
+
+	// Timeout is a duration like "5s". Defaults to 10s.
+	Timeout string `json:"timeout"`
+
+	// JSON means the command prints {"text": ..., "ssml": ..., "data": ...}
+	// instead of plain text
+	JSON bool `json:"json"`
+}
+
+// Result is the output of a single command
+type Result struct {
+	Name string      `json:"name"`
+	Text string      `json:"text"`
+	Data interface{} `json:"data,omitempty"`
+}
+
+// jsonOutput is what commands print when JSON is set
+type jsonOutput struct {
+	Text string      `json:"text"`
+	SSML string      `json:"ssml"`
+	Data interface{} `json:"data"`
+}
+
+// New creates an exec plugin with no commands, that may only run the programs
+// in allowed. With none allowed, no commands can be configured.
+func New(allowed ...string) *Exec {
+	e := &Exec{allowed: map[string]bool{}}
+	for _, program := range allowed {
+		if program != "" {
+			e.allowed[filepath.Clean(program)] = true
+		}
+	}
+
+	return e
+}
+
+// Name returns the name of the plugin
+func (e *Exec) Name() string {
+	return "exec"
+}
+
+// Configure replaces the commands
+func (e *Exec) Configure(raw json.RawMessage) error {
+	return e.configure(raw, false)
+}
+
+// Restore replaces the commands with ones saved by an earlier run. Commands
+// that run programs no longer allowed by -exec-allow are left out, rather than
+// rejecting the rest.
+func (e *Exec) Restore(raw json.RawMessage) error {
+	return e.configure(raw, true)
+}
+
+func (e *Exec) configure(raw json.RawMessage, restoring bool) error {
+	settings := Settings{}
+	if len(raw) > 0 {
+		err := json.Unmarshal(raw, &settings)
+		if err != nil {
+			return fmt.Errorf("decoding settings: %w", err)
+		}
+	}
+
+	commands := make([]Command, 0, len(settings.Commands))
+	for i, c := range settings.Commands {
+		if len(c.Args) == 0 || c.Args[0] == "" {
+			return fmt.Errorf("command %d has no args", i)
+		}
+		if !e.allowed[filepath.Clean(c.Args[0])] {
+			err := fmt.Errorf("command %d runs %s, which isn't one of the programs allowed by -exec-allow", i, c.Args[0])
+			if !restoring {
+				return err
+			}
+			log.Warningf("%s, so it is disabled", err)
+			continue
+		}
+		if c.Timeout != "" {
+			d, err := time.ParseDuration(c.Timeout)
+			if err != nil {
+				return fmt.Errorf("parsing timeout of command %d: %w", i, err)
+			}
+			if d <= 0 {
+				return fmt.Errorf("timeout of command %d must be positive", i)
+			}
+		}
+		commands = append(commands, c)
+	}
+	settings.Commands = commands
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.settings = settings
+
+	return nil
+}
+
+func (e *Exec) currentSettings() Settings {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+
+	return e.settings
+}
+
+// Generate runs the commands one after another and speaks what they print.
+// Commands that fail are logged and left out of the briefing.
+func (e *Exec) Generate(ctx context.Context, run plugin.Run) (plugin.Output, error) {
+	settings := e.currentSettings()
+	if len(settings.Commands) == 0 {
+		return plugin.Output{}, nil
+	}
+
+	outputs := make([]plugin.Result, 0, len(settings.Commands))
+	for _, c := range settings.Commands {
+		out, err := c.run(ctx, run)
+		if err != nil {
+			log.Errorf("running command %s: %s", c.name(), err)
+		}
+		outputs = append(outputs, plugin.Result{Name: c.name(), Output: out, Err: err})
+	}
+
+	output, err := plugin.Join(outputs)
+	if err != nil {
+		return plugin.Output{}, fmt.Errorf("running commands: %w", err)
+	}
+
+	results := []Result{}
+	for _, o := range outputs {
+		if o.Err == nil {
+			results = append(results, Result{Name: o.Name, Text: ssml.ToText(o.Output.SSMLFragment()), Data: o.Output.Data})
+		}
+	}
+	output.Data = results
+
+	return output, nil
+}
+
+func (c Command) name() string {
+	if c.Name != "" {
+		return c.Name
+	}
+	return c.Args[0]
+}
+
+func (c Command) run(ctx context.Context, run plugin.Run) (plugin.Output, error) {
+	timeout := defaultTimeout
+	if c.Timeout != "" {
+		timeout, _ = time.ParseDuration(c.Timeout)
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	cmd := osexec.CommandContext(ctx, c.Args[0], c.Args[1:]...)
+	cmd.Dir = c.Dir
+	cmd.Env = append(os.Environ(), env(run)...)
+
+	stdout := &limitedBuffer{max: maxOutput}
+	stderr := &limitedBuffer{max: 4 << 10}
+	cmd.Stdout, cmd.Stderr = stdout, stderr
+
+	err := cmd.Run()
+	if ctx.Err() == context.DeadlineExceeded {
+		return plugin.Output{}, fmt.Errorf("timed out after %s", timeout)
+	}
+	if err != nil {
+		return plugin.Output{}, fmt.Errorf("%w: %s", err, strings.TrimSpace(stderr.String()))
+	}
+
+	if !c.JSON {
+		return plugin.Output{Text: strings.TrimSpace(stdout.String())}, nil
+	}
+
+	out := jsonOutput{}
+	err = json.Unmarshal(stdout.Bytes(), &out)
+	if err != nil {
+		return plugin.Output{}, fmt.Errorf("decoding output: %w", err)
+	}
+	if out.Text == "" && out.SSML == "" {
+		return plugin.Output{}, errors.New("output has neither text nor ssml")
+	}
+
+	return plugin.Output{Text: out.Text, SSML: out.SSML, Data: out.Data}, nil
+}
+
+// env describes the alarm to the command
+func env(run plugin.Run) []string {
+	loc := run.Location
+	if loc == nil {
+		loc = time.Local
+	}
+	scheduled := run.Scheduled
+	if scheduled.IsZero() {
+		scheduled = time.Now()
+	}
+	scheduled = scheduled.In(loc)
+
+	preview := "0"
+	if run.Preview {
+		preview = "1"
+	}
+
+	return []string{
+		"GOWAKER_ALARM_ID=" + run.AlarmID,
+		"GOWAKER_ALARM_LABEL=" + run.Label,
+		"GOWAKER_SCHEDULED=" + scheduled.Format(time.RFC3339),
+		"GOWAKER_TIMEZONE=" + loc.String(),
+		"GOWAKER_WEEKDAY=" + strings.ToLower(scheduled.Weekday().String()),
+		"GOWAKER_PLUGINS=" + strings.Join(run.Plugins, ","),
+		"GOWAKER_PREVIEW=" + preview,
+	}
+}
+
+// limitedBuffer keeps the first max bytes written to it and drops the rest,
+// so a chatty command can't use up memory
+type limitedBuffer struct {
+	bytes.Buffer
+	max int
+}
+
+func (b *limitedBuffer) Write(p []byte) (int, error) {
+	if room := b.max - b.Len(); room < len(p) {
+		if room > 0 {
+			b.Buffer.Write(p[:room])
+		}
+		return len(p), nil
+	}
+	return b.Buffer.Write(p)
+}
@@ -0,0 +1,175 @@
+package exec
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jchorl/gowaker/plugin"
+)
+
+// script writes an executable shell script to a temp dir and returns its path
+func script(t *testing.T, body string) string {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), "script")
+	err := ioutil.WriteFile(path, []byte("#!/bin/sh\n"+body+"\n"), 0700)
+	if err != nil {
+		t.Fatalf("writing script: %s", err)
+	}
+	return path
+}
+
+func configure(t *testing.T, e *Exec, commands ...Command) {
+	t.Helper()
+
+	raw, err := json.Marshal(Settings{Commands: commands})
+	if err != nil {
+		t.Fatalf("encoding settings: %s", err)
+	}
+	err = e.Configure(raw)
+	if err != nil {
+		t.Fatalf("configuring: %s", err)
+	}
+}
+
+func TestAllowed(t *testing.T) {
+	allowed := script(t, "echo allowed")
+	other := script(t, "echo other")
+	e := New(allowed, "")
+
+	tests := []struct {
+		name    string
+		args    []string
+		wantErr bool
+	}{
+		{"allowed", []string{allowed, "arg"}, false},
+		{"uncleaned path", []string{filepath.Dir(allowed) + "/./script"}, false},
+		{"not allowed", []string{other}, true},
+		{"empty allowed entry", []string{""}, true},
+		{"no args", nil, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			raw, _ := json.Marshal(Settings{Commands: []Command{{Args: tt.args}}})
+			err := e.Configure(raw)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("got error %v, want error %t", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestRestoreDisallowed(t *testing.T) {
+	allowed := script(t, "echo allowed")
+	removed := script(t, "echo removed")
+	raw, _ := json.Marshal(Settings{Commands: []Command{{Args: []string{removed}}, {Args: []string{allowed}}}})
+
+	e := New(allowed)
+	err := e.Configure(raw)
+	if err == nil {
+		t.Fatal("configuring with a program that isn't allowed succeeded")
+	}
+
+	err = e.Restore(raw)
+	if err != nil {
+		t.Fatalf("restoring: %s", err)
+	}
+
+	out, err := e.Generate(context.Background(), plugin.Run{})
+	if err != nil {
+		t.Fatalf("generating: %s", err)
+	}
+	if out.Text != "allowed" {
+		t.Errorf("got %q, want only the allowed command's output", out.Text)
+	}
+}
+
+func TestEnv(t *testing.T) {
+	loc, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		t.Fatalf("loading location: %s", err)
+	}
+	path := script(t, `echo "$GOWAKER_ALARM_ID|$GOWAKER_ALARM_LABEL|$GOWAKER_SCHEDULED|$GOWAKER_TIMEZONE|$GOWAKER_WEEKDAY|$GOWAKER_PLUGINS|$GOWAKER_PREVIEW"`)
+	scheduled := time.Date(2021, 3, 6, 12, 30, 0, 0, time.UTC)
+
+	tests := []struct {
+		name string
+		run  plugin.Run
+		want string
+	}{
+		{
+			name: "alarm",
+			run:  plugin.Run{AlarmID: "a1", Label: "work", Scheduled: scheduled, Location: loc, Plugins: []string{"weather", "exec"}},
+			want: "a1|work|2021-03-06T07:30:00-05:00|America/New_York|saturday|weather,exec|0",
+		},
+		{
+			name: "preview",
+			run:  plugin.Run{AlarmID: "a1", Label: "work", Scheduled: scheduled, Location: time.UTC, Preview: true},
+			want: "a1|work|2021-03-06T12:30:00Z|UTC|saturday||1",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e := New(path)
+			configure(t, e, Command{Args: []string{path}})
+
+			out, err := e.Generate(context.Background(), tt.run)
+			if err != nil {
+				t.Fatalf("generating: %s", err)
+			}
+			if out.Text != tt.want {
+				t.Errorf("got %q, want %q", out.Text, tt.want)
+			}
+		})
+	}
+}
+
+func TestTimeout(t *testing.T) {
+	slow := script(t, "exec sleep 5")
+	fast := script(t, "echo done")
+	e := New(slow, fast)
+	configure(t, e, Command{Name: "slow", Args: []string{slow}, Timeout: "100ms"}, Command{Name: "fast", Args: []string{fast}})
+
+	start := time.Now()
+	out, err := e.Generate(context.Background(), plugin.Run{})
+	if err != nil {
+		t.Fatalf("generating: %s", err)
+	}
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Errorf("took %s, want the slow command killed after its timeout", elapsed)
+	}
+	if out.Text != "done" {
+		t.Errorf("got %q, want only the fast command's output", out.Text)
+	}
+
+	configure(t, e, Command{Name: "slow", Args: []string{slow}, Timeout: "100ms"})
+	_, err = e.Generate(context.Background(), plugin.Run{})
+	if err == nil || !strings.Contains(err.Error(), "timed out") {
+		t.Errorf("got error %v, want a timeout", err)
+	}
+}
+
+func TestJSON(t *testing.T) {
+	path := script(t, `echo '{"text": "plain", "ssml": "<emphasis>loud</emphasis>", "data": {"n": 1}}'`)
+	e := New(path)
+	configure(t, e, Command{Name: "j", Args: []string{path}, JSON: true})
+
+	out, err := e.Generate(context.Background(), plugin.Run{})
+	if err != nil {
+		t.Fatalf("generating: %s", err)
+	}
+	if out.SSML != "<emphasis>loud</emphasis>" {
+		t.Errorf("got ssml %q", out.SSML)
+	}
+	results, ok := out.Data.([]Result)
+	if !ok || len(results) != 1 || results[0].Name != "j" || results[0].Text != "loud" {
+		t.Errorf("got data %+v", out.Data)
+	}
+}
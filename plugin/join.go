@@ -0,0 +1,42 @@
+package plugin
+
+import (
+	"strings"
+	"time"
+
+	"github.com/jchorl/gowaker/ssml"
+)
+
+// joinPause separates the outputs that Join puts together
+const joinPause = 300 * time.Millisecond
+
+// Join puts the outputs of results together in order, for plugins that speak
+// several things of their own, like the output of several commands. Failed
+// results are left out, and only if every result failed is the last error
+// returned. The joined output has no Data, which is up to the plugin.
+func Join(results []Result) (Output, error) {
+	fragments := []string{}
+	var lastErr error
+	succeeded := 0
+	for _, result := range results {
+		if result.Err != nil {
+			lastErr = result.Err
+			continue
+		}
+
+		succeeded++
+		if fragment := result.Output.SSMLFragment(); fragment != "" {
+			fragments = append(fragments, fragment)
+		}
+	}
+
+	if len(results) > 0 && succeeded == 0 {
+		return Output{}, lastErr
+	}
+
+	str := strings.Join(fragments, " "+ssml.Break(joinPause))
+	return Output{
+		Text: ssml.ToText(str),
+		SSML: str,
+	}, nil
+}
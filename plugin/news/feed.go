@@ -0,0 +1,210 @@
+package news
+
+import (
+	"context"
+	"encoding/xml"
+	"fmt"
+	"html"
+	"io"
+	"net/http"
+	"os"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// Headline is a single item from a feed
+type Headline struct {
+	Title     string    `json:"title"`
+	Link      string    `json:"link"`
+	Feed      string    `json:"feed"`
+	Published time.Time `json:"published"`
+}
+
+// document covers RSS 2.0, RSS 1.0 (RDF) and Atom. Tags without a namespace
+// match any namespace, so the same fields work for all three.
+type document struct {
+	XMLName xml.Name
+
+	// RSS 2.0
+	Channel struct {
+		Title string `xml:"title"`
+		Items []item `xml:"item"`
+	} `xml:"channel"`
+
+	// RSS 1.0 puts items next to the channel
+	Items []item `xml:"item"`
+
+	// Atom
+	Title   string  `xml:"title"`
+	Entries []entry `xml:"entry"`
+}
+
+type item struct {
+	Title   string `xml:"title"`
+	Link    string `xml:"link"`
+	PubDate string `xml:"pubDate"`
+	Date    string `xml:"date"` // dc:date in RSS 1.0
+}
+
+type entry struct {
+	Title string `xml:"title"`
+	Links []struct {
+		Href string `xml:"href,attr"`
+		Rel  string `xml:"rel,attr"`
+	} `xml:"link"`
+	Published string `xml:"published"`
+	Updated   string `xml:"updated"`
+}
+
+// fetch reads a feed from an http(s) URL or a file path
+func fetch(ctx context.Context, client *http.Client, location string) ([]Headline, error) {
+	r, err := open(ctx, client, location)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+
+	headlines, err := parse(r)
+	if err != nil {
+		return nil, fmt.Errorf("parsing %s: %w", location, err)
+	}
+
+	return headlines, nil
+}
+
+func open(ctx context.Context, client *http.Client, location string) (io.ReadCloser, error) {
+	if !strings.HasPrefix(location, "http://") && !strings.HasPrefix(location, "https://") {
+		f, err := os.Open(location)
+		if err != nil {
+			return nil, fmt.Errorf("opening %s: %w", location, err)
+		}
+		return f, nil
+	}
+
+	req, err := http.NewRequest(http.MethodGet, location, nil)
+	if err != nil {
+		return nil, fmt.Errorf("creating request: %w", err)
+	}
+
+	resp, err := client.Do(req.WithContext(ctx))
+	if err != nil {
+		return nil, fmt.Errorf("fetching %s: %w", location, err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		return nil, fmt.Errorf("fetching %s: unexpected status %s", location, resp.Status)
+	}
+
+	return resp.Body, nil
+}
+
+// parse reads the headlines of an RSS or Atom feed, in feed order
+func parse(r io.Reader) ([]Headline, error) {
+	decoder := xml.NewDecoder(r)
+	// feeds that declare a charset are almost always utf-8 in practice
+	decoder.CharsetReader = func(charset string, input io.Reader) (io.Reader, error) {
+		switch strings.ToLower(charset) {
+		case "utf-8", "utf8", "us-ascii", "ascii":
+			return input, nil
+		}
+		return nil, fmt.Errorf("unsupported charset %s", charset)
+	}
+
+	doc := document{}
+	err := decoder.Decode(&doc)
+	if err != nil {
+		return nil, fmt.Errorf("decoding: %w", err)
+	}
+
+	var headlines []Headline
+	switch strings.ToLower(doc.XMLName.Local) {
+	case "rss", "rdf":
+		feedTitle := clean(doc.Channel.Title)
+		for _, i := range append(doc.Channel.Items, doc.Items...) {
+			published := parseDate(i.PubDate)
+			if published.IsZero() {
+				published = parseDate(i.Date)
+			}
+			headlines = append(headlines, Headline{
+				Title:     clean(i.Title),
+				Link:      strings.TrimSpace(i.Link),
+				Feed:      feedTitle,
+				Published: published,
+			})
+		}
+	case "feed":
+		feedTitle := clean(doc.Title)
+		for _, e := range doc.Entries {
+			link := ""
+			for _, l := range e.Links {
+				if l.Rel == "" || l.Rel == "alternate" {
+					link = l.Href
+					break
+				}
+			}
+
+			published := parseDate(e.Published)
+			if published.IsZero() {
+				published = parseDate(e.Updated)
+			}
+			headlines = append(headlines, Headline{
+				Title:     clean(e.Title),
+				Link:      link,
+				Feed:      feedTitle,
+				Published: published,
+			})
+		}
+	default:
+		return nil, fmt.Errorf("unknown feed type %s", doc.XMLName.Local)
+	}
+
+	return headlines, nil
+}
+
+var dateLayouts = []string{
+	time.RFC1123Z,
+	time.RFC1123,
+	"Mon, 2 Jan 2006 15:04:05 -0700",
+	"Mon, 2 Jan 2006 15:04:05 MST",
+	time.RFC3339,
+}
+
+// parseDate parses the date formats feeds use, returning the zero time when it can't
+func parseDate(s string) time.Time {
+	s = strings.TrimSpace(s)
+	for _, layout := range dateLayouts {
+		if t, err := time.Parse(layout, s); err == nil {
+			return t
+		}
+	}
+	return time.Time{}
+}
+
+var (
+	tags       = regexp.MustCompile(`<[^>]*>`)
+	whitespace = regexp.MustCompile(`\s+`)
+)
+
+// clean strips html from feed text, which is often escaped html, so it can be spoken
+func clean(s string) string {
+	s = tags.ReplaceAllString(s, " ")
+	s = html.UnescapeString(s)
+	// entities can decode to more markup, e.g. &lt;b&gt;
+	s = tags.ReplaceAllString(s, " ")
+	return strings.TrimSpace(whitespace.ReplaceAllString(s, " "))
+}
+
+// truncate caps s at max runes, cutting at a word boundary
+func truncate(s string, max int) string {
+	runes := []rune(s)
+	if max <= 0 || len(runes) <= max {
+		return s
+	}
+
+	cut := string(runes[:max])
+	if i := strings.LastIndex(cut, " "); i > 0 {
+		cut = cut[:i]
+	}
+	return strings.TrimRight(cut, " ,;:-") + "..."
+}
@@ -0,0 +1,89 @@
+package news
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		file string
+		want []Headline
+	}{
+		{
+			file: "rss.xml",
+			want: []Headline{
+				{Title: "City council approves new transit plan", Link: "https://news.example.com/transit", Feed: "Example & Co News", Published: time.Date(2020, time.March, 2, 11, 30, 0, 0, time.UTC)},
+				{Title: "Local team wins championship after overtime thriller", Link: "https://news.example.com/sports", Feed: "Example & Co News", Published: time.Date(2020, time.March, 2, 10, 0, 0, 0, time.UTC)},
+				{Title: "Scientists say a very long headline that keeps going and going with details nobody wants read aloud before coffee in the morning is not ideal", Link: "https://news.example.com/long", Feed: "Example & Co News", Published: time.Date(2020, time.March, 2, 3, 0, 0, 0, time.UTC)},
+			},
+		},
+		{
+			file: "rdf.xml",
+			want: []Headline{
+				{Title: "New chips promise longer battery life", Link: "https://tech.example.com/chips", Feed: "Example Tech", Published: time.Date(2020, time.March, 2, 9, 0, 0, 0, time.UTC)},
+			},
+		},
+		{
+			file: "atom.xml",
+			want: []Headline{
+				{Title: "City Council approves new transit plan!", Link: "https://wire.example.com/transit", Feed: "Example Wire", Published: time.Date(2020, time.March, 2, 11, 15, 0, 0, time.UTC)},
+				// falls back to the updated date, and entities decode twice
+				{Title: `Markets open higher on "strong" earnings`, Link: "https://wire.example.com/markets", Feed: "Example Wire", Published: time.Date(2020, time.March, 2, 10, 45, 0, 0, time.UTC)},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.file, func(t *testing.T) {
+			f, err := os.Open(filepath.Join("testdata", tt.file))
+			if err != nil {
+				t.Fatalf("opening fixture: %s", err)
+			}
+			defer f.Close()
+
+			headlines, err := parse(f)
+			if err != nil {
+				t.Fatalf("parsing: %s", err)
+			}
+
+			if len(headlines) != len(tt.want) {
+				t.Fatalf("got %d headlines, want %d: %+v", len(headlines), len(tt.want), headlines)
+			}
+			for i, h := range headlines {
+				want := tt.want[i]
+				if h.Title != want.Title || h.Link != want.Link || h.Feed != want.Feed || !h.Published.Equal(want.Published) {
+					t.Errorf("headline %d is %+v, want %+v", i, h, want)
+				}
+			}
+		})
+	}
+}
+
+func TestParseUnknown(t *testing.T) {
+	_, err := parse(strings.NewReader(`<html><title>not a feed</title></html>`))
+	if err == nil {
+		t.Error("parsed html as a feed")
+	}
+}
+
+func TestTruncate(t *testing.T) {
+	tests := []struct {
+		s    string
+		max  int
+		want string
+	}{
+		{s: "short", max: 20, want: "short"},
+		{s: "one two, three four", max: 12, want: "one two..."},
+		{s: "café crème brûlée", max: 9, want: "café..."},
+	}
+
+	for _, tt := range tests {
+		if got := truncate(tt.s, tt.max); got != tt.want {
+			t.Errorf("truncate(%q, %d) is %q, want %q", tt.s, tt.max, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,150 @@
+package news
+
+import (
+	"encoding/json"
+	"fmt"
+
+	log "github.com/golang/glog"
+	"github.com/valyala/fasthttp"
+
+	"github.com/jchorl/gowaker/requestcontext"
+)
+
+// Feed is the body of the feed add and remove endpoints
+type Feed struct {
+	URL string `json:"url"`
+}
+
+// HandlerGetFeeds lists the configured feeds
+func HandlerGetFeeds(ctx *fasthttp.RequestCtx) {
+	settings, err := getSettings(ctx)
+	if err != nil {
+		log.Error(err)
+		ctx.Error(err.Error(), fasthttp.StatusInternalServerError)
+		return
+	}
+
+	feeds := []Feed{}
+	for _, f := range settings.Feeds {
+		feeds = append(feeds, Feed{URL: f})
+	}
+
+	ctx.Response.SetStatusCode(fasthttp.StatusOK)
+	json.NewEncoder(ctx).Encode(feeds)
+}
+
+// HandlerAddFeed adds a feed after the existing ones
+func HandlerAddFeed(ctx *fasthttp.RequestCtx) {
+	feed := Feed{}
+	err := json.Unmarshal(ctx.Request.Body(), &feed)
+	if err != nil {
+		err = fmt.Errorf("decoding body: %w", err)
+		log.Error(err)
+		ctx.Error(err.Error(), fasthttp.StatusBadRequest)
+		return
+	}
+	if feed.URL == "" {
+		ctx.Error("url is required", fasthttp.StatusBadRequest)
+		return
+	}
+
+	settings, err := getSettings(ctx)
+	if err != nil {
+		log.Error(err)
+		ctx.Error(err.Error(), fasthttp.StatusInternalServerError)
+		return
+	}
+
+	for _, f := range settings.Feeds {
+		if f == feed.URL {
+			ctx.Error(fmt.Sprintf("feed %s already exists", feed.URL), fasthttp.StatusConflict)
+			return
+		}
+	}
+	settings.Feeds = append(settings.Feeds, feed.URL)
+
+	err = setSettings(ctx, settings)
+	if err != nil {
+		log.Error(err)
+		ctx.Error(err.Error(), fasthttp.StatusInternalServerError)
+		return
+	}
+
+	ctx.Response.SetStatusCode(fasthttp.StatusOK)
+	json.NewEncoder(ctx).Encode(feed)
+}
+
+// HandlerDeleteFeed removes a feed
+func HandlerDeleteFeed(ctx *fasthttp.RequestCtx) {
+	feed := Feed{}
+	err := json.Unmarshal(ctx.Request.Body(), &feed)
+	if err != nil {
+		err = fmt.Errorf("decoding body: %w", err)
+		log.Error(err)
+		ctx.Error(err.Error(), fasthttp.StatusBadRequest)
+		return
+	}
+
+	settings, err := getSettings(ctx)
+	if err != nil {
+		log.Error(err)
+		ctx.Error(err.Error(), fasthttp.StatusInternalServerError)
+		return
+	}
+
+	var feeds []string
+	for _, f := range settings.Feeds {
+		if f != feed.URL {
+			feeds = append(feeds, f)
+		}
+	}
+	if len(feeds) == len(settings.Feeds) {
+		ctx.Error(fmt.Sprintf("feed %s not found", feed.URL), fasthttp.StatusNotFound)
+		return
+	}
+	settings.Feeds = feeds
+
+	err = setSettings(ctx, settings)
+	if err != nil {
+		log.Error(err)
+		ctx.Error(err.Error(), fasthttp.StatusInternalServerError)
+	}
+}
+
+func getSettings(ctx *fasthttp.RequestCtx) (Settings, error) {
+	pluginSettings, err := requestcontext.Plugins(ctx).Get(pluginName)
+	if err != nil {
+		return Settings{}, fmt.Errorf("getting plugin settings: %w", err)
+	}
+
+	settings := Settings{Count: defaultCount, MaxLength: defaultMaxLength}
+	if len(pluginSettings.Settings) > 0 {
+		err = json.Unmarshal(pluginSettings.Settings, &settings)
+		if err != nil {
+			return Settings{}, fmt.Errorf("decoding news settings: %w", err)
+		}
+	}
+
+	return settings, nil
+}
+
+// setSettings persists settings through the registry, which also applies them
+func setSettings(ctx *fasthttp.RequestCtx, settings Settings) error {
+	registry := requestcontext.Plugins(ctx)
+	pluginSettings, err := registry.Get(pluginName)
+	if err != nil {
+		return fmt.Errorf("getting plugin settings: %w", err)
+	}
+
+	pluginSettings.Settings, err = json.Marshal(settings)
+	if err != nil {
+		return fmt.Errorf("encoding news settings: %w", err)
+	}
+
+	err = registry.Update(pluginSettings)
+	if err != nil {
+		return fmt.Errorf("updating news settings: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,197 @@
+// Package news reads out headlines from RSS and Atom feeds
+package news
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+
+	log "github.com/golang/glog"
+
+	"github.com/jchorl/gowaker/plugin"
+	"github.com/jchorl/gowaker/ssml"
+)
+
+const pluginName = "news"
+
+const (
+	defaultCount     = 5
+	defaultMaxLength = 120
+)
+
+// News is a plugin that reads out the top headlines from feeds
+type News struct {
+	Client *http.Client
+
+	mu       sync.RWMutex
+	settings Settings
+}
+
+// Settings are the feeds to read and how much of them
+type Settings struct {
+	// Feeds are RSS or Atom feed URLs or file paths. Earlier feeds take
+	// priority when headlines are picked.
+	Feeds []string `json:"feeds"`
+
+	// Count is the number of headlines to read
+	Count int `json:"count"`
+
+	// MaxLength caps the characters read from each headline
+	MaxLength int `json:"max_length"`
+}
+
+// New creates a news plugin with no feeds
+func New() *News {
+	return &News{
+		Client:   &http.Client{},
+		settings: Settings{Count: defaultCount, MaxLength: defaultMaxLength},
+	}
+}
+
+// Name returns the name of the plugin
+func (n *News) Name() string {
+	return pluginName
+}
+
+// Configure replaces the feeds and limits
+func (n *News) Configure(raw json.RawMessage) error {
+	settings := Settings{Count: defaultCount, MaxLength: defaultMaxLength}
+	if len(raw) > 0 {
+		err := json.Unmarshal(raw, &settings)
+		if err != nil {
+			return fmt.Errorf("decoding settings: %w", err)
+		}
+	}
+
+	for _, feed := range settings.Feeds {
+		if feed == "" {
+			return errors.New("feed location is required")
+		}
+	}
+	if settings.Count <= 0 {
+		return fmt.Errorf("count must be positive, got %d", settings.Count)
+	}
+	if settings.MaxLength < 20 {
+		return fmt.Errorf("max_length must be at least 20, got %d", settings.MaxLength)
+	}
+
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.settings = settings
+
+	return nil
+}
+
+func (n *News) currentSettings() Settings {
+	n.mu.RLock()
+	defer n.mu.RUnlock()
+
+	return n.settings
+}
+
+// Generate reads out the top headlines across every feed
+func (n *News) Generate(ctx context.Context, run plugin.Run) (plugin.Output, error) {
+	settings := n.currentSettings()
+	if len(settings.Feeds) == 0 {
+		return plugin.Output{Data: []Headline{}}, nil
+	}
+
+	headlines, err := n.Headlines(ctx, settings)
+	if err != nil {
+		return plugin.Output{}, err
+	}
+
+	if len(headlines) == 0 {
+		return plugin.Output{Text: "There are no headlines right now.", Data: headlines}, nil
+	}
+
+	str := "Here are the top headlines." + ssml.Break(500*time.Millisecond)
+	for _, h := range headlines {
+		str += ssml.Escape(h.Title)
+		if !strings.ContainsAny(h.Title[len(h.Title)-1:], ".!?") {
+			str += "."
+		}
+		str += " " + ssml.Break(400*time.Millisecond)
+	}
+
+	return plugin.Output{
+		Text: ssml.ToText(str),
+		SSML: str,
+		Data: headlines,
+	}, nil
+}
+
+// Headlines fetches every feed and picks the top headlines, taking turns
+// between feeds so that one busy feed doesn't crowd out the others. Feeds that
+// can't be fetched are logged and picked around; there are only no headlines
+// to read when none of them can be.
+func (n *News) Headlines(ctx context.Context, settings Settings) ([]Headline, error) {
+	feeds := make([][]Headline, len(settings.Feeds))
+	errs := make([]error, len(settings.Feeds))
+
+	var wg sync.WaitGroup
+	for i, location := range settings.Feeds {
+		wg.Add(1)
+		go func(i int, location string) {
+			defer wg.Done()
+			feeds[i], errs[i] = fetch(ctx, n.Client, location)
+		}(i, location)
+	}
+	wg.Wait()
+
+	var lastErr error
+	failures := 0
+	for _, err := range errs {
+		if err != nil {
+			log.Errorf("fetching news feed: %s", err)
+			lastErr = err
+			failures++
+		}
+	}
+	if failures > 0 && failures == len(feeds) {
+		return nil, fmt.Errorf("fetching feeds: %w", lastErr)
+	}
+
+	seen := map[string]bool{}
+	headlines := []Headline{}
+	for depth := 0; len(headlines) < settings.Count; depth++ {
+		more := false
+		for _, feed := range feeds {
+			if depth >= len(feed) || len(headlines) >= settings.Count {
+				continue
+			}
+			more = true
+
+			h := feed[depth]
+			key := dedupKey(h.Title)
+			if key == "" || seen[key] {
+				continue
+			}
+			seen[key] = true
+
+			h.Title = truncate(h.Title, settings.MaxLength)
+			headlines = append(headlines, h)
+		}
+		if !more {
+			break
+		}
+	}
+
+	return headlines, nil
+}
+
+// dedupKey normalizes a title so the same story from different feeds matches
+func dedupKey(title string) string {
+	return strings.Map(func(r rune) rune {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			return unicode.ToLower(r)
+		}
+		return -1
+	}, title)
+}
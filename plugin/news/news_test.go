@@ -0,0 +1,52 @@
+package news
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+)
+
+func TestHeadlines(t *testing.T) {
+	n := New()
+	settings := Settings{
+		Feeds: []string{
+			filepath.Join("testdata", "rss.xml"),
+			filepath.Join("testdata", "missing.xml"),
+			filepath.Join("testdata", "atom.xml"),
+			filepath.Join("testdata", "rdf.xml"),
+		},
+		Count:     4,
+		MaxLength: 40,
+	}
+
+	headlines, err := n.Headlines(context.Background(), settings)
+	if err != nil {
+		t.Fatalf("getting headlines: %s", err)
+	}
+
+	// feeds take turns, the missing feed is skipped and the wire's transit
+	// story is the same as the first one
+	want := []string{
+		"City council approves new transit plan",
+		"New chips promise longer battery life",
+		"Local team wins championship after...",
+		`Markets open higher on "strong" earnings`,
+	}
+	if len(headlines) != len(want) {
+		t.Fatalf("got headlines %+v, want %q", headlines, want)
+	}
+	for i, h := range headlines {
+		if h.Title != want[i] {
+			t.Errorf("headline %d is %q, want %q", i, h.Title, want[i])
+		}
+	}
+}
+
+func TestHeadlinesEveryFeedFails(t *testing.T) {
+	settings := Settings{Feeds: []string{filepath.Join("testdata", "missing.xml")}, Count: 3, MaxLength: 40}
+
+	_, err := New().Headlines(context.Background(), settings)
+	if err == nil {
+		t.Error("got headlines from a missing feed")
+	}
+}
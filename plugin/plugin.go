@@ -1,6 +1,113 @@
 package plugin
 
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/jchorl/gowaker/ssml"
+)
+
 // Plugin generates a part of the wakeup message
 type Plugin interface {
-	Text() (string, error)
+	// Name identifies the plugin, e.g. "weather"
+	Name() string
+
+	// Generate returns the plugin's part of the wakeup message. It should
+	// give up once ctx is done.
+	Generate(ctx context.Context, run Run) (Output, error)
+}
+
+// Acknowledger is a plugin with output that has side effects once it has been
+// spoken, like reminders that are then marked delivered
+type Acknowledger interface {
+	Plugin
+
+	// Spoken is called with the plugin's output once the briefing it was part
+	// of has been read out in full
+	Spoken(ctx context.Context, run Run, output Output) error
+}
+
+// Run describes the alarm run that a plugin is generating output for
+type Run struct {
+	AlarmID   string
+	Label     string
+	Scheduled time.Time
+	Location  *time.Location
+
+	// Plugins are the names of the plugins chosen for the alarm. When empty,
+	// every enabled plugin runs.
+	Plugins []string
+
+	// Preview is set when the briefing is only being previewed, so plugins
+	// should leave no side effects behind
+	Preview bool
+}
+
+// Output is a plugin's part of the wakeup message
+type Output struct {
+	// Text is the plain text to speak
+	Text string
+
+	// SSML is an optional SSML fragment, without the surrounding <speak>
+	// tags, to speak instead of Text
+	SSML string
+
+	// Data is the structured data that the text was generated from
+	Data interface{}
+}
+
+// SSMLFragment returns the SSML to speak for an output, escaping the plain
+// text of outputs that have no SSML
+func (o Output) SSMLFragment() string {
+	if o.SSML != "" {
+		return o.SSML
+	}
+
+	return ssml.Escape(o.Text)
+}
+
+// Result is the outcome of running a single plugin
+type Result struct {
+	Name   string
+	Output Output
+	Err    error
+}
+
+// RunAll runs plugins concurrently, giving each of them at most timeout to
+// finish. Results are returned in the same order as plugins.
+func RunAll(ctx context.Context, run Run, plugins []Plugin, timeout time.Duration) []Result {
+	results := make([]Result, len(plugins))
+
+	var wg sync.WaitGroup
+	for i, p := range plugins {
+		wg.Add(1)
+		go func(i int, p Plugin) {
+			defer wg.Done()
+			results[i] = runOne(ctx, run, p, timeout)
+		}(i, p)
+	}
+	wg.Wait()
+
+	return results
+}
+
+func runOne(ctx context.Context, run Run, p Plugin, timeout time.Duration) Result {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	// buffered so a plugin that ignores ctx doesn't leak a blocked goroutine
+	done := make(chan Result, 1)
+	go func() {
+		output, err := p.Generate(ctx, run)
+		done <- Result{Name: p.Name(), Output: output, Err: err}
+	}()
+
+	select {
+	case result := <-done:
+		return result
+	case <-ctx.Done():
+		return Result{Name: p.Name(), Err: fmt.Errorf("running plugin %s: %w", p.Name(), ctx.Err())}
+	}
 }
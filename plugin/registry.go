@@ -0,0 +1,262 @@
+package plugin
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+
+	log "github.com/golang/glog"
+)
+
+// Configurable is a Plugin with settings of its own
+type Configurable interface {
+	Plugin
+
+	// Configure applies user provided settings. It is called whenever the
+	// settings change, and may be called while the plugin is generating.
+	Configure(settings json.RawMessage) error
+}
+
+// Restorer is a Configurable plugin that makes do with saved settings that it
+// would no longer take from Configure, e.g. because a flag changed since
+type Restorer interface {
+	Configurable
+
+	// Restore applies settings saved by an earlier run, at startup
+	Restore(settings json.RawMessage) error
+}
+
+// Settings control whether and when a plugin is run
+type Settings struct {
+	Name     string          `json:"name"`
+	Enabled  bool            `json:"enabled"`
+	Position int             `json:"position"`
+	Settings json.RawMessage `json:"settings,omitempty"`
+}
+
+// Registry holds every available plugin along with its persisted settings
+type Registry struct {
+	db *sql.DB
+
+	mu       sync.RWMutex
+	plugins  map[string]Plugin
+	settings map[string]Settings
+}
+
+// ErrNotFound is returned when there is no plugin with a given name
+var ErrNotFound = errors.New("plugin not found")
+
+// NewRegistry creates a registry of plugins, restoring their settings from the
+// db. Plugins without saved settings are enabled, in the order given. A plugin
+// that rejects its saved settings keeps its defaults and is disabled, with the
+// saved settings left in place to be fixed through Update.
+func NewRegistry(db *sql.DB, plugins ...Plugin) (*Registry, error) {
+	r := &Registry{
+		db:       db,
+		plugins:  map[string]Plugin{},
+		settings: map[string]Settings{},
+	}
+
+	for i, p := range plugins {
+		r.plugins[p.Name()] = p
+		r.settings[p.Name()] = Settings{Name: p.Name(), Enabled: true, Position: i}
+	}
+
+	rows, err := db.Query("select name, enabled, position, settings from plugin_config")
+	if err != nil {
+		return nil, fmt.Errorf("querying plugin config: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var s Settings
+		var raw string
+		err = rows.Scan(&s.Name, &s.Enabled, &s.Position, &raw)
+		if err != nil {
+			return nil, fmt.Errorf("scanning row: %w", err)
+		}
+		if raw != "" {
+			s.Settings = json.RawMessage(raw)
+		}
+
+		p, ok := r.plugins[s.Name]
+		if !ok {
+			// the plugin was removed since its settings were saved
+			continue
+		}
+
+		err = restore(p, s.Settings)
+		if err != nil {
+			log.Errorf("configuring plugin %s, disabling it: %s", s.Name, err)
+			s.Enabled = false
+		}
+		r.settings[s.Name] = s
+	}
+	err = rows.Err()
+	if err != nil {
+		return nil, fmt.Errorf("iterating over plugin config query results: %w", err)
+	}
+
+	return r, nil
+}
+
+// List returns the settings of every plugin, in the order they run in
+func (r *Registry) List() []Settings {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	list := make([]Settings, 0, len(r.settings))
+	for _, s := range r.settings {
+		list = append(list, s)
+	}
+
+	sort.Slice(list, func(i, j int) bool {
+		if list[i].Position != list[j].Position {
+			return list[i].Position < list[j].Position
+		}
+		return list[i].Name < list[j].Name
+	})
+
+	return list
+}
+
+// Get returns the settings of a single plugin
+func (r *Registry) Get(name string) (Settings, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	s, ok := r.settings[name]
+	if !ok {
+		return Settings{}, ErrNotFound
+	}
+
+	return s, nil
+}
+
+// Plugin returns the plugin with a given name
+func (r *Registry) Plugin(name string) (Plugin, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	p, ok := r.plugins[name]
+	if !ok {
+		return nil, ErrNotFound
+	}
+
+	return p, nil
+}
+
+// InvalidSettingsError is returned by Update when a plugin rejects its
+// settings, as opposed to when they can't be saved
+type InvalidSettingsError struct {
+	Name string
+	Err  error
+}
+
+func (e *InvalidSettingsError) Error() string {
+	return fmt.Sprintf("invalid settings for plugin %s: %s", e.Name, e.Err)
+}
+
+func (e *InvalidSettingsError) Unwrap() error {
+	return e.Err
+}
+
+// Update persists a plugin's settings and configures it with them. The
+// settings are saved in a transaction that is only committed once the plugin
+// takes them, so a plugin never runs with settings that weren't saved, and
+// settings that a plugin rejects are never saved.
+func (r *Registry) Update(s Settings) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	p, ok := r.plugins[s.Name]
+	if !ok {
+		return ErrNotFound
+	}
+
+	restored, err := restoreSecrets(p, s.Settings, r.settings[s.Name].Settings)
+	if err != nil {
+		return &InvalidSettingsError{Name: s.Name, Err: fmt.Errorf("restoring masked secrets: %w", err)}
+	}
+	s.Settings = restored
+
+	tx, err := r.db.Begin()
+	if err != nil {
+		return fmt.Errorf("beginning plugin config tx: %w", err)
+	}
+	// a no-op once committed
+	defer tx.Rollback()
+
+	_, err = tx.Exec(`
+		insert into plugin_config(name, enabled, position, settings) values(?, ?, ?, ?)
+		on conflict(name) do update set enabled = excluded.enabled, position = excluded.position, settings = excluded.settings
+	`,
+		s.Name, s.Enabled, s.Position, string(s.Settings),
+	)
+	if err != nil {
+		return fmt.Errorf("executing plugin config upsert stmt: %w", err)
+	}
+
+	// configured before committing so rejected settings are rolled back, and
+	// with the saved settings put back if the commit fails
+	err = configure(p, s.Settings)
+	if err != nil {
+		return &InvalidSettingsError{Name: s.Name, Err: err}
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		if restoreErr := configure(p, r.settings[s.Name].Settings); restoreErr != nil {
+			log.Errorf("restoring settings of plugin %s: %s", s.Name, restoreErr)
+		}
+		return fmt.Errorf("committing plugin config tx: %w", err)
+	}
+
+	r.settings[s.Name] = s
+	return nil
+}
+
+// Select returns the plugins to run, in order. With no names, every enabled
+// plugin is returned. Otherwise only the named plugins are, enabled or not.
+func (r *Registry) Select(names []string) ([]Plugin, error) {
+	selected := map[string]bool{}
+	for _, name := range names {
+		if _, err := r.Plugin(name); err != nil {
+			return nil, fmt.Errorf("selecting plugin %s: %w", name, err)
+		}
+		selected[name] = true
+	}
+
+	var plugins []Plugin
+	for _, s := range r.List() {
+		if (len(names) == 0 && s.Enabled) || selected[s.Name] {
+			p, _ := r.Plugin(s.Name)
+			plugins = append(plugins, p)
+		}
+	}
+
+	return plugins, nil
+}
+
+func configure(p Plugin, settings json.RawMessage) error {
+	c, ok := p.(Configurable)
+	if !ok {
+		if len(settings) > 0 && string(settings) != "null" {
+			return fmt.Errorf("plugin %s has no settings", p.Name())
+		}
+		return nil
+	}
+
+	return c.Configure(settings)
+}
+
+func restore(p Plugin, settings json.RawMessage) error {
+	if r, ok := p.(Restorer); ok {
+		return r.Restore(settings)
+	}
+
+	return configure(p, settings)
+}
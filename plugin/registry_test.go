@@ -0,0 +1,166 @@
+package plugin
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"testing"
+
+	_ "github.com/mattn/go-sqlite3"
+)
+
+// fakePlugin takes any settings but "bad"
+type fakePlugin struct {
+	settings string
+}
+
+func (f *fakePlugin) Name() string {
+	return "fake"
+}
+
+func (f *fakePlugin) Generate(ctx context.Context, run Run) (Output, error) {
+	return Output{}, nil
+}
+
+func (f *fakePlugin) Configure(raw json.RawMessage) error {
+	if string(raw) == `"bad"` {
+		return errors.New("bad settings")
+	}
+	f.settings = string(raw)
+	return nil
+}
+
+func newTestDB(t *testing.T) *sql.DB {
+	t.Helper()
+
+	db, err := sql.Open("sqlite3", ":memory:")
+	if err != nil {
+		t.Fatalf("opening db: %s", err)
+	}
+	// every connection to :memory: is its own db
+	db.SetMaxOpenConns(1)
+	t.Cleanup(func() { db.Close() })
+
+	_, err = db.Exec(`create table plugin_config (
+		name text not null primary key,
+		enabled bool not null,
+		position int not null,
+		settings text not null
+	)`)
+	if err != nil {
+		t.Fatalf("creating table: %s", err)
+	}
+
+	return db
+}
+
+func newTestRegistry(t *testing.T) (*Registry, *fakePlugin, *sql.DB) {
+	t.Helper()
+
+	db := newTestDB(t)
+	p := &fakePlugin{}
+	r, err := NewRegistry(db, p)
+	if err != nil {
+		t.Fatalf("creating registry: %s", err)
+	}
+
+	return r, p, db
+}
+
+func savedSettings(t *testing.T, db *sql.DB) string {
+	t.Helper()
+
+	var settings string
+	err := db.QueryRow("select settings from plugin_config where name = 'fake'").Scan(&settings)
+	if errors.Is(err, sql.ErrNoRows) {
+		return ""
+	}
+	if err != nil {
+		t.Fatalf("querying settings: %s", err)
+	}
+	return settings
+}
+
+func TestUpdate(t *testing.T) {
+	r, p, db := newTestRegistry(t)
+
+	err := r.Update(Settings{Name: "fake", Enabled: true, Settings: json.RawMessage(`"good"`)})
+	if err != nil {
+		t.Fatalf("updating: %s", err)
+	}
+	if p.settings != `"good"` || savedSettings(t, db) != `"good"` {
+		t.Errorf("plugin has %s and db has %s, want both good", p.settings, savedSettings(t, db))
+	}
+
+	err = r.Update(Settings{Name: "fake", Enabled: true, Settings: json.RawMessage(`"bad"`)})
+	var invalid *InvalidSettingsError
+	if !errors.As(err, &invalid) {
+		t.Errorf("got error %v, want invalid settings", err)
+	}
+	if p.settings != `"good"` || savedSettings(t, db) != `"good"` {
+		t.Errorf("plugin has %s and db has %s, want rejected settings rolled back", p.settings, savedSettings(t, db))
+	}
+
+	err = r.Update(Settings{Name: "missing"})
+	if !errors.Is(err, ErrNotFound) {
+		t.Errorf("got error %v, want not found", err)
+	}
+}
+
+func TestUpdateStorageError(t *testing.T) {
+	r, p, db := newTestRegistry(t)
+
+	_, err := db.Exec("drop table plugin_config")
+	if err != nil {
+		t.Fatalf("dropping table: %s", err)
+	}
+
+	err = r.Update(Settings{Name: "fake", Enabled: true, Settings: json.RawMessage(`"good"`)})
+	var invalid *InvalidSettingsError
+	if err == nil || errors.As(err, &invalid) {
+		t.Errorf("got error %v, want a storage error", err)
+	}
+	if p.settings != "" {
+		t.Errorf("plugin was configured with %s, though its settings weren't saved", p.settings)
+	}
+	if s, _ := r.Get("fake"); len(s.Settings) != 0 {
+		t.Errorf("registry has settings %s that weren't saved", s.Settings)
+	}
+}
+
+func TestNewRegistryRejectedSettings(t *testing.T) {
+	db := newTestDB(t)
+	_, err := db.Exec(`insert into plugin_config(name, enabled, position, settings) values('fake', true, 3, '"bad"')`)
+	if err != nil {
+		t.Fatalf("inserting settings: %s", err)
+	}
+
+	p := &fakePlugin{}
+	r, err := NewRegistry(db, p)
+	if err != nil {
+		t.Fatalf("creating registry: %s", err)
+	}
+
+	s, err := r.Get("fake")
+	if err != nil {
+		t.Fatalf("getting settings: %s", err)
+	}
+	if s.Enabled || s.Position != 3 {
+		t.Errorf("got settings %+v, want the plugin disabled in its saved position", s)
+	}
+	if p.settings != "" {
+		t.Errorf("plugin was configured with %s, want its defaults", p.settings)
+	}
+	if saved := savedSettings(t, db); saved != `"bad"` {
+		t.Errorf("db has %s, want the saved settings kept", saved)
+	}
+
+	err = r.Update(Settings{Name: "fake", Enabled: true, Settings: json.RawMessage(`"good"`)})
+	if err != nil {
+		t.Fatalf("fixing settings: %s", err)
+	}
+	if s, _ := r.Get("fake"); !s.Enabled || p.settings != `"good"` {
+		t.Errorf("got settings %+v and plugin settings %s, want the fixed settings applied", s, p.settings)
+	}
+}
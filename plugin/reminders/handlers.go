@@ -0,0 +1,142 @@
+package reminders
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	log "github.com/golang/glog"
+	"github.com/google/uuid"
+	"github.com/valyala/fasthttp"
+
+	"github.com/jchorl/gowaker/requestcontext"
+)
+
+// HandlerGet lists every reminder, delivered or not
+func HandlerGet(ctx *fasthttp.RequestCtx) {
+	db := requestcontext.DB(ctx)
+
+	reminders, err := allReminders(ctx, db)
+	if err != nil {
+		err = fmt.Errorf("getting reminders: %w", err)
+		log.Error(err)
+		ctx.Error(err.Error(), fasthttp.StatusInternalServerError)
+		return
+	}
+
+	ctx.Response.SetStatusCode(fasthttp.StatusOK)
+	json.NewEncoder(ctx).Encode(reminders)
+}
+
+// HandlerPost creates a reminder
+func HandlerPost(ctx *fasthttp.RequestCtx) {
+	db := requestcontext.DB(ctx)
+
+	reminder := Reminder{}
+	err := json.Unmarshal(ctx.Request.Body(), &reminder)
+	if err != nil {
+		err = fmt.Errorf("decoding body: %w", err)
+		log.Error(err)
+		ctx.Error(err.Error(), fasthttp.StatusBadRequest)
+		return
+	}
+
+	err = reminder.validate()
+	if err != nil {
+		log.Error(err)
+		ctx.Error(err.Error(), fasthttp.StatusBadRequest)
+		return
+	}
+
+	reminder.ID = uuid.New().String()
+	reminder.Start = reminder.Due
+	reminder.Created = time.Now()
+	reminder.Delivered = nil
+
+	err = saveReminder(ctx, db, reminder)
+	if err != nil {
+		err = fmt.Errorf("saving reminder: %w", err)
+		log.Error(err)
+		ctx.Error(err.Error(), fasthttp.StatusInternalServerError)
+		return
+	}
+
+	ctx.Response.SetStatusCode(fasthttp.StatusOK)
+	json.NewEncoder(ctx).Encode(reminder)
+}
+
+// HandlerPut updates a reminder. Fields missing from the body are left as is.
+// Moving the due date of a delivered reminder makes it pending again, and
+// moving the due date or changing the recurrence of a recurring reminder
+// restarts the recurrence from the due date.
+func HandlerPut(ctx *fasthttp.RequestCtx) {
+	db := requestcontext.DB(ctx)
+	id := ctx.UserValue("id").(string)
+
+	reminder, err := getReminder(ctx, db, id)
+	if errors.Is(err, sql.ErrNoRows) {
+		ctx.Error(fmt.Sprintf("reminder %s not found", id), fasthttp.StatusNotFound)
+		return
+	}
+	if err != nil {
+		err = fmt.Errorf("getting reminder: %w", err)
+		log.Error(err)
+		ctx.Error(err.Error(), fasthttp.StatusInternalServerError)
+		return
+	}
+
+	previous := reminder
+	err = json.Unmarshal(ctx.Request.Body(), &reminder)
+	if err != nil {
+		err = fmt.Errorf("decoding body: %w", err)
+		log.Error(err)
+		ctx.Error(err.Error(), fasthttp.StatusBadRequest)
+		return
+	}
+	reminder.ID = id
+
+	err = reminder.validate()
+	if err != nil {
+		log.Error(err)
+		ctx.Error(err.Error(), fasthttp.StatusBadRequest)
+		return
+	}
+
+	if !reminder.Due.Equal(previous.Due) {
+		reminder.Delivered = nil
+	}
+	if !reminder.Due.Equal(previous.Due) || reminder.Recurrence != previous.Recurrence {
+		reminder.Start = reminder.Due
+	}
+
+	err = saveReminder(ctx, db, reminder)
+	if err != nil {
+		err = fmt.Errorf("saving reminder: %w", err)
+		log.Error(err)
+		ctx.Error(err.Error(), fasthttp.StatusInternalServerError)
+		return
+	}
+
+	ctx.Response.SetStatusCode(fasthttp.StatusOK)
+	json.NewEncoder(ctx).Encode(reminder)
+}
+
+// HandlerDelete deletes a reminder
+func HandlerDelete(ctx *fasthttp.RequestCtx) {
+	db := requestcontext.DB(ctx)
+	id := ctx.UserValue("id").(string)
+
+	res, err := db.ExecContext(ctx, "delete from reminders where id = ?", id)
+	if err != nil {
+		err = fmt.Errorf("deleting reminder: %w", err)
+		log.Error(err)
+		ctx.Error(err.Error(), fasthttp.StatusInternalServerError)
+		return
+	}
+
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		ctx.Error(fmt.Sprintf("reminder %s not found", id), fasthttp.StatusNotFound)
+	}
+}
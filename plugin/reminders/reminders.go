@@ -0,0 +1,260 @@
+// Package reminders keeps reminders in the database and reads out the ones
+// that are due during the briefing
+package reminders
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+
+	log "github.com/golang/glog"
+	"github.com/teambition/rrule-go"
+
+	"github.com/jchorl/gowaker/plugin"
+	"github.com/jchorl/gowaker/ssml"
+)
+
+// Reminder is something to read out once it is due
+type Reminder struct {
+	ID   string    `json:"id"`
+	Text string    `json:"text"`
+	Due  time.Time `json:"due"`
+
+	// Recurrence is an RRULE, e.g. FREQ=WEEKLY;BYDAY=TU. Recurring reminders
+	// move on to their next occurrence once delivered, instead of being marked
+	// delivered.
+	Recurrence string `json:"recurrence"`
+
+	// Start is the first occurrence of a recurring reminder, which the
+	// recurrence is counted from while Due moves on
+	Start time.Time `json:"-"`
+
+	Delivered *time.Time `json:"delivered,omitempty"`
+	Created   time.Time  `json:"created"`
+}
+
+func (r Reminder) validate() error {
+	if strings.TrimSpace(r.Text) == "" {
+		return errors.New("text is required")
+	}
+	if r.Due.IsZero() {
+		return errors.New("due is required")
+	}
+	if r.Recurrence != "" {
+		_, err := rrule.StrToROption(r.Recurrence)
+		if err != nil {
+			return fmt.Errorf("parsing recurrence: %w", err)
+		}
+	}
+	return nil
+}
+
+// next returns the first occurrence of a recurring reminder after a time,
+// counting from its start, or the zero time once the recurrence has ended
+func (r Reminder) next(after time.Time, loc *time.Location) (time.Time, error) {
+	opt, err := rrule.StrToROptionInLocation(r.Recurrence, loc)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("parsing recurrence: %w", err)
+	}
+	opt.Dtstart = r.Start.In(loc)
+
+	rule, err := rrule.NewRRule(*opt)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("creating rrule: %w", err)
+	}
+
+	return rule.After(after, false), nil
+}
+
+// Reminders is a plugin that reads out due reminders
+type Reminders struct {
+	db *sql.DB
+}
+
+// New creates a reminders plugin
+func New(db *sql.DB) *Reminders {
+	return &Reminders{db: db}
+}
+
+// Name returns the name of the plugin
+func (r *Reminders) Name() string {
+	return "reminders"
+}
+
+// Generate reads out the reminders that are due by the time the alarm went
+// off. They are only marked delivered once they have been spoken.
+func (r *Reminders) Generate(ctx context.Context, run plugin.Run) (plugin.Output, error) {
+	now := run.Scheduled
+	if now.IsZero() {
+		now = time.Now()
+	}
+
+	due, err := dueReminders(ctx, r.db, now)
+	if err != nil {
+		return plugin.Output{}, err
+	}
+	if len(due) == 0 {
+		return plugin.Output{Data: due}, nil
+	}
+
+	str := "Don't forget to "
+	if len(due) > 1 {
+		str = fmt.Sprintf("You have %d reminders.", len(due)) + ssml.Break(300*time.Millisecond)
+	}
+	for _, reminder := range due {
+		text := strings.TrimSpace(reminder.Text)
+		if len(due) == 1 {
+			first, size := utf8.DecodeRuneInString(text)
+			text = string(unicode.ToLower(first)) + text[size:]
+		}
+		str += ssml.Escape(text)
+		if !strings.ContainsAny(text[len(text)-1:], ".!?") {
+			str += "."
+		}
+		str += " " + ssml.Break(300*time.Millisecond)
+	}
+
+	return plugin.Output{
+		Text: ssml.ToText(str),
+		SSML: str,
+		Data: due,
+	}, nil
+}
+
+// Spoken marks the reminders that were read out delivered
+func (r *Reminders) Spoken(ctx context.Context, run plugin.Run, output plugin.Output) error {
+	if run.Preview {
+		return nil
+	}
+
+	due, ok := output.Data.([]Reminder)
+	if !ok {
+		return fmt.Errorf("unexpected output data %T", output.Data)
+	}
+
+	now := run.Scheduled
+	if now.IsZero() {
+		now = time.Now()
+	}
+
+	for _, reminder := range due {
+		err := markDelivered(ctx, r.db, reminder, now, run.Location)
+		if err != nil {
+			log.Errorf("marking reminder %s delivered: %s", reminder.ID, err)
+		}
+	}
+
+	return nil
+}
+
+func scanReminder(row interface{ Scan(...interface{}) error }) (Reminder, error) {
+	var r Reminder
+	var due, start, delivered, created int64
+	err := row.Scan(&r.ID, &r.Text, &due, &r.Recurrence, &start, &delivered, &created)
+	if err != nil {
+		return Reminder{}, err
+	}
+
+	r.Due = time.Unix(due, 0)
+	// saved before the start was
+	if start == 0 {
+		start = due
+	}
+	r.Start = time.Unix(start, 0)
+	r.Created = time.Unix(created, 0)
+	if delivered != 0 {
+		t := time.Unix(delivered, 0)
+		r.Delivered = &t
+	}
+
+	return r, nil
+}
+
+const reminderColumns = "id, text, due_at, recurrence, starts_at, delivered_at, created_at"
+
+func queryReminders(ctx context.Context, db *sql.DB, query string, args ...interface{}) ([]Reminder, error) {
+	rows, err := db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("querying reminders: %w", err)
+	}
+	defer rows.Close()
+
+	reminders := []Reminder{}
+	for rows.Next() {
+		r, err := scanReminder(rows)
+		if err != nil {
+			return nil, fmt.Errorf("scanning row: %w", err)
+		}
+		reminders = append(reminders, r)
+	}
+	err = rows.Err()
+	if err != nil {
+		return nil, fmt.Errorf("iterating over reminder query results: %w", err)
+	}
+
+	return reminders, nil
+}
+
+func allReminders(ctx context.Context, db *sql.DB) ([]Reminder, error) {
+	return queryReminders(ctx, db, "select "+reminderColumns+" from reminders order by due_at, created_at")
+}
+
+func dueReminders(ctx context.Context, db *sql.DB, now time.Time) ([]Reminder, error) {
+	return queryReminders(ctx, db, "select "+reminderColumns+" from reminders where delivered_at = 0 and due_at <= ? order by due_at, created_at", now.Unix())
+}
+
+func getReminder(ctx context.Context, db *sql.DB, id string) (Reminder, error) {
+	row := db.QueryRowContext(ctx, "select "+reminderColumns+" from reminders where id = ?", id)
+	return scanReminder(row)
+}
+
+func saveReminder(ctx context.Context, db *sql.DB, r Reminder) error {
+	var delivered int64
+	if r.Delivered != nil {
+		delivered = r.Delivered.Unix()
+	}
+
+	stmt, err := db.PrepareContext(ctx, `
+		insert into reminders(`+reminderColumns+`) values(?, ?, ?, ?, ?, ?, ?)
+		on conflict(id) do update set text = excluded.text, due_at = excluded.due_at, recurrence = excluded.recurrence, starts_at = excluded.starts_at, delivered_at = excluded.delivered_at
+	`,
+	)
+	if err != nil {
+		return fmt.Errorf("preparing reminder upsert stmt: %w", err)
+	}
+	defer stmt.Close()
+
+	_, err = stmt.ExecContext(ctx, r.ID, r.Text, r.Due.Unix(), r.Recurrence, r.Start.Unix(), delivered, r.Created.Unix())
+	if err != nil {
+		return fmt.Errorf("executing reminder upsert stmt: %w", err)
+	}
+
+	return nil
+}
+
+// markDelivered marks a one-shot reminder delivered, and moves a recurring
+// reminder on to its next occurrence
+func markDelivered(ctx context.Context, db *sql.DB, r Reminder, now time.Time, loc *time.Location) error {
+	if loc == nil {
+		loc = time.Local
+	}
+
+	if r.Recurrence != "" {
+		next, err := r.next(now, loc)
+		if err != nil {
+			return err
+		}
+		if !next.IsZero() {
+			r.Due = next
+			return saveReminder(ctx, db, r)
+		}
+	}
+
+	r.Delivered = &now
+	return saveReminder(ctx, db, r)
+}
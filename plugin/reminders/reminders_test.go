@@ -0,0 +1,156 @@
+package reminders
+
+import (
+	"context"
+	"database/sql"
+	"testing"
+	"time"
+
+	_ "github.com/mattn/go-sqlite3"
+
+	"github.com/jchorl/gowaker/plugin"
+)
+
+func newTestDB(t *testing.T) *sql.DB {
+	t.Helper()
+
+	db, err := sql.Open("sqlite3", ":memory:")
+	if err != nil {
+		t.Fatalf("opening db: %s", err)
+	}
+	// every connection to :memory: is its own db
+	db.SetMaxOpenConns(1)
+	t.Cleanup(func() { db.Close() })
+
+	_, err = db.Exec(`create table reminders (
+		id text not null primary key,
+		text text not null,
+		due_at int not null,
+		recurrence text not null default '',
+		starts_at int not null default 0,
+		delivered_at int not null default 0,
+		created_at int not null
+	)`)
+	if err != nil {
+		t.Fatalf("creating table: %s", err)
+	}
+
+	return db
+}
+
+// deliver runs the plugin the way an alarm at now would, and returns the ids
+// of the reminders it read out
+func deliver(t *testing.T, r *Reminders, now time.Time) []string {
+	t.Helper()
+
+	run := plugin.Run{Scheduled: now, Location: time.UTC}
+	out, err := r.Generate(context.Background(), run)
+	if err != nil {
+		t.Fatalf("generating: %s", err)
+	}
+	err = r.Spoken(context.Background(), run, out)
+	if err != nil {
+		t.Fatalf("marking spoken: %s", err)
+	}
+
+	var ids []string
+	for _, reminder := range out.Data.([]Reminder) {
+		ids = append(ids, reminder.ID)
+	}
+	return ids
+}
+
+func TestRecurrence(t *testing.T) {
+	start := time.Date(2021, 3, 1, 7, 0, 0, 0, time.UTC)
+	day := 24 * time.Hour
+
+	tests := []struct {
+		name       string
+		recurrence string
+		// alarms go off daily from the start, and want are the days with
+		// the reminder in the briefing
+		days  int
+		want  []int
+		ended bool
+	}{
+		{"count", "FREQ=DAILY;COUNT=3", 7, []int{0, 1, 2}, true},
+		{"until", "FREQ=DAILY;UNTIL=20210303T070000Z", 7, []int{0, 1, 2}, true},
+		{"interval", "FREQ=DAILY;INTERVAL=3", 10, []int{0, 3, 6, 9}, false},
+		{"interval and count", "FREQ=DAILY;INTERVAL=2;COUNT=3", 10, []int{0, 2, 4}, true},
+		{"weekly by day", "FREQ=WEEKLY;BYDAY=MO,TH;COUNT=4", 14, []int{0, 3, 7, 10}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db := newTestDB(t)
+			err := saveReminder(context.Background(), db, Reminder{
+				ID:         "r",
+				Text:       "Water the plants",
+				Due:        start,
+				Start:      start,
+				Recurrence: tt.recurrence,
+				Created:    start,
+			})
+			if err != nil {
+				t.Fatalf("saving reminder: %s", err)
+			}
+
+			var got []int
+			for d := 0; d < tt.days; d++ {
+				if ids := deliver(t, New(db), start.Add(time.Duration(d)*day)); len(ids) > 0 {
+					got = append(got, d)
+				}
+			}
+
+			if len(got) != len(tt.want) {
+				t.Fatalf("delivered on days %v, want %v", got, tt.want)
+			}
+			for i := range got {
+				if got[i] != tt.want[i] {
+					t.Fatalf("delivered on days %v, want %v", got, tt.want)
+				}
+			}
+
+			reminder, err := getReminder(context.Background(), db, "r")
+			if err != nil {
+				t.Fatalf("getting reminder: %s", err)
+			}
+			if ended := reminder.Delivered != nil; ended != tt.ended {
+				t.Errorf("got delivered %t with due %s, want %t", ended, reminder.Due, tt.ended)
+			}
+			if !reminder.Start.Equal(start) {
+				t.Errorf("start moved to %s", reminder.Start)
+			}
+		})
+	}
+}
+
+func TestPreviewLeavesRemindersPending(t *testing.T) {
+	db := newTestDB(t)
+	now := time.Date(2021, 3, 1, 7, 0, 0, 0, time.UTC)
+	err := saveReminder(context.Background(), db, Reminder{ID: "r", Text: "Call mom", Due: now, Start: now, Created: now})
+	if err != nil {
+		t.Fatalf("saving reminder: %s", err)
+	}
+
+	r := New(db)
+	run := plugin.Run{Scheduled: now, Preview: true}
+	out, err := r.Generate(context.Background(), run)
+	if err != nil {
+		t.Fatalf("generating: %s", err)
+	}
+	if out.Text != "Don't forget to call mom." {
+		t.Errorf("got %q", out.Text)
+	}
+	err = r.Spoken(context.Background(), run, out)
+	if err != nil {
+		t.Fatalf("marking spoken: %s", err)
+	}
+
+	if ids := deliver(t, r, now); len(ids) != 1 {
+		t.Errorf("got %v, want the reminder still pending after a preview", ids)
+	}
+	if ids := deliver(t, r, now); len(ids) != 0 {
+		t.Errorf("got %v, want the reminder delivered", ids)
+	}
+}
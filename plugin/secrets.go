@@ -0,0 +1,58 @@
+package plugin
+
+import (
+	"encoding/json"
+
+	log "github.com/golang/glog"
+)
+
+// MaskedSecret stands in for secrets when settings are read back
+const MaskedSecret = "********"
+
+// Secretive is a Configurable plugin whose settings hold secrets, like
+// passwords, that aren't shown when settings are read back over the API
+type Secretive interface {
+	Configurable
+
+	// MaskSecrets replaces every secret in settings with MaskedSecret
+	MaskSecrets(settings json.RawMessage) (json.RawMessage, error)
+
+	// RestoreSecrets replaces each MaskedSecret in settings with the secret
+	// it stands for in current, so settings that were read back can be
+	// saved again as they are
+	RestoreSecrets(settings, current json.RawMessage) (json.RawMessage, error)
+}
+
+// Masked returns settings with the plugin's secrets masked. If they can't be
+// masked, they are left out entirely rather than shown.
+func (r *Registry) Masked(s Settings) Settings {
+	p, err := r.Plugin(s.Name)
+	if err != nil || len(s.Settings) == 0 {
+		return s
+	}
+
+	secretive, ok := p.(Secretive)
+	if !ok {
+		return s
+	}
+
+	masked, err := secretive.MaskSecrets(s.Settings)
+	if err != nil {
+		log.Errorf("masking settings of plugin %s: %s", s.Name, err)
+		s.Settings = nil
+		return s
+	}
+
+	s.Settings = masked
+	return s
+}
+
+// restoreSecrets puts back the secrets that settings for p have masked
+func restoreSecrets(p Plugin, settings, current json.RawMessage) (json.RawMessage, error) {
+	secretive, ok := p.(Secretive)
+	if !ok || len(settings) == 0 {
+		return settings, nil
+	}
+
+	return secretive.RestoreSecrets(settings, current)
+}
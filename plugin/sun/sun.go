@@ -0,0 +1,200 @@
+// Package sun reads out sunrise, sunset and the length of the day, computed
+// locally from coordinates
+package sun
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"sync"
+	"time"
+
+	"github.com/jchorl/gowaker/plugin"
+	"github.com/jchorl/gowaker/ssml"
+)
+
+// Sun is a plugin that reads out today's sunrise, sunset and daylight
+type Sun struct {
+	mu       sync.RWMutex
+	settings Settings
+}
+
+// Settings are the coordinates to compute the sun's times for
+type Settings struct {
+	Lat float64 `json:"lat"`
+	Lon float64 `json:"lon"`
+}
+
+// Day is the structured data behind the sun plugin's output. Sunrise and
+// Sunset are zero when the sun doesn't rise or set.
+type Day struct {
+	Sunrise  time.Time     `json:"sunrise"`
+	Sunset   time.Time     `json:"sunset"`
+	Daylight time.Duration `json:"daylight"`
+
+	// Change is how much longer the daylight is than yesterday's
+	Change time.Duration `json:"change"`
+}
+
+// New creates a sun plugin with no location
+func New() *Sun {
+	return &Sun{}
+}
+
+// Name returns the name of the plugin
+func (s *Sun) Name() string {
+	return "sun"
+}
+
+// Configure sets the coordinates
+func (s *Sun) Configure(raw json.RawMessage) error {
+	settings := Settings{}
+	if len(raw) > 0 {
+		err := json.Unmarshal(raw, &settings)
+		if err != nil {
+			return fmt.Errorf("decoding settings: %w", err)
+		}
+	}
+
+	if settings.Lat < -90 || settings.Lat > 90 {
+		return fmt.Errorf("lat %f is out of range", settings.Lat)
+	}
+	if settings.Lon < -180 || settings.Lon > 180 {
+		return fmt.Errorf("lon %f is out of range", settings.Lon)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.settings = settings
+
+	return nil
+}
+
+func (s *Sun) currentSettings() Settings {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return s.settings
+}
+
+// Generate reads out the sun's times for the alarm's day
+func (s *Sun) Generate(ctx context.Context, run plugin.Run) (plugin.Output, error) {
+	settings := s.currentSettings()
+	if settings.Lat == 0 && settings.Lon == 0 {
+		return plugin.Output{}, nil
+	}
+
+	loc := run.Location
+	if loc == nil {
+		loc = time.Local
+	}
+	scheduled := run.Scheduled
+	if scheduled.IsZero() {
+		scheduled = time.Now()
+	}
+	year, month, date := scheduled.In(loc).Date()
+	today := time.Date(year, month, date, 0, 0, 0, 0, loc)
+
+	day := Times(today, settings.Lat, settings.Lon)
+	yesterday := Times(today.AddDate(0, 0, -1), settings.Lat, settings.Lon)
+	day.Change = day.Daylight - yesterday.Daylight
+
+	str := say(day)
+	return plugin.Output{
+		Text: ssml.ToText(str),
+		SSML: str,
+		Data: day,
+	}, nil
+}
+
+// j2000 is the epoch of the julian dates used below, noon UTC on 2000-01-01
+var j2000 = time.Date(2000, 1, 1, 12, 0, 0, 0, time.UTC)
+
+// Times computes sunrise and sunset on the date of day, in day's location,
+// using the sunrise equation. It is accurate to within a minute or two away
+// from the poles.
+func Times(day time.Time, lat, lon float64) Day {
+	year, month, date := day.Date()
+	// whole days since the epoch for the local date
+	n := math.Round(time.Date(year, month, date, 12, 0, 0, 0, time.UTC).Sub(j2000).Hours() / 24)
+
+	// mean solar noon, in days since the epoch
+	meanNoon := n + 0.0008 - lon/360
+
+	anomaly := math.Mod(357.5291+0.98560028*meanNoon, 360)
+	center := 1.9148*sin(anomaly) + 0.0200*sin(2*anomaly) + 0.0003*sin(3*anomaly)
+	longitude := math.Mod(anomaly+center+180+102.9372, 360)
+	transit := meanNoon + 0.0053*sin(anomaly) - 0.0069*sin(2*longitude)
+
+	declination := math.Asin(sin(longitude) * sin(23.4397))
+	// -0.833 degrees accounts for refraction and the size of the sun's disc
+	cosHourAngle := (sin(-0.833) - sin(lat)*math.Sin(declination)) / (cos(lat) * math.Cos(declination))
+
+	switch {
+	case cosHourAngle < -1:
+		// the sun doesn't set
+		return Day{Daylight: 24 * time.Hour}
+	case cosHourAngle > 1:
+		// the sun doesn't rise
+		return Day{}
+	}
+
+	hourAngle := math.Acos(cosHourAngle) * 180 / math.Pi
+	rise := fromEpoch(transit - hourAngle/360).In(day.Location())
+	set := fromEpoch(transit + hourAngle/360).In(day.Location())
+
+	return Day{Sunrise: rise, Sunset: set, Daylight: set.Sub(rise)}
+}
+
+func fromEpoch(days float64) time.Time {
+	return j2000.Add(time.Duration(days * 24 * float64(time.Hour))).Truncate(time.Second)
+}
+
+func sin(degrees float64) float64 {
+	return math.Sin(degrees * math.Pi / 180)
+}
+
+func cos(degrees float64) float64 {
+	return math.Cos(degrees * math.Pi / 180)
+}
+
+func say(day Day) string {
+	switch {
+	case day.Sunrise.IsZero() && day.Daylight > 0:
+		return "The sun won't set today."
+	case day.Sunrise.IsZero():
+		return "The sun won't rise today."
+	}
+
+	str := fmt.Sprintf("Sunrise is at %s and sunset is at %s, for %s of daylight. ",
+		ssml.Time(day.Sunrise), ssml.Time(day.Sunset), hoursAndMinutes(day.Daylight))
+
+	minutes := int(math.Round(math.Abs(day.Change.Minutes())))
+	switch {
+	case minutes == 0:
+		str += "That's about the same as yesterday."
+	case day.Change > 0:
+		str += fmt.Sprintf("That's %s more than yesterday.", plural(minutes, "minute"))
+	default:
+		str += fmt.Sprintf("That's %s less than yesterday.", plural(minutes, "minute"))
+	}
+
+	return str
+}
+
+func hoursAndMinutes(d time.Duration) string {
+	minutes := int(math.Round(d.Minutes()))
+	hours, minutes := minutes/60, minutes%60
+	if minutes == 0 {
+		return plural(hours, "hour")
+	}
+	return plural(hours, "hour") + " and " + plural(minutes, "minute")
+}
+
+func plural(n int, unit string) string {
+	if n == 1 {
+		return "1 " + unit
+	}
+	return fmt.Sprintf("%d %ss", n, unit)
+}
@@ -0,0 +1,109 @@
+package sun
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jchorl/gowaker/plugin"
+)
+
+func TestTimes(t *testing.T) {
+	// times from NOAA's solar calculator, rounded to the minute
+	tests := []struct {
+		name     string
+		zone     string
+		lat, lon float64
+		date     time.Time
+		sunrise  string
+		sunset   string
+	}{
+		{"toronto summer solstice", "America/Toronto", 43.65, -79.38, time.Date(2021, 6, 21, 0, 0, 0, 0, time.UTC), "05:36", "21:03"},
+		{"london winter solstice", "Europe/London", 51.5074, -0.1278, time.Date(2021, 12, 21, 0, 0, 0, 0, time.UTC), "08:04", "15:53"},
+		{"sydney equinox", "Australia/Sydney", -33.8688, 151.2093, time.Date(2021, 3, 20, 0, 0, 0, 0, time.UTC), "06:58", "19:07"},
+		{"quito", "America/Guayaquil", -0.1807, -78.4678, time.Date(2021, 3, 20, 0, 0, 0, 0, time.UTC), "06:18", "18:25"},
+		{"los angeles equinox", "America/Los_Angeles", 34.05, -118.24, time.Date(2021, 9, 22, 0, 0, 0, 0, time.UTC), "06:41", "18:50"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			loc, err := time.LoadLocation(tt.zone)
+			if err != nil {
+				t.Fatalf("loading location: %s", err)
+			}
+			year, month, date := tt.date.Date()
+			day := Times(time.Date(year, month, date, 0, 0, 0, 0, loc), tt.lat, tt.lon)
+
+			checkTime(t, "sunrise", day.Sunrise, loc, tt.date, tt.sunrise)
+			checkTime(t, "sunset", day.Sunset, loc, tt.date, tt.sunset)
+			if day.Daylight != day.Sunset.Sub(day.Sunrise) {
+				t.Errorf("got daylight %s between %s and %s", day.Daylight, day.Sunrise, day.Sunset)
+			}
+		})
+	}
+}
+
+// checkTime checks that got is within two minutes of the clock time want on
+// date in loc
+func checkTime(t *testing.T, name string, got time.Time, loc *time.Location, date time.Time, want string) {
+	t.Helper()
+
+	clock, err := time.Parse("15:04", want)
+	if err != nil {
+		t.Fatalf("parsing %s: %s", want, err)
+	}
+	year, month, day := date.Date()
+	wantTime := time.Date(year, month, day, clock.Hour(), clock.Minute(), 0, 0, loc)
+
+	if diff := got.Sub(wantTime); diff < -2*time.Minute || diff > 2*time.Minute {
+		t.Errorf("got %s %s, want %s", name, got.In(loc).Format("15:04:05"), want)
+	}
+	if got.Location() != loc {
+		t.Errorf("got %s in %s, want %s", name, got.Location(), loc)
+	}
+}
+
+func TestTimesPolar(t *testing.T) {
+	// tromsø
+	const lat, lon = 69.6492, 18.9553
+
+	polarDay := Times(time.Date(2021, 6, 21, 0, 0, 0, 0, time.UTC), lat, lon)
+	if !polarDay.Sunrise.IsZero() || !polarDay.Sunset.IsZero() || polarDay.Daylight != 24*time.Hour {
+		t.Errorf("got %+v, want a polar day", polarDay)
+	}
+
+	polarNight := Times(time.Date(2021, 12, 21, 0, 0, 0, 0, time.UTC), lat, lon)
+	if !polarNight.Sunrise.IsZero() || !polarNight.Sunset.IsZero() || polarNight.Daylight != 0 {
+		t.Errorf("got %+v, want a polar night", polarNight)
+	}
+
+	// antarctic winter during the arctic's summer
+	if antarctic := Times(time.Date(2021, 6, 21, 0, 0, 0, 0, time.UTC), -77.85, 166.67); antarctic.Daylight != 0 {
+		t.Errorf("got %+v, want a polar night at mcmurdo", antarctic)
+	}
+}
+
+func TestGenerate(t *testing.T) {
+	s := New()
+	err := s.Configure([]byte(`{"lat":69.6492,"lon":18.9553}`))
+	if err != nil {
+		t.Fatalf("configuring: %s", err)
+	}
+
+	out, err := s.Generate(context.Background(), plugin.Run{Scheduled: time.Date(2021, 6, 21, 6, 0, 0, 0, time.UTC), Location: time.UTC})
+	if err != nil {
+		t.Fatalf("generating: %s", err)
+	}
+	if out.Text != "The sun won't set today." {
+		t.Errorf("got %q", out.Text)
+	}
+
+	out, err = s.Generate(context.Background(), plugin.Run{Scheduled: time.Date(2021, 3, 20, 6, 0, 0, 0, time.UTC), Location: time.UTC})
+	if err != nil {
+		t.Fatalf("generating: %s", err)
+	}
+	if !strings.Contains(out.Text, "more than yesterday") {
+		t.Errorf("got %q, want the days getting longer in spring", out.Text)
+	}
+}
@@ -0,0 +1,174 @@
+package weather
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"math"
+	"time"
+
+	log "github.com/golang/glog"
+)
+
+// freshFor is how long a cached forecast is used without trying to fetch a
+// new one. Forecasts are refreshed hourly, so this leaves some slack.
+const freshFor = 90 * time.Minute
+
+// Refresh fetches the forecast for the current settings in each of the time
+// zones that alarms go off in and caches them, so they are ready when an alarm
+// goes off. With no zones, the local one is used.
+func (w *Weather) Refresh(ctx context.Context, locs ...*time.Location) error {
+	if w.db == nil {
+		return nil
+	}
+	if len(locs) == 0 {
+		locs = []*time.Location{time.Local}
+	}
+
+	w.mu.RLock()
+	settings := w.settings
+	w.mu.RUnlock()
+
+	var lastErr error
+	for _, loc := range locs {
+		_, err := w.fetch(ctx, settings, loc)
+		if err != nil {
+			log.Errorf("refreshing forecast in %s: %s", loc, err)
+			lastErr = err
+		}
+	}
+
+	return lastErr
+}
+
+// forecast returns a fresh cached forecast if there is one. Otherwise it
+// fetches a new forecast, falling back to a stale cached one if that fails.
+func (w *Weather) forecast(ctx context.Context, settings Settings, loc *time.Location) (Forecast, error) {
+	cached, cacheErr := w.cached(settings, loc)
+	if cacheErr == nil && time.Since(cached.FetchedAt) < freshFor {
+		return cached.in(loc), nil
+	}
+	if cacheErr != nil && cacheErr != sql.ErrNoRows {
+		log.Errorf("reading cached forecast: %s", cacheErr)
+	}
+
+	forecast, err := w.fetch(ctx, settings, loc)
+	if err == nil {
+		return forecast, nil
+	}
+	if cacheErr != nil {
+		return Forecast{}, err
+	}
+
+	log.Errorf("fetching forecast, using the cached one from %s: %s", cached.FetchedAt, err)
+	cached.Stale = true
+	return cached.in(loc), nil
+}
+
+// fetch fetches a forecast from the provider and caches it
+func (w *Weather) fetch(ctx context.Context, settings Settings, loc *time.Location) (Forecast, error) {
+	forecast, err := w.providers[settings.Provider].Forecast(ctx, Query{
+		Unit:     settings.Unit,
+		CityID:   settings.CityID,
+		Lat:      settings.Lat,
+		Lon:      settings.Lon,
+		Location: loc,
+	})
+	if err != nil {
+		return Forecast{}, fmt.Errorf("fetching forecast from %s: %w", settings.Provider, err)
+	}
+	forecast.FetchedAt = time.Now()
+
+	if w.db == nil {
+		return forecast, nil
+	}
+
+	b, err := json.Marshal(forecast)
+	if err != nil {
+		return Forecast{}, fmt.Errorf("marshalling forecast: %w", err)
+	}
+
+	stmt, err := w.db.Prepare(`
+		insert into weather_cache(key, fetched_at, forecast) values(?, ?, ?)
+		on conflict(key) do update set fetched_at = excluded.fetched_at, forecast = excluded.forecast
+	`,
+	)
+	if err != nil {
+		log.Errorf("preparing weather cache upsert stmt: %s", err)
+		return forecast, nil
+	}
+	defer stmt.Close()
+	_, err = stmt.Exec(cacheKey(settings, loc), forecast.FetchedAt.Unix(), string(b))
+	if err != nil {
+		log.Errorf("executing weather cache upsert stmt: %s", err)
+	}
+
+	return forecast, nil
+}
+
+func (w *Weather) cached(settings Settings, loc *time.Location) (Forecast, error) {
+	if w.db == nil {
+		return Forecast{}, sql.ErrNoRows
+	}
+
+	stmt, err := w.db.Prepare("select forecast from weather_cache where key = ?")
+	if err != nil {
+		return Forecast{}, fmt.Errorf("preparing weather cache select stmt: %w", err)
+	}
+	defer stmt.Close()
+
+	var b string
+	err = stmt.QueryRow(cacheKey(settings, loc)).Scan(&b)
+	if err != nil {
+		return Forecast{}, err
+	}
+
+	forecast := Forecast{}
+	err = json.Unmarshal([]byte(b), &forecast)
+	if err != nil {
+		return Forecast{}, fmt.Errorf("unmarshalling cached forecast: %w", err)
+	}
+
+	return forecast, nil
+}
+
+// cacheKey identifies everything about the settings that changes the
+// forecast, and the time zone, since that decides which day is today
+func cacheKey(s Settings, loc *time.Location) string {
+	if loc == nil {
+		loc = time.Local
+	}
+	return fmt.Sprintf("%s:%s:%d:%v:%v:%s", s.Provider, s.Unit, s.CityID, s.Lat, s.Lon, loc)
+}
+
+// in converts every time in the forecast to loc
+func (f Forecast) in(loc *time.Location) Forecast {
+	hourly := make([]Hour, len(f.Hourly))
+	for i, h := range f.Hourly {
+		h.Time = h.Time.In(loc)
+		hourly[i] = h
+	}
+	f.Hourly = hourly
+
+	alerts := make([]Alert, len(f.Alerts))
+	for i, a := range f.Alerts {
+		a.Start = a.Start.In(loc)
+		a.End = a.End.In(loc)
+		alerts[i] = a
+	}
+	f.Alerts = alerts
+
+	return f
+}
+
+// age describes how old a forecast is, e.g. "3 hours"
+func age(d time.Duration) string {
+	if d < 2*time.Hour {
+		return fmt.Sprintf("%.0f minutes", math.Round(d.Minutes()))
+	}
+	if d < 48*time.Hour {
+		return fmt.Sprintf("%.0f hours", math.Round(d.Hours()))
+	}
+	return fmt.Sprintf("%.0f days", math.Round(d.Hours()/24))
+}
@@ -0,0 +1,184 @@
+package weather
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	_ "github.com/mattn/go-sqlite3"
+
+	"github.com/jchorl/gowaker/plugin"
+	"github.com/jchorl/gowaker/plugin/weather/weathertest"
+)
+
+func newTestWeather(t *testing.T, day time.Time) (*Weather, *weathertest.Server, *sql.DB) {
+	t.Helper()
+
+	db, err := sql.Open("sqlite3", ":memory:")
+	if err != nil {
+		t.Fatalf("opening db: %s", err)
+	}
+	// every connection to :memory: is its own db
+	db.SetMaxOpenConns(1)
+	t.Cleanup(func() { db.Close() })
+
+	_, err = db.Exec(`create table weather_cache (
+		key text not null primary key,
+		fetched_at int not null,
+		forecast text not null
+	)`)
+	if err != nil {
+		t.Fatalf("creating table: %s", err)
+	}
+
+	server := weathertest.NewServer(day)
+	t.Cleanup(server.Close)
+
+	o := NewOpenMeteo()
+	o.BaseURL = server.URL
+
+	w, err := New(db, Settings{Unit: Celsius, Lat: 43.65, Lon: -79.38}, o)
+	if err != nil {
+		t.Fatalf("creating weather plugin: %s", err)
+	}
+
+	return w, server, db
+}
+
+func loadLocations(t *testing.T, names ...string) []*time.Location {
+	t.Helper()
+
+	var locs []*time.Location
+	for _, name := range names {
+		loc, err := time.LoadLocation(name)
+		if err != nil {
+			t.Fatalf("loading location: %s", err)
+		}
+		locs = append(locs, loc)
+	}
+	return locs
+}
+
+func TestRefreshCachesPerZone(t *testing.T) {
+	locs := loadLocations(t, "America/Toronto", "Asia/Tokyo", "Europe/London")
+	toronto, tokyo, london := locs[0], locs[1], locs[2]
+	day := time.Date(2021, time.March, 3, 0, 0, 0, 0, toronto)
+	w, server, db := newTestWeather(t, day)
+
+	err := w.Refresh(context.Background(), toronto, tokyo)
+	if err != nil {
+		t.Fatalf("refreshing: %s", err)
+	}
+
+	var rows int
+	err = db.QueryRow("select count(*) from weather_cache").Scan(&rows)
+	if err != nil {
+		t.Fatalf("counting cached forecasts: %s", err)
+	}
+	if rows != 2 || len(server.Requests()) != 2 {
+		t.Fatalf("got %d cached forecasts from %d requests, want one for each zone", rows, len(server.Requests()))
+	}
+
+	// the refreshed zones are served from the cache
+	for _, loc := range []*time.Location{toronto, tokyo} {
+		_, err = w.Generate(context.Background(), plugin.Run{Scheduled: day.Add(7 * time.Hour), Location: loc})
+		if err != nil {
+			t.Fatalf("generating in %s: %s", loc, err)
+		}
+	}
+	if n := len(server.Requests()); n != 2 {
+		t.Errorf("got %d requests, want the refreshed zones to use the cache", n)
+	}
+
+	// a zone that wasn't refreshed is fetched
+	_, err = w.Generate(context.Background(), plugin.Run{Scheduled: day.Add(7 * time.Hour), Location: london})
+	if err != nil {
+		t.Fatalf("generating in %s: %s", london, err)
+	}
+	if n := len(server.Requests()); n != 3 {
+		t.Errorf("got %d requests, want a new zone fetched", n)
+	}
+}
+
+func TestRefreshDefaultsToLocal(t *testing.T) {
+	w, server, _ := newTestWeather(t, time.Now())
+
+	err := w.Refresh(context.Background())
+	if err != nil {
+		t.Fatalf("refreshing: %s", err)
+	}
+
+	if _, err := w.cached(w.settings, time.Local); err != nil {
+		t.Errorf("getting the local zone's forecast: %s", err)
+	}
+	if n := len(server.Requests()); n != 1 {
+		t.Errorf("got %d requests, want 1", n)
+	}
+}
+
+// backdate makes the cached forecast for loc d older
+func backdate(t *testing.T, w *Weather, db *sql.DB, loc *time.Location, d time.Duration) {
+	t.Helper()
+
+	cached, err := w.cached(w.settings, loc)
+	if err != nil {
+		t.Fatalf("getting cached forecast: %s", err)
+	}
+	cached.FetchedAt = cached.FetchedAt.Add(-d)
+
+	b, err := json.Marshal(cached)
+	if err != nil {
+		t.Fatalf("encoding forecast: %s", err)
+	}
+	_, err = db.Exec("update weather_cache set fetched_at = ?, forecast = ? where key = ?", cached.FetchedAt.Unix(), string(b), cacheKey(w.settings, loc))
+	if err != nil {
+		t.Fatalf("backdating forecast: %s", err)
+	}
+}
+
+func TestStaleForecast(t *testing.T) {
+	toronto := loadLocations(t, "America/Toronto")[0]
+	day := time.Date(2021, time.March, 3, 0, 0, 0, 0, toronto)
+	w, server, db := newTestWeather(t, day)
+	run := plugin.Run{Scheduled: day.Add(7 * time.Hour), Location: toronto}
+
+	err := w.Refresh(context.Background(), toronto)
+	if err != nil {
+		t.Fatalf("refreshing: %s", err)
+	}
+	backdate(t, w, db, toronto, 3*time.Hour)
+
+	// the provider is down
+	server.Close()
+
+	out, err := w.Generate(context.Background(), run)
+	if err != nil {
+		t.Fatalf("generating: %s", err)
+	}
+	if !strings.HasPrefix(out.Text, "I couldn't get a new forecast, so this one is from 3 hours ago.") || !strings.Contains(out.Text, "light rain") {
+		t.Errorf("got %q, want the cached forecast read with its age", out.Text)
+	}
+	if forecast := out.Data.(Forecast); !forecast.Stale {
+		t.Errorf("got forecast %+v, want it marked stale", forecast)
+	}
+
+	// without a cached forecast there is nothing to fall back on
+	_, err = w.Generate(context.Background(), plugin.Run{Scheduled: run.Scheduled, Location: loadLocations(t, "Asia/Tokyo")[0]})
+	if err == nil {
+		t.Error("generating without a provider or a cached forecast succeeded")
+	}
+}
+
+func TestRefreshFailure(t *testing.T) {
+	locs := loadLocations(t, "America/Toronto", "Asia/Tokyo")
+	w, server, _ := newTestWeather(t, time.Now())
+	server.Close()
+
+	err := w.Refresh(context.Background(), locs...)
+	if err == nil {
+		t.Error("refreshing with the provider down succeeded")
+	}
+}
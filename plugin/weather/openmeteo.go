@@ -0,0 +1,164 @@
+package weather
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+// OpenMeteo fetches forecasts from an Open-Meteo compatible api, which needs
+// no api key but only takes coordinates. It has no weather alerts.
+type OpenMeteo struct {
+	BaseURL string
+	Client  *http.Client
+}
+
+// NewOpenMeteo returns an Open-Meteo provider
+func NewOpenMeteo() *OpenMeteo {
+	return &OpenMeteo{
+		BaseURL: "https://api.open-meteo.com",
+		Client:  &http.Client{},
+	}
+}
+
+// Name returns the name of the provider
+func (o *OpenMeteo) Name() string {
+	return "open-meteo"
+}
+
+type openMeteoHourly struct {
+	Time                     []int64   `json:"time"`
+	Temperature2m            []float64 `json:"temperature_2m"`
+	ApparentTemperature      []float64 `json:"apparent_temperature"`
+	PrecipitationProbability []float64 `json:"precipitation_probability"`
+	WeatherCode              []int     `json:"weathercode"`
+	WindSpeed10m             []float64 `json:"windspeed_10m"`
+}
+
+type openMeteoDaily struct {
+	Time                        []int64   `json:"time"`
+	WeatherCode                 []int     `json:"weathercode"`
+	Temperature2mMax            []float64 `json:"temperature_2m_max"`
+	Temperature2mMin            []float64 `json:"temperature_2m_min"`
+	ApparentTemperatureMax      []float64 `json:"apparent_temperature_max"`
+	PrecipitationProbabilityMax []float64 `json:"precipitation_probability_max"`
+	WindSpeed10mMax             []float64 `json:"windspeed_10m_max"`
+}
+
+type openMeteoForecast struct {
+	Hourly openMeteoHourly `json:"hourly"`
+	Daily  openMeteoDaily  `json:"daily"`
+}
+
+// Forecast fetches today's forecast for a lat/lon
+func (o *OpenMeteo) Forecast(ctx context.Context, q Query) (Forecast, error) {
+	if q.Lat == 0 && q.Lon == 0 {
+		return Forecast{}, errors.New("open-meteo needs lat and lon, city ids are not supported")
+	}
+
+	params := url.Values{
+		"latitude":      {strconv.FormatFloat(q.Lat, 'f', -1, 64)},
+		"longitude":     {strconv.FormatFloat(q.Lon, 'f', -1, 64)},
+		"hourly":        {"temperature_2m,apparent_temperature,precipitation_probability,weathercode,windspeed_10m"},
+		"daily":         {"weathercode,temperature_2m_max,temperature_2m_min,apparent_temperature_max,precipitation_probability_max,windspeed_10m_max"},
+		"timezone":      {timezone(q.Location)},
+		"timeformat":    {"unixtime"},
+		"forecast_days": {"2"},
+	}
+	if q.Unit == Fahrenheit {
+		params.Set("temperature_unit", "fahrenheit")
+		params.Set("windspeed_unit", "mph")
+	}
+
+	resp := openMeteoForecast{}
+	err := getJSON(ctx, o.Client, o.BaseURL+"/v1/forecast?"+params.Encode(), &resp)
+	if err != nil {
+		return Forecast{}, fmt.Errorf("fetching forecast: %w", err)
+	}
+
+	d := resp.Daily
+	if len(d.Time) == 0 || len(d.WeatherCode) == 0 || len(d.Temperature2mMax) == 0 || len(d.Temperature2mMin) == 0 ||
+		len(d.ApparentTemperatureMax) == 0 || len(d.PrecipitationProbabilityMax) == 0 || len(d.WindSpeed10mMax) == 0 {
+		return Forecast{}, errors.New("forecast has no days")
+	}
+
+	forecast := Forecast{
+		Unit:                q.Unit,
+		Description:         wmoDescription(d.WeatherCode[0]),
+		High:                d.Temperature2mMax[0],
+		Low:                 d.Temperature2mMin[0],
+		FeelsLike:           d.ApparentTemperatureMax[0],
+		PrecipitationChance: int(d.PrecipitationProbabilityMax[0]),
+		WindSpeed:           d.WindSpeed10mMax[0],
+	}
+
+	h := resp.Hourly
+	for i, t := range h.Time {
+		if i >= len(h.Temperature2m) || i >= len(h.ApparentTemperature) || i >= len(h.PrecipitationProbability) ||
+			i >= len(h.WeatherCode) || i >= len(h.WindSpeed10m) {
+			break
+		}
+
+		forecast.Hourly = append(forecast.Hourly, Hour{
+			Time:                time.Unix(t, 0).In(q.Location),
+			Temp:                h.Temperature2m[i],
+			FeelsLike:           h.ApparentTemperature[i],
+			Description:         wmoDescription(h.WeatherCode[i]),
+			PrecipitationChance: int(h.PrecipitationProbability[i]),
+			WindSpeed:           h.WindSpeed10m[i],
+		})
+	}
+
+	return forecast, nil
+}
+
+// wmoDescriptions describes WMO weather interpretation codes
+var wmoDescriptions = map[int]string{
+	0:  "clear sky",
+	1:  "mainly clear",
+	2:  "partly cloudy",
+	3:  "overcast",
+	45: "fog",
+	48: "freezing fog",
+	51: "light drizzle",
+	53: "drizzle",
+	55: "heavy drizzle",
+	56: "light freezing drizzle",
+	57: "freezing drizzle",
+	61: "light rain",
+	63: "rain",
+	65: "heavy rain",
+	66: "light freezing rain",
+	67: "freezing rain",
+	71: "light snow",
+	73: "snow",
+	75: "heavy snow",
+	77: "snow grains",
+	80: "light showers",
+	81: "showers",
+	82: "heavy showers",
+	85: "light snow showers",
+	86: "heavy snow showers",
+	95: "thunderstorms",
+	96: "thunderstorms with hail",
+	99: "thunderstorms with heavy hail",
+}
+
+func wmoDescription(code int) string {
+	if description, ok := wmoDescriptions[code]; ok {
+		return description
+	}
+	return "unknown weather"
+}
+
+// timezone returns the IANA name of loc, or "auto" to use the zone of the coordinates
+func timezone(loc *time.Location) string {
+	if loc == time.Local || loc.String() == "Local" {
+		return "auto"
+	}
+	return loc.String()
+}
@@ -0,0 +1,102 @@
+package weather
+
+import (
+	"context"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jchorl/gowaker/plugin/weather/weathertest"
+)
+
+func TestOpenMeteoForecast(t *testing.T) {
+	loc, err := time.LoadLocation("America/Toronto")
+	if err != nil {
+		t.Fatalf("loading location: %s", err)
+	}
+	day := time.Date(2021, time.March, 3, 0, 0, 0, 0, loc)
+
+	server := weathertest.NewServer(day)
+	defer server.Close()
+
+	o := NewOpenMeteo()
+	o.BaseURL = server.URL
+
+	forecast, err := o.Forecast(context.Background(), Query{Unit: Celsius, Lat: 43.65, Lon: -79.38, Location: loc})
+	if err != nil {
+		t.Fatalf("getting forecast: %s", err)
+	}
+
+	if forecast.Description != "light rain" || forecast.High != 6 || forecast.Low != -2 || forecast.FeelsLike != 3 {
+		t.Errorf("got forecast %+v", forecast)
+	}
+	if forecast.PrecipitationChance != 60 || forecast.WindSpeed != 28.8 {
+		t.Errorf("got precipitation chance %d and wind speed %f", forecast.PrecipitationChance, forecast.WindSpeed)
+	}
+	if len(forecast.Alerts) != 0 {
+		t.Errorf("got alerts %+v, open-meteo has none", forecast.Alerts)
+	}
+
+	if len(forecast.Hourly) != 3 {
+		t.Fatalf("got %d hours, want 3", len(forecast.Hourly))
+	}
+	last := forecast.Hourly[2]
+	if !last.Time.Equal(day.Add(9*time.Hour)) || last.Time.Location() != loc {
+		t.Errorf("last hour is at %s, want 9am in %s", last.Time, loc)
+	}
+	if last.Temp != 4 || last.FeelsLike != 1 || last.PrecipitationChance != 10 || last.Description != "overcast" {
+		t.Errorf("got last hour %+v", last)
+	}
+
+	requests := server.Requests()
+	if len(requests) != 1 {
+		t.Fatalf("got requests %v, want one", requests)
+	}
+	u, err := url.Parse(requests[0])
+	if err != nil {
+		t.Fatalf("parsing request: %s", err)
+	}
+	q := u.Query()
+	if q.Get("timezone") != "America/Toronto" || q.Get("temperature_unit") != "" {
+		t.Errorf("got query %v, want celsius in America/Toronto", q)
+	}
+}
+
+func TestOpenMeteoForecastFahrenheit(t *testing.T) {
+	server := weathertest.NewServer(time.Now())
+	defer server.Close()
+
+	o := NewOpenMeteo()
+	o.BaseURL = server.URL
+
+	_, err := o.Forecast(context.Background(), Query{Unit: Fahrenheit, Lat: 43.65, Lon: -79.38, Location: time.Local})
+	if err != nil {
+		t.Fatalf("getting forecast: %s", err)
+	}
+
+	u, err := url.Parse(server.Requests()[0])
+	if err != nil {
+		t.Fatalf("parsing request: %s", err)
+	}
+	q := u.Query()
+	if q.Get("temperature_unit") != "fahrenheit" || q.Get("windspeed_unit") != "mph" || q.Get("timezone") != "auto" {
+		t.Errorf("got query %v, want fahrenheit and mph in the zone of the coordinates", q)
+	}
+}
+
+func TestOpenMeteoForecastCity(t *testing.T) {
+	server := weathertest.NewServer(time.Now())
+	defer server.Close()
+
+	o := NewOpenMeteo()
+	o.BaseURL = server.URL
+
+	_, err := o.Forecast(context.Background(), Query{CityID: 6167865, Location: time.UTC})
+	if err == nil || !strings.Contains(err.Error(), "city ids are not supported") {
+		t.Errorf("got error %v, want city ids to be unsupported", err)
+	}
+	if requests := server.Requests(); len(requests) != 0 {
+		t.Errorf("got requests %v, want none", requests)
+	}
+}
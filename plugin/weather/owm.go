@@ -0,0 +1,168 @@
+package weather
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+// OWM fetches forecasts from the OpenWeatherMap One Call API 3.0, which needs
+// a key subscribed to "One Call by Call". Version 2.5 has been shut down.
+type OWM struct {
+	APIKey  string
+	BaseURL string
+	Client  *http.Client
+}
+
+// NewOWM returns an OpenWeatherMap provider
+func NewOWM(apiKey string) *OWM {
+	return &OWM{
+		APIKey:  apiKey,
+		BaseURL: "https://api.openweathermap.org",
+		Client:  &http.Client{},
+	}
+}
+
+// Name returns the name of the provider
+func (o *OWM) Name() string {
+	return "owm"
+}
+
+type owmWeather struct {
+	Description string `json:"description"`
+}
+
+type owmHour struct {
+	Dt        int64        `json:"dt"`
+	Temp      float64      `json:"temp"`
+	FeelsLike float64      `json:"feels_like"`
+	WindSpeed float64      `json:"wind_speed"`
+	Pop       float64      `json:"pop"`
+	Weather   []owmWeather `json:"weather"`
+}
+
+type owmDay struct {
+	Dt   int64 `json:"dt"`
+	Temp struct {
+		Min float64 `json:"min"`
+		Max float64 `json:"max"`
+	} `json:"temp"`
+	FeelsLike struct {
+		Day float64 `json:"day"`
+	} `json:"feels_like"`
+	WindSpeed float64      `json:"wind_speed"`
+	Pop       float64      `json:"pop"`
+	Weather   []owmWeather `json:"weather"`
+}
+
+type owmAlert struct {
+	SenderName  string `json:"sender_name"`
+	Event       string `json:"event"`
+	Start       int64  `json:"start"`
+	End         int64  `json:"end"`
+	Description string `json:"description"`
+}
+
+type owmOneCall struct {
+	Hourly []owmHour  `json:"hourly"`
+	Daily  []owmDay   `json:"daily"`
+	Alerts []owmAlert `json:"alerts"`
+}
+
+type owmCurrent struct {
+	Coord struct {
+		Lat float64 `json:"lat"`
+		Lon float64 `json:"lon"`
+	} `json:"coord"`
+}
+
+// Forecast fetches today's forecast, looking up the coordinates of the city
+// if only a city is given
+func (o *OWM) Forecast(ctx context.Context, q Query) (Forecast, error) {
+	lat, lon := q.Lat, q.Lon
+	if lat == 0 && lon == 0 {
+		current := owmCurrent{}
+		err := o.getJSON(ctx, "/data/2.5/weather", url.Values{"id": {strconv.Itoa(q.CityID)}}, &current)
+		if err != nil {
+			return Forecast{}, fmt.Errorf("looking up city %d: %w", q.CityID, err)
+		}
+		lat, lon = current.Coord.Lat, current.Coord.Lon
+	}
+
+	units := "metric"
+	if q.Unit == Fahrenheit {
+		units = "imperial"
+	}
+
+	resp := owmOneCall{}
+	err := o.getJSON(ctx, "/data/3.0/onecall", url.Values{
+		"lat":     {strconv.FormatFloat(lat, 'f', -1, 64)},
+		"lon":     {strconv.FormatFloat(lon, 'f', -1, 64)},
+		"units":   {units},
+		"exclude": {"current,minutely"},
+	}, &resp)
+	if err != nil {
+		return Forecast{}, fmt.Errorf("fetching forecast: %w", err)
+	}
+
+	if len(resp.Daily) == 0 {
+		return Forecast{}, fmt.Errorf("forecast has no days")
+	}
+	today := resp.Daily[0]
+
+	forecast := Forecast{
+		Unit:                q.Unit,
+		Description:         description(today.Weather),
+		High:                today.Temp.Max,
+		Low:                 today.Temp.Min,
+		FeelsLike:           today.FeelsLike.Day,
+		PrecipitationChance: int(today.Pop * 100),
+		WindSpeed:           owmWindSpeed(today.WindSpeed, q.Unit),
+	}
+
+	for _, h := range resp.Hourly {
+		forecast.Hourly = append(forecast.Hourly, Hour{
+			Time:                time.Unix(h.Dt, 0).In(q.Location),
+			Temp:                h.Temp,
+			FeelsLike:           h.FeelsLike,
+			Description:         description(h.Weather),
+			PrecipitationChance: int(h.Pop * 100),
+			WindSpeed:           owmWindSpeed(h.WindSpeed, q.Unit),
+		})
+	}
+
+	for _, a := range resp.Alerts {
+		forecast.Alerts = append(forecast.Alerts, Alert{
+			Event:       a.Event,
+			Sender:      a.SenderName,
+			Start:       time.Unix(a.Start, 0).In(q.Location),
+			End:         time.Unix(a.End, 0).In(q.Location),
+			Description: a.Description,
+		})
+	}
+
+	return forecast, nil
+}
+
+func (o *OWM) getJSON(ctx context.Context, path string, params url.Values, v interface{}) error {
+	params.Set("appid", o.APIKey)
+	return getJSON(ctx, o.Client, o.BaseURL+path+"?"+params.Encode(), v)
+}
+
+// owmWindSpeed converts to km/h, since metric wind speeds are in m/s. Imperial are already mph.
+func owmWindSpeed(speed float64, unit TempUnit) float64 {
+	if unit == Fahrenheit {
+		return speed
+	}
+	return speed * 3.6
+}
+
+func description(weather []owmWeather) string {
+	if len(weather) == 0 {
+		return ""
+	}
+	return weather[0].Description
+}
@@ -0,0 +1,101 @@
+package weather
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jchorl/gowaker/plugin/weather/weathertest"
+)
+
+func TestOWMForecast(t *testing.T) {
+	loc, err := time.LoadLocation("America/Toronto")
+	if err != nil {
+		t.Fatalf("loading location: %s", err)
+	}
+	day := time.Date(2021, time.March, 3, 0, 0, 0, 0, loc)
+
+	server := weathertest.NewServer(day)
+	defer server.Close()
+
+	o := NewOWM("key")
+	o.BaseURL = server.URL
+
+	forecast, err := o.Forecast(context.Background(), Query{Unit: Celsius, Lat: 43.65, Lon: -79.38, Location: loc})
+	if err != nil {
+		t.Fatalf("getting forecast: %s", err)
+	}
+
+	if forecast.Description != "light rain" || forecast.High != 6 || forecast.Low != -2 || forecast.FeelsLike != 3 {
+		t.Errorf("got forecast %+v", forecast)
+	}
+	if forecast.PrecipitationChance != 60 {
+		t.Errorf("got precipitation chance %d, want 60", forecast.PrecipitationChance)
+	}
+	// metric wind speeds come in m/s
+	if forecast.WindSpeed != 8*3.6 {
+		t.Errorf("got wind speed %f, want %f", forecast.WindSpeed, 8*3.6)
+	}
+
+	if len(forecast.Hourly) != 3 {
+		t.Fatalf("got %d hours, want 3", len(forecast.Hourly))
+	}
+	first := forecast.Hourly[0]
+	if !first.Time.Equal(day.Add(7*time.Hour)) || first.Time.Location() != loc {
+		t.Errorf("first hour is at %s, want 7am in %s", first.Time, loc)
+	}
+	if first.Temp != 1 || first.FeelsLike != -3 || first.PrecipitationChance != 60 || first.Description != "light rain" {
+		t.Errorf("got first hour %+v", first)
+	}
+
+	if len(forecast.Alerts) != 1 || forecast.Alerts[0].Event != "Freezing rain warning" || forecast.Alerts[0].Sender != "Environment Canada" {
+		t.Errorf("got alerts %+v", forecast.Alerts)
+	}
+
+	requests := server.Requests()
+	if len(requests) != 1 || !strings.Contains(requests[0], "units=metric") {
+		t.Errorf("got requests %v, want one metric forecast", requests)
+	}
+}
+
+func TestOWMForecastCity(t *testing.T) {
+	server := weathertest.NewServer(time.Now())
+	defer server.Close()
+
+	o := NewOWM("key")
+	o.BaseURL = server.URL
+
+	forecast, err := o.Forecast(context.Background(), Query{Unit: Fahrenheit, CityID: 6167865, Location: time.UTC})
+	if err != nil {
+		t.Fatalf("getting forecast: %s", err)
+	}
+	// imperial wind speeds are already mph
+	if forecast.WindSpeed != 8 {
+		t.Errorf("got wind speed %f, want 8", forecast.WindSpeed)
+	}
+
+	requests := server.Requests()
+	if len(requests) != 2 {
+		t.Fatalf("got requests %v, want a city lookup then a forecast", requests)
+	}
+	if !strings.Contains(requests[0], "id=6167865") {
+		t.Errorf("city lookup was %s", requests[0])
+	}
+	if !strings.Contains(requests[1], "lat=43.65") || !strings.Contains(requests[1], "lon=-79.38") || !strings.Contains(requests[1], "units=imperial") {
+		t.Errorf("forecast request was %s, want the city's coordinates in imperial units", requests[1])
+	}
+}
+
+func TestOWMForecastUnauthorized(t *testing.T) {
+	server := weathertest.NewServer(time.Now())
+	defer server.Close()
+
+	o := NewOWM("")
+	o.BaseURL = server.URL
+
+	_, err := o.Forecast(context.Background(), Query{Lat: 43.65, Lon: -79.38, Location: time.UTC})
+	if err == nil || !strings.Contains(err.Error(), "401") {
+		t.Errorf("got error %v, want unauthorized", err)
+	}
+}
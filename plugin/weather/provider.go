@@ -0,0 +1,55 @@
+package weather
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// Provider fetches forecasts from a weather service
+type Provider interface {
+	// Name identifies the provider in the weather plugin's settings
+	Name() string
+
+	// Forecast returns today's forecast, including hourly forecasts and alerts if the service has them
+	Forecast(ctx context.Context, q Query) (Forecast, error)
+}
+
+// Query describes the forecast to fetch
+type Query struct {
+	Unit TempUnit
+
+	// Lat and Lon take precedence over CityID, which not every provider supports
+	CityID int
+	Lat    float64
+	Lon    float64
+
+	// Location is the time zone that "today" is in
+	Location *time.Location
+}
+
+func getJSON(ctx context.Context, client *http.Client, url string, v interface{}) error {
+	req, err := http.NewRequest(http.MethodGet, url, nil)
+	if err != nil {
+		return fmt.Errorf("creating request: %w", err)
+	}
+
+	resp, err := client.Do(req.WithContext(ctx))
+	if err != nil {
+		return fmt.Errorf("requesting %s: %w", req.URL.Path, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("requesting %s: unexpected status %s", req.URL.Path, resp.Status)
+	}
+
+	err = json.NewDecoder(resp.Body).Decode(v)
+	if err != nil {
+		return fmt.Errorf("decoding %s response: %w", req.URL.Path, err)
+	}
+
+	return nil
+}
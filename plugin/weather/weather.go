@@ -1,10 +1,17 @@
 package weather
 
 import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"math"
+	"sync"
+	"time"
 
-	owm "github.com/briandowns/openweathermap"
+	"github.com/jchorl/gowaker/plugin"
+	"github.com/jchorl/gowaker/ssml"
 )
 
 type TempUnit string
@@ -18,29 +25,301 @@ const (
 	Fahrenheit TempUnit = "F"
 )
 
+// windyKPH is the wind speed at which wind is worth mentioning
+const windyKPH = 25
+
+// Weather is a plugin that reads out the forecast
 type Weather struct {
-	APIKey   string
-	TempUnit TempUnit
-	OWMID    int
+	providers map[string]Provider
+
+	// db caches the last good forecast, so a briefing can still be given when the provider is down
+	db *sql.DB
+
+	mu       sync.RWMutex
+	defaults Settings
+	settings Settings
+}
+
+// Settings control what the weather plugin reads out. They can be changed at
+// runtime through the plugin's settings.
+type Settings struct {
+	// Provider is the name of the provider to fetch forecasts from
+	Provider string `json:"provider"`
+
+	Unit TempUnit `json:"unit"`
+
+	// the location is a lat/lon or, for owm only, a city ID
+	CityID int     `json:"city_id,omitempty"`
+	Lat    float64 `json:"lat,omitempty"`
+	Lon    float64 `json:"lon,omitempty"`
+
+	// Commute is the window to read an hourly breakdown for
+	Commute *Window `json:"commute,omitempty"`
+
+	// UmbrellaChance is the chance of precipitation, in percent, at which to suggest an umbrella
+	UmbrellaChance int `json:"umbrella_chance"`
+}
+
+// Window is a time of day range, e.g. {"start":"07:30","end":"09:00"}
+type Window struct {
+	Start string `json:"start"`
+	End   string `json:"end"`
+}
+
+// Forecast is the structured data behind the weather plugin's output
+type Forecast struct {
+	Unit                TempUnit `json:"unit"`
+	Description         string   `json:"description"`
+	High                float64  `json:"high"`
+	Low                 float64  `json:"low"`
+	FeelsLike           float64  `json:"feels_like"`
+	PrecipitationChance int      `json:"precipitation_chance"`
+	Umbrella            bool     `json:"umbrella"`
+
+	// WindSpeed is in km/h for celsius and mph for fahrenheit
+	WindSpeed float64 `json:"wind_speed"`
+
+	Hourly []Hour  `json:"hourly"`
+	Alerts []Alert `json:"alerts"`
+
+	FetchedAt time.Time `json:"fetched_at"`
+
+	// Stale is set when a new forecast couldn't be fetched so an old one was used
+	Stale bool `json:"stale"`
+}
+
+// Hour is the forecast for a single hour
+type Hour struct {
+	Time                time.Time `json:"time"`
+	Temp                float64   `json:"temp"`
+	FeelsLike           float64   `json:"feels_like"`
+	Description         string    `json:"description"`
+	PrecipitationChance int       `json:"precipitation_chance"`
+	WindSpeed           float64   `json:"wind_speed"`
+}
+
+// Alert is a severe weather alert
+type Alert struct {
+	Event       string    `json:"event"`
+	Sender      string    `json:"sender"`
+	Start       time.Time `json:"start"`
+	End         time.Time `json:"end"`
+	Description string    `json:"description"`
 }
 
-func (w Weather) Text() (string, error) {
-	o, err := owm.NewForecast("5", w.TempUnit.String(), "EN", w.APIKey)
+// New creates a weather plugin that can fetch from any of the providers. The
+// defaults apply until settings are configured. Forecasts are cached in db,
+// unless it is nil.
+func New(db *sql.DB, defaults Settings, providers ...Provider) (*Weather, error) {
+	w := &Weather{
+		providers: map[string]Provider{},
+		db:        db,
+	}
+	for _, p := range providers {
+		w.providers[p.Name()] = p
+	}
+
+	if defaults.Provider == "" && len(providers) > 0 {
+		defaults.Provider = providers[0].Name()
+	}
+	if defaults.UmbrellaChance == 0 {
+		defaults.UmbrellaChance = 40
+	}
+
+	err := w.validate(defaults)
 	if err != nil {
-		return "", fmt.Errorf("creating owm client: %w", err)
+		return nil, fmt.Errorf("validating default settings: %w", err)
 	}
+	w.defaults = defaults
+	w.settings = defaults
 
-	err = o.DailyByID(w.OWMID, 1)
+	return w, nil
+}
+
+func (w *Weather) validate(s Settings) error {
+	if _, ok := w.providers[s.Provider]; !ok {
+		return fmt.Errorf("unknown provider %q", s.Provider)
+	}
+	if s.Unit != Celsius && s.Unit != Fahrenheit {
+		return fmt.Errorf("unit must be %s or %s, got %q", Celsius, Fahrenheit, s.Unit)
+	}
+	if s.CityID == 0 && s.Lat == 0 && s.Lon == 0 {
+		return errors.New("either city_id or lat and lon are required")
+	}
+	if s.Commute != nil {
+		_, _, err := s.Commute.parse()
+		if err != nil {
+			return fmt.Errorf("parsing commute: %w", err)
+		}
+	}
+	return nil
+}
+
+func (win Window) parse() (time.Duration, time.Duration, error) {
+	start, err := time.Parse("15:04", win.Start)
+	if err != nil {
+		return 0, 0, fmt.Errorf("parsing start: %w", err)
+	}
+
+	end, err := time.Parse("15:04", win.End)
 	if err != nil {
-		return "", fmt.Errorf("w.DailyByID: %w", err)
+		return 0, 0, fmt.Errorf("parsing end: %w", err)
+	}
+
+	if !end.After(start) {
+		return 0, 0, errors.New("end must be after start")
 	}
 
-	forecast := o.ForecastWeatherJson.(*owm.Forecast5WeatherData).List[0]
+	// offsets from midnight
+	zero := time.Date(0, 1, 1, 0, 0, 0, 0, time.UTC)
+	return start.Sub(zero), end.Sub(zero), nil
+}
+
+// Name returns the name of the plugin
+func (w *Weather) Name() string {
+	return "weather"
+}
+
+// Configure applies settings on top of the defaults
+func (w *Weather) Configure(raw json.RawMessage) error {
+	settings := w.defaults
+	if len(raw) > 0 {
+		err := json.Unmarshal(raw, &settings)
+		if err != nil {
+			return fmt.Errorf("decoding settings: %w", err)
+		}
+	}
+
+	err := w.validate(settings)
+	if err != nil {
+		return err
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.settings = settings
+
+	return nil
+}
+
+// Generate returns today's forecast
+func (w *Weather) Generate(ctx context.Context, run plugin.Run) (plugin.Output, error) {
+	w.mu.RLock()
+	settings := w.settings
+	w.mu.RUnlock()
+
+	forecast, err := w.forecast(ctx, settings, run.Location)
+	if err != nil {
+		return plugin.Output{}, err
+	}
+	forecast.Umbrella = forecast.PrecipitationChance >= settings.UmbrellaChance
+
+	if settings.Commute != nil {
+		forecast.Hourly = commuteHours(forecast.Hourly, *settings.Commute, run)
+	} else {
+		forecast.Hourly = nil
+	}
+	forecast.Alerts = currentAlerts(forecast.Alerts, run.Scheduled)
+
+	s := say(forecast)
+	if forecast.Stale {
+		s = fmt.Sprintf("I couldn't get a new forecast, so this one is from %s ago. ", age(time.Since(forecast.FetchedAt))) + s
+	}
+
+	return plugin.Output{
+		Text: ssml.ToText(s),
+		SSML: s,
+		Data: forecast,
+	}, nil
+}
+
+// commuteHours returns the hours that fall in the commute window on the day of the run
+func commuteHours(hours []Hour, win Window, run plugin.Run) []Hour {
+	// validated when configured
+	start, end, _ := win.parse()
+
+	year, month, day := run.Scheduled.In(run.Location).Date()
+	midnight := time.Date(year, month, day, 0, 0, 0, 0, run.Location)
+
+	var commute []Hour
+	for _, h := range hours {
+		// include the hour that the commute starts in
+		if h.Time.After(midnight.Add(start).Add(-time.Hour)) && !h.Time.After(midnight.Add(end)) {
+			commute = append(commute, h)
+		}
+	}
+
+	return commute
+}
+
+// currentAlerts drops alerts that are over by the time the alarm goes off
+func currentAlerts(alerts []Alert, now time.Time) []Alert {
+	var current []Alert
+	for _, a := range alerts {
+		if a.End.After(now) {
+			current = append(current, a)
+		}
+	}
+
+	return current
+}
+
+func say(f Forecast) string {
+	s := fmt.Sprintf(
+		"Today's forecast is %s, with a high of %s and a low of %s. It will feel like %s.",
+		ssml.Escape(f.Description),
+		ssml.Emphasis("moderate", degrees(f.High)),
+		ssml.Emphasis("moderate", degrees(f.Low)),
+		degrees(f.FeelsLike),
+	)
+
+	if f.Umbrella {
+		s += fmt.Sprintf(" There is a %d percent chance of precipitation, so %s.", f.PrecipitationChance, ssml.Emphasis("moderate", "bring an umbrella"))
+	} else if f.PrecipitationChance > 0 {
+		s += fmt.Sprintf(" There is a %d percent chance of precipitation.", f.PrecipitationChance)
+	}
+
+	windUnit, windy := "kilometres per hour", f.WindSpeed >= windyKPH
+	if f.Unit == Fahrenheit {
+		windUnit, windy = "miles per hour", f.WindSpeed >= windyKPH/1.609
+	}
+	if windy {
+		s += fmt.Sprintf(" It will be windy, with winds up to %.0f %s.", math.Round(f.WindSpeed), windUnit)
+	}
+
+	if len(f.Hourly) > 0 {
+		s += ssml.Break(500*time.Millisecond) + " For your commute:"
+		for _, h := range f.Hourly {
+			s += fmt.Sprintf(
+				" At %s, %s and %s, feeling like %s",
+				ssml.Time(h.Time),
+				ssml.Escape(h.Description),
+				degrees(h.Temp),
+				degrees(h.FeelsLike),
+			)
+			if h.PrecipitationChance > 0 {
+				s += fmt.Sprintf(", with a %d percent chance of precipitation", h.PrecipitationChance)
+			}
+			s += "." + ssml.Break(300*time.Millisecond)
+		}
+	}
+
+	for _, a := range f.Alerts {
+		s += ssml.Break(500*time.Millisecond) + fmt.Sprintf(
+			" %s %s until %s on %s.",
+			ssml.Emphasis("strong", "Weather alert:"),
+			ssml.Escape(a.Event),
+			ssml.Time(a.End),
+			ssml.Date(a.End),
+		)
+		if a.Sender != "" {
+			s += fmt.Sprintf(" Issued by %s.", ssml.Escape(a.Sender))
+		}
+	}
+
+	return s
+}
 
-	return fmt.Sprintf(
-		"Today's forecast is %s with a high of %.0f degrees and a low of %.0f degrees.",
-		forecast.Weather[0].Description,
-		math.Round(forecast.Main.TempMax),
-		math.Round(forecast.Main.TempMin),
-	), nil
+func degrees(temp float64) string {
+	return fmt.Sprintf("%.0f degrees", math.Round(temp))
 }
@@ -0,0 +1,149 @@
+// Package weathertest fakes the weather services at the HTTP level, so the
+// weather providers can be exercised offline
+package weathertest
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"time"
+)
+
+// Server is a fake of both OpenWeatherMap and Open-Meteo. Point a provider's
+// BaseURL at URL to use it.
+type Server struct {
+	*httptest.Server
+
+	// Day is the day that forecasts are generated for
+	Day time.Time
+
+	mu       sync.Mutex
+	requests []string
+}
+
+// Requests returns the path and query of every request made so far
+func (s *Server) Requests() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return append([]string(nil), s.requests...)
+}
+
+// NewServer starts a fake that forecasts for the day of day, in day's location
+func NewServer(day time.Time) *Server {
+	year, month, date := day.Date()
+	s := &Server{Day: time.Date(year, month, date, 0, 0, 0, 0, day.Location())}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/data/2.5/weather", s.owmCurrent)
+	mux.HandleFunc("/data/3.0/onecall", s.owmOneCall)
+	mux.HandleFunc("/v1/forecast", s.openMeteoForecast)
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.mu.Lock()
+		s.requests = append(s.requests, r.URL.RequestURI())
+		s.mu.Unlock()
+		mux.ServeHTTP(w, r)
+	}))
+
+	return s
+}
+
+// hours are 7am, 8am and 9am, which covers a typical commute
+var hours = []struct {
+	offset      time.Duration
+	temp        float64
+	feelsLike   float64
+	pop         float64
+	code        int
+	description string
+}{
+	{7 * time.Hour, 1, -3, 0.6, 61, "light rain"},
+	{8 * time.Hour, 2, -1, 0.4, 61, "light rain"},
+	{9 * time.Hour, 4, 1, 0.1, 3, "overcast clouds"},
+}
+
+func (s *Server) owmCurrent(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Query().Get("appid") == "" {
+		http.Error(w, `{"cod":401}`, http.StatusUnauthorized)
+		return
+	}
+
+	writeJSON(w, map[string]interface{}{
+		"coord": map[string]float64{"lat": 43.65, "lon": -79.38},
+	})
+}
+
+func (s *Server) owmOneCall(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Query().Get("appid") == "" {
+		http.Error(w, `{"cod":401}`, http.StatusUnauthorized)
+		return
+	}
+
+	var hourly []map[string]interface{}
+	for _, h := range hours {
+		hourly = append(hourly, map[string]interface{}{
+			"dt":         s.Day.Add(h.offset).Unix(),
+			"temp":       h.temp,
+			"feels_like": h.feelsLike,
+			"wind_speed": 5.5,
+			"pop":        h.pop,
+			"weather":    []map[string]string{{"description": h.description}},
+		})
+	}
+
+	writeJSON(w, map[string]interface{}{
+		"hourly": hourly,
+		"daily": []map[string]interface{}{{
+			"dt":         s.Day.Add(12 * time.Hour).Unix(),
+			"temp":       map[string]float64{"min": -2, "max": 6},
+			"feels_like": map[string]float64{"day": 3},
+			"wind_speed": 8,
+			"pop":        0.6,
+			"weather":    []map[string]string{{"description": "light rain"}},
+		}},
+		"alerts": []map[string]interface{}{{
+			"sender_name": "Environment Canada",
+			"event":       "Freezing rain warning",
+			"start":       s.Day.Unix(),
+			"end":         s.Day.Add(12 * time.Hour).Unix(),
+			"description": "Freezing rain is expected this morning.",
+		}},
+	})
+}
+
+func (s *Server) openMeteoForecast(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	if q.Get("latitude") == "" || q.Get("longitude") == "" {
+		http.Error(w, `{"error":true,"reason":"latitude and longitude are required"}`, http.StatusBadRequest)
+		return
+	}
+
+	hourly := map[string][]interface{}{}
+	for _, h := range hours {
+		hourly["time"] = append(hourly["time"], s.Day.Add(h.offset).Unix())
+		hourly["temperature_2m"] = append(hourly["temperature_2m"], h.temp)
+		hourly["apparent_temperature"] = append(hourly["apparent_temperature"], h.feelsLike)
+		hourly["precipitation_probability"] = append(hourly["precipitation_probability"], h.pop*100)
+		hourly["weathercode"] = append(hourly["weathercode"], h.code)
+		hourly["windspeed_10m"] = append(hourly["windspeed_10m"], 19.8)
+	}
+
+	writeJSON(w, map[string]interface{}{
+		"hourly": hourly,
+		"daily": map[string][]interface{}{
+			"time":                          {s.Day.Unix()},
+			"weathercode":                   {61},
+			"temperature_2m_max":            {6},
+			"temperature_2m_min":            {-2},
+			"apparent_temperature_max":      {3},
+			"precipitation_probability_max": {60},
+			"windspeed_10m_max":             {28.8},
+		},
+	})
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(v)
+}
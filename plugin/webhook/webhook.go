@@ -0,0 +1,366 @@
+// Package webhook speaks what an HTTP endpoint returns, for integrating
+// dashboards and internal services without code changes
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"mime"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/golang/glog"
+
+	"github.com/jchorl/gowaker/plugin"
+	"github.com/jchorl/gowaker/ssml"
+)
+
+const defaultTimeout = 10 * time.Second
+
+// maxBody caps how much of a response is read
+const maxBody = 64 << 10
+
+// Webhook is a plugin that requests URLs and speaks their responses
+type Webhook struct {
+	Client *http.Client
+
+	mu       sync.RWMutex
+	settings Settings
+}
+
+// Settings are the hooks to call, in order
+type Settings struct {
+	Hooks []Hook `json:"hooks"`
+}
+
+// Hook is a single endpoint
+type Hook struct {
+	// Name identifies the hook in logs and in the plugin's data
+	Name string `json:"name"`
+
+	URL string `json:"url"`
+
+	// Method is GET or POST. GET sends the alarm as query params, POST as a
+	// JSON body. Defaults to GET.
+	Method string `json:"method"`
+
+	// Headers often carry credentials, so their values are masked when the
+	// settings are read back
+	Headers map[string]string `json:"headers"`
+
+	// Timeout is a duration like "5s". Defaults to 10s.
+	Timeout string `json:"timeout"`
+
+	// Fallback is spoken when the request fails. Without it, the hook is
+	// skipped.
+	Fallback string `json:"fallback"`
+}
+
+// Alarm is the alarm context sent to hooks
+type Alarm struct {
+	ID        string   `json:"alarm_id"`
+	Label     string   `json:"label"`
+	Scheduled string   `json:"scheduled"`
+	Timezone  string   `json:"timezone"`
+	Weekday   string   `json:"weekday"`
+	Plugins   []string `json:"plugins"`
+	Preview   bool     `json:"preview"`
+}
+
+// Result is the outcome of a single hook
+type Result struct {
+	Name     string      `json:"name"`
+	Text     string      `json:"text"`
+	Data     interface{} `json:"data,omitempty"`
+	Fallback bool        `json:"fallback"`
+}
+
+// jsonResponse is what hooks return with a JSON content type
+type jsonResponse struct {
+	Text string      `json:"text"`
+	SSML string      `json:"ssml"`
+	Data interface{} `json:"data"`
+}
+
+// New creates a webhook plugin with no hooks
+func New() *Webhook {
+	return &Webhook{Client: &http.Client{}}
+}
+
+// Name returns the name of the plugin
+func (w *Webhook) Name() string {
+	return "webhook"
+}
+
+// Configure replaces the hooks
+func (w *Webhook) Configure(raw json.RawMessage) error {
+	settings := Settings{}
+	if len(raw) > 0 {
+		err := json.Unmarshal(raw, &settings)
+		if err != nil {
+			return fmt.Errorf("decoding settings: %w", err)
+		}
+	}
+
+	for i, h := range settings.Hooks {
+		u, err := url.Parse(h.URL)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+			return fmt.Errorf("hook %d needs an http(s) url", i)
+		}
+		switch strings.ToUpper(h.Method) {
+		case "", http.MethodGet, http.MethodPost:
+		default:
+			return fmt.Errorf("hook %d has unsupported method %s", i, h.Method)
+		}
+		if h.Timeout != "" {
+			d, err := time.ParseDuration(h.Timeout)
+			if err != nil {
+				return fmt.Errorf("parsing timeout of hook %d: %w", i, err)
+			}
+			if d <= 0 {
+				return fmt.Errorf("timeout of hook %d must be positive", i)
+			}
+		}
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.settings = settings
+
+	return nil
+}
+
+// MaskSecrets masks every header value
+func (w *Webhook) MaskSecrets(raw json.RawMessage) (json.RawMessage, error) {
+	settings := Settings{}
+	err := json.Unmarshal(raw, &settings)
+	if err != nil {
+		return nil, fmt.Errorf("decoding settings: %w", err)
+	}
+
+	for _, h := range settings.Hooks {
+		for k := range h.Headers {
+			h.Headers[k] = plugin.MaskedSecret
+		}
+	}
+
+	return json.Marshal(settings)
+}
+
+// RestoreSecrets puts back masked header values, matching hooks by name and
+// headers by key
+func (w *Webhook) RestoreSecrets(raw, current json.RawMessage) (json.RawMessage, error) {
+	settings := Settings{}
+	err := json.Unmarshal(raw, &settings)
+	if err != nil {
+		return nil, fmt.Errorf("decoding settings: %w", err)
+	}
+
+	saved := Settings{}
+	if len(current) > 0 {
+		err = json.Unmarshal(current, &saved)
+		if err != nil {
+			return nil, fmt.Errorf("decoding current settings: %w", err)
+		}
+	}
+
+	savedHeaders := map[string]map[string]string{}
+	for _, h := range saved.Hooks {
+		savedHeaders[h.name()] = h.Headers
+	}
+
+	masked := false
+	for _, h := range settings.Hooks {
+		for k, v := range h.Headers {
+			if v != plugin.MaskedSecret {
+				continue
+			}
+
+			masked = true
+			prev, ok := savedHeaders[h.name()][k]
+			if !ok {
+				return nil, fmt.Errorf("header %s of hook %s is masked, but no value is saved for it", k, h.name())
+			}
+			h.Headers[k] = prev
+		}
+	}
+
+	if !masked {
+		return raw, nil
+	}
+	return json.Marshal(settings)
+}
+
+func (w *Webhook) currentSettings() Settings {
+	w.mu.RLock()
+	defer w.mu.RUnlock()
+
+	return w.settings
+}
+
+// Generate calls the hooks one after another and speaks their responses. When
+// a hook doesn't answer, its fallback is spoken in its place; hooks without a
+// fallback are left out.
+func (w *Webhook) Generate(ctx context.Context, run plugin.Run) (plugin.Output, error) {
+	settings := w.currentSettings()
+	if len(settings.Hooks) == 0 {
+		return plugin.Output{}, nil
+	}
+
+	alarm := alarmContext(run)
+	outputs := make([]plugin.Result, 0, len(settings.Hooks))
+	fallbacks := map[int]bool{}
+	for i, h := range settings.Hooks {
+		out, err := w.call(ctx, h, alarm)
+		if err != nil {
+			log.Errorf("calling hook %s: %s", h.name(), err)
+			if h.Fallback != "" {
+				out, err = plugin.Output{Text: h.Fallback}, nil
+				fallbacks[i] = true
+			}
+		}
+		outputs = append(outputs, plugin.Result{Name: h.name(), Output: out, Err: err})
+	}
+
+	output, err := plugin.Join(outputs)
+	if err != nil {
+		return plugin.Output{}, fmt.Errorf("calling hooks: %w", err)
+	}
+
+	results := []Result{}
+	for i, o := range outputs {
+		if o.Err == nil {
+			results = append(results, Result{Name: o.Name, Text: ssml.ToText(o.Output.SSMLFragment()), Data: o.Output.Data, Fallback: fallbacks[i]})
+		}
+	}
+	output.Data = results
+
+	return output, nil
+}
+
+func (h Hook) name() string {
+	if h.Name != "" {
+		return h.Name
+	}
+	return h.URL
+}
+
+func (w *Webhook) call(ctx context.Context, h Hook, alarm Alarm) (plugin.Output, error) {
+	timeout := defaultTimeout
+	if h.Timeout != "" {
+		timeout, _ = time.ParseDuration(h.Timeout)
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	req, err := newRequest(h, alarm)
+	if err != nil {
+		return plugin.Output{}, err
+	}
+	for k, v := range h.Headers {
+		req.Header.Set(k, v)
+	}
+
+	resp, err := w.Client.Do(req.WithContext(ctx))
+	if err != nil {
+		return plugin.Output{}, fmt.Errorf("requesting %s: %w", req.URL.Host, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return plugin.Output{}, fmt.Errorf("requesting %s: unexpected status %s", req.URL.Host, resp.Status)
+	}
+
+	body, err := ioutil.ReadAll(io.LimitReader(resp.Body, maxBody))
+	if err != nil {
+		return plugin.Output{}, fmt.Errorf("reading response: %w", err)
+	}
+
+	mediaType, _, _ := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	if mediaType != "application/json" {
+		text := strings.TrimSpace(string(body))
+		if text == "" {
+			return plugin.Output{}, errors.New("empty response")
+		}
+		return plugin.Output{Text: text}, nil
+	}
+
+	out := jsonResponse{}
+	err = json.Unmarshal(body, &out)
+	if err != nil {
+		return plugin.Output{}, fmt.Errorf("decoding response: %w", err)
+	}
+	if out.Text == "" && out.SSML == "" {
+		return plugin.Output{}, errors.New("response has neither text nor ssml")
+	}
+
+	return plugin.Output{Text: out.Text, SSML: out.SSML, Data: out.Data}, nil
+}
+
+func newRequest(h Hook, alarm Alarm) (*http.Request, error) {
+	if strings.ToUpper(h.Method) == http.MethodPost {
+		body, err := json.Marshal(alarm)
+		if err != nil {
+			return nil, fmt.Errorf("encoding alarm: %w", err)
+		}
+
+		req, err := http.NewRequest(http.MethodPost, h.URL, bytes.NewReader(body))
+		if err != nil {
+			return nil, fmt.Errorf("creating request: %w", err)
+		}
+		req.Header.Set("Content-Type", "application/json")
+		return req, nil
+	}
+
+	req, err := http.NewRequest(http.MethodGet, h.URL, nil)
+	if err != nil {
+		return nil, fmt.Errorf("creating request: %w", err)
+	}
+
+	q := req.URL.Query()
+	q.Set("alarm_id", alarm.ID)
+	q.Set("label", alarm.Label)
+	q.Set("scheduled", alarm.Scheduled)
+	q.Set("timezone", alarm.Timezone)
+	q.Set("weekday", alarm.Weekday)
+	q.Set("plugins", strings.Join(alarm.Plugins, ","))
+	q.Set("preview", fmt.Sprint(alarm.Preview))
+	req.URL.RawQuery = q.Encode()
+
+	return req, nil
+}
+
+func alarmContext(run plugin.Run) Alarm {
+	loc := run.Location
+	if loc == nil {
+		loc = time.Local
+	}
+	scheduled := run.Scheduled
+	if scheduled.IsZero() {
+		scheduled = time.Now()
+	}
+	scheduled = scheduled.In(loc)
+
+	plugins := run.Plugins
+	if plugins == nil {
+		plugins = []string{}
+	}
+
+	return Alarm{
+		ID:        run.AlarmID,
+		Label:     run.Label,
+		Scheduled: scheduled.Format(time.RFC3339),
+		Timezone:  loc.String(),
+		Weekday:   strings.ToLower(scheduled.Weekday().String()),
+		Plugins:   plugins,
+		Preview:   run.Preview,
+	}
+}
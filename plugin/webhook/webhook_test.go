@@ -0,0 +1,265 @@
+package webhook
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/jchorl/gowaker/plugin"
+)
+
+// endpoint serves the kinds of responses hooks can get, and records the
+// requests made to it
+type endpoint struct {
+	*httptest.Server
+
+	mu       sync.Mutex
+	requests []*http.Request
+	bodies   []string
+}
+
+func newEndpoint(t *testing.T) *endpoint {
+	t.Helper()
+
+	e := &endpoint{}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/text", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, "  Three tickets are open.\n")
+	})
+	mux.HandleFunc("/json", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+		fmt.Fprint(w, `{"text": "Build is red", "ssml": "Build is <emphasis>red</emphasis>", "data": {"failing": 2}}`)
+	})
+	mux.HandleFunc("/error", func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "down for maintenance", http.StatusServiceUnavailable)
+	})
+	mux.HandleFunc("/empty", func(w http.ResponseWriter, r *http.Request) {})
+	e.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		e.mu.Lock()
+		e.requests = append(e.requests, r)
+		e.bodies = append(e.bodies, string(body))
+		e.mu.Unlock()
+		mux.ServeHTTP(w, r)
+	}))
+	t.Cleanup(e.Close)
+
+	return e
+}
+
+func newTestWebhook(t *testing.T, hooks ...Hook) *Webhook {
+	t.Helper()
+
+	raw, err := json.Marshal(Settings{Hooks: hooks})
+	if err != nil {
+		t.Fatalf("encoding settings: %s", err)
+	}
+
+	w := New()
+	err = w.Configure(raw)
+	if err != nil {
+		t.Fatalf("configuring: %s", err)
+	}
+
+	return w
+}
+
+func TestGenerate(t *testing.T) {
+	e := newEndpoint(t)
+	w := newTestWebhook(t,
+		Hook{Name: "tickets", URL: e.URL + "/text"},
+		Hook{Name: "ci", URL: e.URL + "/json"},
+		Hook{Name: "dashboard", URL: e.URL + "/error", Fallback: "The dashboard didn't answer."},
+		Hook{Name: "quiet", URL: e.URL + "/empty"},
+		Hook{Name: "broken", URL: e.URL + "/error"},
+	)
+
+	out, err := w.Generate(context.Background(), plugin.Run{})
+	if err != nil {
+		t.Fatalf("generating: %s", err)
+	}
+
+	for _, want := range []string{"Three tickets are open.", "Build is red", "The dashboard didn't answer."} {
+		if !strings.Contains(out.Text, want) {
+			t.Errorf("got text %q, want it to contain %q", out.Text, want)
+		}
+	}
+	if !strings.Contains(out.SSML, "Build is <emphasis>red</emphasis>") {
+		t.Errorf("got ssml %q, want the json response's ssml", out.SSML)
+	}
+
+	want := []Result{
+		{Name: "tickets", Text: "Three tickets are open."},
+		{Name: "ci", Text: "Build is red", Data: map[string]interface{}{"failing": float64(2)}},
+		{Name: "dashboard", Text: "The dashboard didn't answer.", Fallback: true},
+	}
+	if got := out.Data.([]Result); !reflect.DeepEqual(got, want) {
+		t.Errorf("got results %+v, want %+v", got, want)
+	}
+}
+
+func TestGenerateEveryHookFails(t *testing.T) {
+	e := newEndpoint(t)
+	w := newTestWebhook(t,
+		Hook{Name: "quiet", URL: e.URL + "/empty"},
+		Hook{Name: "broken", URL: e.URL + "/error"},
+	)
+
+	_, err := w.Generate(context.Background(), plugin.Run{})
+	if err == nil || !strings.Contains(err.Error(), "503") {
+		t.Errorf("got error %v, want the last hook's error", err)
+	}
+}
+
+func TestGenerateTimeout(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-r.Context().Done():
+		case <-time.After(5 * time.Second):
+		}
+	}))
+	t.Cleanup(server.Close)
+
+	w := newTestWebhook(t, Hook{Name: "slow", URL: server.URL, Timeout: "50ms", Fallback: "It's slow today."})
+	out, err := w.Generate(context.Background(), plugin.Run{})
+	if err != nil {
+		t.Fatalf("generating: %s", err)
+	}
+	if out.Text != "It's slow today." {
+		t.Errorf("got %q, want the fallback", out.Text)
+	}
+}
+
+func TestRequests(t *testing.T) {
+	e := newEndpoint(t)
+	headers := map[string]string{"Authorization": "Bearer secret"}
+	w := newTestWebhook(t,
+		Hook{URL: e.URL + "/text?team=platform", Headers: headers},
+		Hook{URL: e.URL + "/text", Method: "post", Headers: headers},
+	)
+
+	loc := time.FixedZone("EST", -5*60*60)
+	run := plugin.Run{AlarmID: "a1", Label: "work", Scheduled: time.Date(2021, 3, 1, 12, 0, 0, 0, time.UTC), Location: loc, Plugins: []string{"webhook"}, Preview: true}
+	_, err := w.Generate(context.Background(), run)
+	if err != nil {
+		t.Fatalf("generating: %s", err)
+	}
+
+	if len(e.requests) != 2 {
+		t.Fatalf("got %d requests, want 2", len(e.requests))
+	}
+	for _, r := range e.requests {
+		if r.Header.Get("Authorization") != "Bearer secret" {
+			t.Errorf("got authorization %q on a %s", r.Header.Get("Authorization"), r.Method)
+		}
+	}
+
+	get := e.requests[0]
+	wantQuery := url.Values{
+		"team":      {"platform"},
+		"alarm_id":  {"a1"},
+		"label":     {"work"},
+		"scheduled": {"2021-03-01T07:00:00-05:00"},
+		"timezone":  {"EST"},
+		"weekday":   {"monday"},
+		"plugins":   {"webhook"},
+		"preview":   {"true"},
+	}
+	if get.Method != http.MethodGet || !reflect.DeepEqual(get.URL.Query(), wantQuery) {
+		t.Errorf("got %s with query %v, want a GET with %v", get.Method, get.URL.Query(), wantQuery)
+	}
+
+	post := e.requests[1]
+	alarm := Alarm{}
+	err = json.Unmarshal([]byte(e.bodies[1]), &alarm)
+	if err != nil {
+		t.Fatalf("decoding post body: %s", err)
+	}
+	wantAlarm := Alarm{ID: "a1", Label: "work", Scheduled: "2021-03-01T07:00:00-05:00", Timezone: "EST", Weekday: "monday", Plugins: []string{"webhook"}, Preview: true}
+	if post.Method != http.MethodPost || !reflect.DeepEqual(alarm, wantAlarm) {
+		t.Errorf("got %s with %+v, want a POST with %+v", post.Method, alarm, wantAlarm)
+	}
+}
+
+func TestRestoreSecrets(t *testing.T) {
+	current := `{"hooks":[{"name":"dashboard","url":"https://dash.example.com","headers":{"Authorization":"Bearer secret","X-Team":"platform"}}]}`
+
+	tests := []struct {
+		name    string
+		raw     string
+		want    map[string]string
+		wantErr bool
+	}{
+		{
+			name: "masked",
+			raw:  `{"hooks":[{"name":"dashboard","url":"https://dash.example.com","headers":{"Authorization":"********","X-Team":"********"}}]}`,
+			want: map[string]string{"Authorization": "Bearer secret", "X-Team": "platform"},
+		},
+		{
+			name: "changed",
+			raw:  `{"hooks":[{"name":"dashboard","url":"https://dash.example.com","headers":{"Authorization":"Bearer new","X-Team":"********"}}]}`,
+			want: map[string]string{"Authorization": "Bearer new", "X-Team": "platform"},
+		},
+		{
+			name:    "masked new header",
+			raw:     `{"hooks":[{"name":"dashboard","url":"https://dash.example.com","headers":{"X-Key":"********"}}]}`,
+			wantErr: true,
+		},
+		{
+			name:    "masked on a renamed hook",
+			raw:     `{"hooks":[{"name":"board","url":"https://dash.example.com","headers":{"Authorization":"********"}}]}`,
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			restored, err := New().RestoreSecrets(json.RawMessage(tt.raw), json.RawMessage(current))
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("got error %v, want error %t", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+
+			settings := Settings{}
+			err = json.Unmarshal(restored, &settings)
+			if err != nil {
+				t.Fatalf("decoding restored settings: %s", err)
+			}
+			if got := settings.Hooks[0].Headers; !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got headers %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMaskSecrets(t *testing.T) {
+	current := json.RawMessage(`{"hooks":[{"name":"dashboard","url":"https://dash.example.com","headers":{"Authorization":"Bearer secret"}}]}`)
+	w := New()
+
+	masked, err := w.MaskSecrets(current)
+	if err != nil {
+		t.Fatalf("masking: %s", err)
+	}
+	if strings.Contains(string(masked), "Bearer secret") {
+		t.Errorf("got %s, want the header masked", masked)
+	}
+
+	restored, err := w.RestoreSecrets(masked, current)
+	if err != nil {
+		t.Fatalf("restoring: %s", err)
+	}
+	if !strings.Contains(string(restored), "Bearer secret") {
+		t.Errorf("got %s, want the header restored", restored)
+	}
+}
@@ -0,0 +1,78 @@
+package plugins
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	log "github.com/golang/glog"
+	"github.com/valyala/fasthttp"
+
+	"github.com/jchorl/gowaker/plugin"
+	"github.com/jchorl/gowaker/requestcontext"
+)
+
+func HandlerGet(ctx *fasthttp.RequestCtx) {
+	registry := requestcontext.Plugins(ctx)
+
+	// secrets like passwords aren't shown, even to read-only tokens
+	list := registry.List()
+	for i, s := range list {
+		list[i] = registry.Masked(s)
+	}
+
+	ctx.Response.SetStatusCode(fasthttp.StatusOK)
+	json.NewEncoder(ctx).Encode(list)
+}
+
+func HandlerPut(ctx *fasthttp.RequestCtx) {
+	registry := requestcontext.Plugins(ctx)
+	name := ctx.UserValue("name").(string)
+
+	settings, err := registry.Get(name)
+	if errors.Is(err, plugin.ErrNotFound) {
+		ctx.Error(err.Error(), fasthttp.StatusNotFound)
+		return
+	} else if err != nil {
+		err = fmt.Errorf("getting plugin settings: %w", err)
+		log.Error(err)
+		ctx.Error(err.Error(), fasthttp.StatusInternalServerError)
+		return
+	}
+
+	// decode over the current settings so partial updates work
+	err = json.Unmarshal(ctx.Request.Body(), &settings)
+	if err != nil {
+		err = fmt.Errorf("decoding body: %w", err)
+		log.Error(err)
+		ctx.Error(err.Error(), fasthttp.StatusBadRequest)
+		return
+	}
+	settings.Name = name
+
+	err = registry.Update(settings)
+	var invalid *plugin.InvalidSettingsError
+	if errors.As(err, &invalid) {
+		err = fmt.Errorf("updating plugin %s: %w", name, err)
+		log.Error(err)
+		ctx.Error(err.Error(), fasthttp.StatusBadRequest)
+		return
+	} else if err != nil {
+		err = fmt.Errorf("updating plugin %s: %w", name, err)
+		log.Error(err)
+		ctx.Error(err.Error(), fasthttp.StatusInternalServerError)
+		return
+	}
+
+	// read back what was saved, with its secrets masked again
+	settings, err = registry.Get(name)
+	if err != nil {
+		err = fmt.Errorf("getting plugin settings: %w", err)
+		log.Error(err)
+		ctx.Error(err.Error(), fasthttp.StatusInternalServerError)
+		return
+	}
+
+	ctx.Response.SetStatusCode(fasthttp.StatusOK)
+	json.NewEncoder(ctx).Encode(registry.Masked(settings))
+}
@@ -4,12 +4,13 @@ import (
 	"database/sql"
 	"math/rand"
 
-	texttospeech "cloud.google.com/go/texttospeech/apiv1"
 	"github.com/jasonlvhit/gocron"
 	"github.com/jchorl/spotify"
 	"github.com/valyala/fasthttp"
 
+	"github.com/jchorl/gowaker/events"
 	"github.com/jchorl/gowaker/plugin"
+	"github.com/jchorl/gowaker/speech/provider"
 )
 
 // we hold internal state that no other package can touch.
@@ -60,22 +61,32 @@ func Rand(ctx *fasthttp.RequestCtx) *rand.Rand {
 
 const speechKey = "speech"
 
-func SetSpeech(ctx *fasthttp.RequestCtx, c *texttospeech.Client) {
-	set(ctx, speechKey, c)
+func SetSpeech(ctx *fasthttp.RequestCtx, p provider.Provider) {
+	set(ctx, speechKey, p)
 }
 
-func Speech(ctx *fasthttp.RequestCtx) *texttospeech.Client {
-	return get(ctx, speechKey).(*texttospeech.Client)
+func Speech(ctx *fasthttp.RequestCtx) provider.Provider {
+	return get(ctx, speechKey).(provider.Provider)
 }
 
 const pluginsKey = "plugins"
 
-func SetPlugins(ctx *fasthttp.RequestCtx, plugins []plugin.Plugin) {
-	set(ctx, pluginsKey, plugins)
+func SetPlugins(ctx *fasthttp.RequestCtx, registry *plugin.Registry) {
+	set(ctx, pluginsKey, registry)
 }
 
-func Plugins(ctx *fasthttp.RequestCtx) []plugin.Plugin {
-	return get(ctx, pluginsKey).([]plugin.Plugin)
+func Plugins(ctx *fasthttp.RequestCtx) *plugin.Registry {
+	return get(ctx, pluginsKey).(*plugin.Registry)
+}
+
+const eventsKey = "events"
+
+func SetEvents(ctx *fasthttp.RequestCtx, bus *events.Bus) {
+	set(ctx, eventsKey, bus)
+}
+
+func Events(ctx *fasthttp.RequestCtx) *events.Bus {
+	return get(ctx, eventsKey).(*events.Bus)
 }
 
 const internalCtxKey = "__gowaker_internal"
@@ -86,6 +97,24 @@ func Clone(ctx *fasthttp.RequestCtx) *fasthttp.RequestCtx {
 	return cl
 }
 
+// internalRequestKey marks ctxs that the server made for itself. It is kept
+// outside the internal ctx, which isn't set until the first middleware runs.
+const internalRequestKey = "__gowaker_internal_request"
+
+// NewInternal returns a ctx for work the server does itself, like restoring
+// alarms, instead of for a request. Middlewares that check who is calling,
+// like auth, let it through.
+func NewInternal() *fasthttp.RequestCtx {
+	ctx := &fasthttp.RequestCtx{}
+	ctx.SetUserValue(internalRequestKey, true)
+	return ctx
+}
+
+// IsInternal returns whether ctx came from NewInternal
+func IsInternal(ctx *fasthttp.RequestCtx) bool {
+	return ctx.UserValue(internalRequestKey) != nil
+}
+
 func set(ctx *fasthttp.RequestCtx, key intCtxKey, value interface{}) {
 	// make sure internal ctx is already on the request
 	intCtxUntyped := ctx.UserValue(internalCtxKey)
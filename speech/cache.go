@@ -0,0 +1,323 @@
+package speech
+
+import (
+	"container/list"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/golang/glog"
+	"github.com/valyala/fasthttp"
+
+	"github.com/jchorl/gowaker/requestcontext"
+	"github.com/jchorl/gowaker/speech/provider"
+)
+
+const cacheFileExt = ".wav"
+
+// tmpPrefix starts the names of files that are still being written
+const tmpPrefix = "tmp-"
+
+// Cache is a provider that keeps synthesized audio on disk, keyed by a hash
+// of everything that affects the audio. SSML documents are cached phrase by
+// phrase, so the parts of a briefing that don't change from day to day are
+// only synthesized once. The least recently used entries are evicted once the
+// cache grows past its size cap.
+type Cache struct {
+	provider.Provider
+
+	dir      string
+	maxBytes int64
+
+	mu      sync.Mutex
+	size    int64
+	lru     *list.List // of *cacheEntry, most recently used at the front
+	entries map[string]*list.Element
+}
+
+type cacheEntry struct {
+	key  string
+	size int64
+}
+
+// CacheStats describes the contents of the cache
+type CacheStats struct {
+	Entries  int   `json:"entries"`
+	Bytes    int64 `json:"bytes"`
+	MaxBytes int64 `json:"max_bytes"`
+}
+
+// NewCache wraps a provider with a disk cache in dir, holding at most maxBytes of audio
+func NewCache(p provider.Provider, dir string, maxBytes int64) (*Cache, error) {
+	err := os.MkdirAll(dir, 0755)
+	if err != nil {
+		return nil, fmt.Errorf("creating cache dir %s: %w", dir, err)
+	}
+
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("reading cache dir %s: %w", dir, err)
+	}
+
+	// modification times are bumped on every hit, so they order the lru across restarts
+	sort.Slice(files, func(i, j int) bool {
+		return files[i].ModTime().Before(files[j].ModTime())
+	})
+
+	c := &Cache{
+		Provider: p,
+		dir:      dir,
+		maxBytes: maxBytes,
+		lru:      list.New(),
+		entries:  map[string]*list.Element{},
+	}
+	for _, f := range files {
+		// a crash while writing leaves a temp file behind
+		if !f.IsDir() && strings.HasPrefix(f.Name(), tmpPrefix) {
+			err = os.Remove(filepath.Join(dir, f.Name()))
+			if err != nil {
+				log.Errorf("removing leftover temp file %s: %s", f.Name(), err)
+			}
+			continue
+		}
+		if f.IsDir() || filepath.Ext(f.Name()) != cacheFileExt {
+			continue
+		}
+
+		key := strings.TrimSuffix(f.Name(), cacheFileExt)
+		c.entries[key] = c.lru.PushFront(&cacheEntry{key: key, size: f.Size()})
+		c.size += f.Size()
+	}
+	c.evict()
+
+	return c, nil
+}
+
+// Synthesize returns cached audio for the request if there is any, otherwise
+// it synthesizes and caches it. SSML documents are synthesized and cached a
+// phrase at a time, then joined.
+func (c *Cache) Synthesize(ctx context.Context, req provider.Request) ([]byte, error) {
+	if !req.SSML {
+		return c.synthesize(ctx, req)
+	}
+
+	phrases := splitPhrases(req.Text)
+	if phrases == nil {
+		return c.synthesize(ctx, req)
+	}
+
+	parts := make([][]byte, 0, len(phrases))
+	for _, phrase := range phrases {
+		phraseReq := req
+		phraseReq.Text = phrase
+		contents, err := c.synthesize(ctx, phraseReq)
+		if err != nil {
+			return nil, err
+		}
+		parts = append(parts, contents)
+	}
+
+	contents, err := joinWAV(parts)
+	if err != nil {
+		log.Errorf("joining synthesized phrases, synthesizing the whole document instead: %s", err)
+		return c.synthesize(ctx, req)
+	}
+
+	return contents, nil
+}
+
+func (c *Cache) synthesize(ctx context.Context, req provider.Request) ([]byte, error) {
+	key, err := cacheKey(req)
+	if err != nil {
+		return nil, err
+	}
+
+	contents, ok := c.get(key)
+	if ok {
+		return contents, nil
+	}
+
+	contents, err = c.Provider.Synthesize(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+
+	err = c.put(key, contents)
+	if err != nil {
+		// the audio is still good even if it couldn't be cached
+		log.Errorf("caching speech: %s", err)
+	}
+
+	return contents, nil
+}
+
+// Stats returns a summary of the cache contents
+func (c *Cache) Stats() CacheStats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return CacheStats{
+		Entries:  c.lru.Len(),
+		Bytes:    c.size,
+		MaxBytes: c.maxBytes,
+	}
+}
+
+// Invalidate removes every entry from the cache
+func (c *Cache) Invalidate() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for c.lru.Len() > 0 {
+		err := c.remove(c.lru.Back())
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (c *Cache) get(key string) ([]byte, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	elem, ok := c.entries[key]
+	if !ok {
+		return nil, false
+	}
+
+	path := c.path(key)
+	contents, err := ioutil.ReadFile(path)
+	if err != nil {
+		log.Errorf("reading cached speech %s: %s", path, err)
+		c.remove(elem)
+		return nil, false
+	}
+
+	now := time.Now()
+	err = os.Chtimes(path, now, now)
+	if err != nil {
+		log.Errorf("touching cached speech %s: %s", path, err)
+	}
+	c.lru.MoveToFront(elem)
+
+	return contents, true
+}
+
+func (c *Cache) put(key string, contents []byte) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if _, ok := c.entries[key]; ok {
+		return nil
+	}
+
+	// write to a temp file and rename so a crash never leaves a partial entry behind
+	tmp, err := ioutil.TempFile(c.dir, tmpPrefix)
+	if err != nil {
+		return fmt.Errorf("creating temp file: %w", err)
+	}
+	_, err = tmp.Write(contents)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+		return fmt.Errorf("writing temp file: %w", err)
+	}
+
+	err = os.Rename(tmp.Name(), c.path(key))
+	if err != nil {
+		os.Remove(tmp.Name())
+		return fmt.Errorf("renaming temp file: %w", err)
+	}
+
+	size := int64(len(contents))
+	c.entries[key] = c.lru.PushFront(&cacheEntry{key: key, size: size})
+	c.size += size
+	c.evict()
+
+	return nil
+}
+
+// evict must be called with c.mu held
+func (c *Cache) evict() {
+	for c.size > c.maxBytes && c.lru.Len() > 0 {
+		err := c.remove(c.lru.Back())
+		if err != nil {
+			log.Errorf("evicting cached speech: %s", err)
+			return
+		}
+	}
+}
+
+// remove must be called with c.mu held
+func (c *Cache) remove(elem *list.Element) error {
+	entry := elem.Value.(*cacheEntry)
+
+	err := os.Remove(c.path(entry.key))
+	if err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("removing cached speech %s: %w", entry.key, err)
+	}
+
+	c.lru.Remove(elem)
+	delete(c.entries, entry.key)
+	c.size -= entry.size
+
+	return nil
+}
+
+func (c *Cache) path(key string) string {
+	return filepath.Join(c.dir, key+cacheFileExt)
+}
+
+// cacheKey hashes everything about a request that changes the audio
+func cacheKey(req provider.Request) (string, error) {
+	b, err := json.Marshal(req)
+	if err != nil {
+		return "", fmt.Errorf("marshalling request: %w", err)
+	}
+
+	sum := sha256.Sum256(b)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+func HandlerGetCache(ctx *fasthttp.RequestCtx) {
+	cache, ok := requestcontext.Speech(ctx).(*Cache)
+	if !ok {
+		ctx.Error("speech cache is not enabled", fasthttp.StatusNotFound)
+		return
+	}
+
+	ctx.Response.SetStatusCode(fasthttp.StatusOK)
+	json.NewEncoder(ctx).Encode(cache.Stats())
+}
+
+func HandlerInvalidateCache(ctx *fasthttp.RequestCtx) {
+	cache, ok := requestcontext.Speech(ctx).(*Cache)
+	if !ok {
+		ctx.Error("speech cache is not enabled", fasthttp.StatusNotFound)
+		return
+	}
+
+	err := cache.Invalidate()
+	if err != nil {
+		err = fmt.Errorf("invalidating speech cache: %w", err)
+		log.Error(err)
+		ctx.Error(err.Error(), fasthttp.StatusInternalServerError)
+		return
+	}
+
+	ctx.Response.SetStatusCode(fasthttp.StatusOK)
+	json.NewEncoder(ctx).Encode(cache.Stats())
+}
@@ -0,0 +1,155 @@
+package speech
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/jchorl/gowaker/speech/provider"
+)
+
+// fakeProvider "synthesizes" a wav whose samples are the request's text
+type fakeProvider struct {
+	mu       sync.Mutex
+	requests []string
+}
+
+func (f *fakeProvider) Synthesize(ctx context.Context, req provider.Request) ([]byte, error) {
+	f.mu.Lock()
+	f.requests = append(f.requests, req.Text)
+	f.mu.Unlock()
+
+	return testWAV([]byte(req.Text)), nil
+}
+
+func (f *fakeProvider) SupportsSSML() bool {
+	return true
+}
+
+func (f *fakeProvider) Voices(ctx context.Context, languageCode string) ([]provider.Voice, error) {
+	return nil, nil
+}
+
+func testWAV(data []byte) []byte {
+	// mono 16 bit 24kHz, like google's LINEAR16
+	format := make([]byte, 16)
+	binary.LittleEndian.PutUint16(format[0:], 1)
+	binary.LittleEndian.PutUint16(format[2:], 1)
+	binary.LittleEndian.PutUint32(format[4:], 24000)
+	binary.LittleEndian.PutUint32(format[8:], 48000)
+	binary.LittleEndian.PutUint16(format[12:], 2)
+	binary.LittleEndian.PutUint16(format[14:], 16)
+
+	var b bytes.Buffer
+	b.WriteString("RIFF")
+	binary.Write(&b, binary.LittleEndian, uint32(4+8+len(format)+8+len(data)))
+	b.WriteString("WAVEfmt ")
+	binary.Write(&b, binary.LittleEndian, uint32(len(format)))
+	b.Write(format)
+	b.WriteString("data")
+	binary.Write(&b, binary.LittleEndian, uint32(len(data)))
+	b.Write(data)
+	return b.Bytes()
+}
+
+func TestSplitPhrases(t *testing.T) {
+	tests := []struct {
+		name string
+		doc  string
+		want []string
+	}{
+		{
+			name: "breaks",
+			doc:  `<speak>Sunny. <break time="1s"/>Planning at <say-as interpret-as="time">10:00</say-as>.<break time="1s"/>Have a great day!</speak>`,
+			want: []string{
+				`<speak>Sunny. <break time="1s"/></speak>`,
+				`<speak>Planning at <say-as interpret-as="time">10:00</say-as>.<break time="1s"/></speak>`,
+				`<speak>Have a great day!</speak>`,
+			},
+		},
+		{
+			name: "nested breaks stay put",
+			doc:  `<speak xml:lang="en-US"><p>One<break time="1s"/>two</p><break time="300ms"></break>three</speak>`,
+			want: []string{
+				`<speak xml:lang="en-US"><p>One<break time="1s"/>two</p><break time="300ms"></break></speak>`,
+				`<speak xml:lang="en-US">three</speak>`,
+			},
+		},
+		{name: "no breaks", doc: `<speak>Just one phrase.</speak>`},
+		{name: "trailing break", doc: `<speak>Just one phrase.<break time="1s"/> </speak>`},
+		{name: "not ssml", doc: `<p>Hi<break/>there</p>`},
+		{name: "malformed", doc: `<speak>Hi<break time="1s"/>there`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := splitPhrases(tt.doc)
+			if len(got) != len(tt.want) {
+				t.Fatalf("got phrases %q, want %q", got, tt.want)
+			}
+			for i := range got {
+				if got[i] != tt.want[i] {
+					t.Errorf("phrase %d is %q, want %q", i, got[i], tt.want[i])
+				}
+			}
+		})
+	}
+}
+
+func TestCacheReusesPhrases(t *testing.T) {
+	fake := &fakeProvider{}
+	c, err := NewCache(fake, t.TempDir(), 1<<20)
+	if err != nil {
+		t.Fatalf("creating cache: %s", err)
+	}
+
+	monday := `<speak>It's Monday.<break time="1s"/>Have a great day!</speak>`
+	tuesday := `<speak>It's Tuesday.<break time="1s"/>Have a great day!</speak>`
+
+	for _, doc := range []string{monday, tuesday, monday} {
+		audio, err := c.Synthesize(context.Background(), provider.Request{Text: doc, SSML: true})
+		if err != nil {
+			t.Fatalf("synthesizing: %s", err)
+		}
+
+		w, err := parseWAV(audio)
+		if err != nil {
+			t.Fatalf("parsing joined audio: %s", err)
+		}
+		phrases := splitPhrases(doc)
+		if want := phrases[0] + phrases[1]; string(w.data) != want {
+			t.Errorf("got audio %q, want %q", w.data, want)
+		}
+	}
+
+	// the sign off is shared and monday is repeated, so only three phrases are new
+	if len(fake.requests) != 3 {
+		t.Errorf("synthesized %q, want each phrase once", fake.requests)
+	}
+}
+
+func TestCacheSweepsTempFiles(t *testing.T) {
+	dir := t.TempDir()
+	leftover := filepath.Join(dir, tmpPrefix+"123")
+	err := ioutil.WriteFile(leftover, []byte("partial"), 0644)
+	if err != nil {
+		t.Fatalf("writing temp file: %s", err)
+	}
+
+	c, err := NewCache(&fakeProvider{}, dir, 1<<20)
+	if err != nil {
+		t.Fatalf("creating cache: %s", err)
+	}
+
+	if _, err := os.Stat(leftover); !os.IsNotExist(err) {
+		t.Errorf("temp file is still there: %v", err)
+	}
+	if stats := c.Stats(); stats.Entries != 0 || stats.Bytes != 0 {
+		t.Errorf("got stats %+v, want an empty cache", stats)
+	}
+}
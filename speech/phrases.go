@@ -0,0 +1,141 @@
+package speech
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// splitPhrases splits an SSML document into documents that each run up to and
+// including one of its top level breaks. Briefings change a little every day,
+// but many of their phrases, like the sign off or a headline, come up again,
+// and synthesizing them on their own lets the cache reuse them. Documents
+// that can't be split come back as nil.
+func splitPhrases(doc string) []string {
+	decoder := xml.NewDecoder(strings.NewReader(doc))
+
+	var phrases []string
+	var open string
+	start, depth := 0, 0
+	inBreak := false
+	for {
+		offset := int(decoder.InputOffset())
+		tok, err := decoder.RawToken()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return nil
+		}
+
+		switch t := tok.(type) {
+		case xml.StartElement:
+			depth++
+			if depth == 1 {
+				if t.Name.Local != "speak" {
+					return nil
+				}
+				open = doc[offset:decoder.InputOffset()]
+				start = int(decoder.InputOffset())
+			}
+			if depth == 2 && t.Name.Local == "break" {
+				inBreak = true
+			}
+		case xml.EndElement:
+			if depth == 2 && inBreak {
+				end := int(decoder.InputOffset())
+				phrases = append(phrases, doc[start:end])
+				start, inBreak = end, false
+			}
+			if depth == 1 && strings.TrimSpace(doc[start:offset]) != "" {
+				phrases = append(phrases, doc[start:offset])
+			}
+			depth--
+		}
+	}
+
+	if len(phrases) < 2 {
+		return nil
+	}
+
+	docs := make([]string, len(phrases))
+	for i, phrase := range phrases {
+		docs[i] = open + phrase + "</speak>"
+	}
+	return docs
+}
+
+// wav is the parts of a wav file that joinWAV needs
+type wav struct {
+	format []byte
+	data   []byte
+}
+
+func parseWAV(b []byte) (wav, error) {
+	if len(b) < 12 || string(b[:4]) != "RIFF" || string(b[8:12]) != "WAVE" {
+		return wav{}, errors.New("not a wav file")
+	}
+
+	w := wav{}
+	for rest := b[12:]; len(rest) >= 8; {
+		id, size := string(rest[:4]), int(binary.LittleEndian.Uint32(rest[4:8]))
+		if size > len(rest)-8 {
+			// streamed wavs can leave the size of their data unset
+			size = len(rest) - 8
+		}
+		body := rest[8 : 8+size]
+
+		switch id {
+		case "fmt ":
+			w.format = body
+		case "data":
+			w.data = body
+		}
+
+		// chunks are padded to an even size
+		next := 8 + size + size%2
+		if next > len(rest) {
+			break
+		}
+		rest = rest[next:]
+	}
+
+	if w.format == nil || w.data == nil {
+		return wav{}, errors.New("wav file is missing its format or data")
+	}
+	return w, nil
+}
+
+// joinWAV plays wav files one after another, as long as they share a format
+func joinWAV(files [][]byte) ([]byte, error) {
+	var format []byte
+	var data bytes.Buffer
+	for i, f := range files {
+		w, err := parseWAV(f)
+		if err != nil {
+			return nil, fmt.Errorf("parsing wav %d: %w", i, err)
+		}
+		if format == nil {
+			format = w.format
+		} else if !bytes.Equal(format, w.format) {
+			return nil, fmt.Errorf("wav %d has a different format", i)
+		}
+		data.Write(w.data)
+	}
+
+	var b bytes.Buffer
+	b.WriteString("RIFF")
+	binary.Write(&b, binary.LittleEndian, uint32(4+8+len(format)+8+data.Len()))
+	b.WriteString("WAVE")
+	b.WriteString("fmt ")
+	binary.Write(&b, binary.LittleEndian, uint32(len(format)))
+	b.Write(format)
+	b.WriteString("data")
+	binary.Write(&b, binary.LittleEndian, uint32(data.Len()))
+	b.Write(data.Bytes())
+
+	return b.Bytes(), nil
+}
@@ -0,0 +1,88 @@
+package provider
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	texttospeech "cloud.google.com/go/texttospeech/apiv1"
+	"google.golang.org/api/option"
+	texttospeechpb "google.golang.org/genproto/googleapis/cloud/texttospeech/v1"
+)
+
+// Google is a provider backed by Google Cloud Text-to-Speech
+type Google struct {
+	client *texttospeech.Client
+}
+
+// NewGoogle returns a new Google Cloud Text-to-Speech provider
+func NewGoogle(credentialFile string) (*Google, error) {
+	client, err := texttospeech.NewClient(context.Background(), option.WithCredentialsFile(credentialFile))
+	if err != nil {
+		return nil, fmt.Errorf("creating text-to-speech client: %w", err)
+	}
+
+	return &Google{client: client}, nil
+}
+
+// Synthesize performs a text-to-speech request
+func (g *Google) Synthesize(ctx context.Context, r Request) ([]byte, error) {
+	input := &texttospeechpb.SynthesisInput{
+		InputSource: &texttospeechpb.SynthesisInput_Text{Text: r.Text},
+	}
+	if r.SSML {
+		input.InputSource = &texttospeechpb.SynthesisInput_Ssml{Ssml: r.Text}
+	}
+
+	req := texttospeechpb.SynthesizeSpeechRequest{
+		Input: input,
+		Voice: &texttospeechpb.VoiceSelectionParams{
+			LanguageCode: r.LanguageCode,
+			Name:         r.VoiceName,
+		},
+		AudioConfig: &texttospeechpb.AudioConfig{
+			AudioEncoding: texttospeechpb.AudioEncoding_LINEAR16,
+			SpeakingRate:  r.SpeakingRate,
+			Pitch:         r.Pitch,
+			VolumeGainDb:  r.VolumeGainDb,
+		},
+	}
+
+	// without a named voice, let google pick a neutral one for the language
+	if r.VoiceName == "" {
+		req.Voice.SsmlGender = texttospeechpb.SsmlVoiceGender_NEUTRAL
+	}
+
+	resp, err := g.client.SynthesizeSpeech(ctx, &req)
+	if err != nil {
+		return nil, fmt.Errorf("synthesizing speech: %w", err)
+	}
+
+	// The resp's AudioContent is binary.
+	return resp.AudioContent, nil
+}
+
+// SupportsSSML is always true for google
+func (g *Google) SupportsSSML() bool {
+	return true
+}
+
+// Voices lists the voices google offers for a language
+func (g *Google) Voices(ctx context.Context, languageCode string) ([]Voice, error) {
+	resp, err := g.client.ListVoices(ctx, &texttospeechpb.ListVoicesRequest{LanguageCode: languageCode})
+	if err != nil {
+		return nil, fmt.Errorf("listing voices: %w", err)
+	}
+
+	voices := make([]Voice, 0, len(resp.Voices))
+	for _, v := range resp.Voices {
+		voices = append(voices, Voice{
+			Name:                   v.Name,
+			LanguageCodes:          v.LanguageCodes,
+			Gender:                 strings.ToLower(v.SsmlGender.String()),
+			NaturalSampleRateHertz: int(v.NaturalSampleRateHertz),
+		})
+	}
+
+	return voices, nil
+}
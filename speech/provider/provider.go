@@ -0,0 +1,37 @@
+package provider
+
+import (
+	"context"
+)
+
+// Provider synthesizes speech and describes the voices it offers
+type Provider interface {
+	// Synthesize returns LINEAR16 wav audio for the request
+	Synthesize(ctx context.Context, req Request) ([]byte, error)
+
+	// SupportsSSML reports whether requests may contain SSML documents
+	SupportsSSML() bool
+
+	// Voices lists the voices available, optionally filtered by language code
+	Voices(ctx context.Context, languageCode string) ([]Voice, error)
+}
+
+// Request is a single synthesis request
+type Request struct {
+	// Text is plain text, or an SSML document when SSML is set
+	Text         string
+	SSML         bool
+	VoiceName    string
+	LanguageCode string
+	SpeakingRate float64
+	Pitch        float64
+	VolumeGainDb float64
+}
+
+// Voice describes a voice offered by a provider
+type Voice struct {
+	Name                   string   `json:"name"`
+	LanguageCodes          []string `json:"language_codes"`
+	Gender                 string   `json:"gender"`
+	NaturalSampleRateHertz int      `json:"natural_sample_rate_hertz"`
+}
@@ -0,0 +1,209 @@
+package speech
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"strconv"
+
+	log "github.com/golang/glog"
+	"github.com/valyala/fasthttp"
+
+	"github.com/jchorl/gowaker/requestcontext"
+)
+
+const (
+	voiceNameKey    = "voice_name"
+	languageCodeKey = "language_code"
+	speakingRateKey = "speaking_rate"
+	pitchKey        = "pitch"
+	volumeGainDbKey = "volume_gain_db"
+)
+
+// Settings control how speech is synthesized
+type Settings struct {
+	VoiceName    string  `json:"voice_name"`
+	LanguageCode string  `json:"language_code"`
+	SpeakingRate float64 `json:"speaking_rate"`
+	Pitch        float64 `json:"pitch"`
+	VolumeGainDb float64 `json:"volume_gain_db"`
+}
+
+var defaultSettings = Settings{
+	LanguageCode: "en-US",
+	SpeakingRate: 1,
+}
+
+func (s Settings) validate() error {
+	if s.LanguageCode == "" {
+		return fmt.Errorf("language_code is required")
+	}
+	if s.SpeakingRate < 0.25 || s.SpeakingRate > 4 {
+		return fmt.Errorf("speaking_rate must be between 0.25 and 4, got %v", s.SpeakingRate)
+	}
+	if s.Pitch < -20 || s.Pitch > 20 {
+		return fmt.Errorf("pitch must be between -20 and 20, got %v", s.Pitch)
+	}
+	if s.VolumeGainDb < -96 || s.VolumeGainDb > 16 {
+		return fmt.Errorf("volume_gain_db must be between -96 and 16, got %v", s.VolumeGainDb)
+	}
+	return nil
+}
+
+func HandlerGetSettings(ctx *fasthttp.RequestCtx) {
+	settings, err := getSettings(ctx)
+	if err != nil {
+		err = fmt.Errorf("getting speech settings: %w", err)
+		log.Error(err)
+		ctx.Error(err.Error(), fasthttp.StatusInternalServerError)
+		return
+	}
+
+	ctx.Response.SetStatusCode(fasthttp.StatusOK)
+	json.NewEncoder(ctx).Encode(settings)
+}
+
+func HandlerSetSettings(ctx *fasthttp.RequestCtx) {
+	settings, err := getSettings(ctx)
+	if err != nil {
+		err = fmt.Errorf("getting speech settings: %w", err)
+		log.Error(err)
+		ctx.Error(err.Error(), fasthttp.StatusInternalServerError)
+		return
+	}
+
+	// decode over the current settings so partial updates work
+	err = json.Unmarshal(ctx.Request.Body(), &settings)
+	if err != nil {
+		err = fmt.Errorf("decoding body: %w", err)
+		log.Error(err)
+		ctx.Error(err.Error(), fasthttp.StatusBadRequest)
+		return
+	}
+
+	err = settings.validate()
+	if err != nil {
+		err = fmt.Errorf("validating settings: %w", err)
+		log.Error(err)
+		ctx.Error(err.Error(), fasthttp.StatusBadRequest)
+		return
+	}
+
+	err = setSettings(ctx, settings)
+	if err != nil {
+		err = fmt.Errorf("saving speech settings: %w", err)
+		log.Error(err)
+		ctx.Error(err.Error(), fasthttp.StatusInternalServerError)
+		return
+	}
+
+	ctx.Response.SetStatusCode(fasthttp.StatusOK)
+	json.NewEncoder(ctx).Encode(settings)
+}
+
+func HandlerGetVoices(ctx *fasthttp.RequestCtx) {
+	p := requestcontext.Speech(ctx)
+	languageCode := ctx.FormValue("language_code")
+
+	voices, err := p.Voices(context.TODO(), string(languageCode))
+	if err != nil {
+		err = fmt.Errorf("listing voices: %w", err)
+		log.Error(err)
+		ctx.Error(err.Error(), fasthttp.StatusInternalServerError)
+		return
+	}
+
+	ctx.Response.SetStatusCode(fasthttp.StatusOK)
+	json.NewEncoder(ctx).Encode(voices)
+}
+
+func getSettings(ctx *fasthttp.RequestCtx) (Settings, error) {
+	db := requestcontext.DB(ctx)
+
+	rows, err := db.Query("select key, value from speech_config")
+	if err != nil {
+		return Settings{}, fmt.Errorf("querying speech config: %w", err)
+	}
+	defer rows.Close()
+
+	settings := defaultSettings
+	for rows.Next() {
+		var key, value string
+		err = rows.Scan(&key, &value)
+		if err != nil {
+			return Settings{}, fmt.Errorf("scanning row: %w", err)
+		}
+
+		switch key {
+		case voiceNameKey:
+			settings.VoiceName = value
+		case languageCodeKey:
+			settings.LanguageCode = value
+		case speakingRateKey:
+			settings.SpeakingRate, err = strconv.ParseFloat(value, 64)
+		case pitchKey:
+			settings.Pitch, err = strconv.ParseFloat(value, 64)
+		case volumeGainDbKey:
+			settings.VolumeGainDb, err = strconv.ParseFloat(value, 64)
+		}
+		if err != nil {
+			return Settings{}, fmt.Errorf("parsing %s: %w", key, err)
+		}
+	}
+	err = rows.Err()
+	if err != nil {
+		return Settings{}, fmt.Errorf("iterating over speech config query results: %w", err)
+	}
+
+	return settings, nil
+}
+
+func setSettings(ctx *fasthttp.RequestCtx, settings Settings) error {
+	db := requestcontext.DB(ctx)
+
+	tx, err := db.Begin()
+	if err != nil {
+		return fmt.Errorf("beginning tx: %w", err)
+	}
+
+	err = upsertSettings(tx, settings)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return fmt.Errorf("committing tx: %w", err)
+	}
+
+	return nil
+}
+
+func upsertSettings(tx *sql.Tx, settings Settings) error {
+	stmt, err := tx.Prepare(`
+		insert into speech_config(key, value) values(?, ?) on conflict(key) do update set value = ?
+	`,
+	)
+	if err != nil {
+		return fmt.Errorf("preparing speech config upsert stmt: %w", err)
+	}
+	defer stmt.Close()
+
+	values := map[string]string{
+		voiceNameKey:    settings.VoiceName,
+		languageCodeKey: settings.LanguageCode,
+		speakingRateKey: strconv.FormatFloat(settings.SpeakingRate, 'f', -1, 64),
+		pitchKey:        strconv.FormatFloat(settings.Pitch, 'f', -1, 64),
+		volumeGainDbKey: strconv.FormatFloat(settings.VolumeGainDb, 'f', -1, 64),
+	}
+	for key, value := range values {
+		_, err = stmt.Exec(key, value, value)
+		if err != nil {
+			return fmt.Errorf("executing speech config upsert stmt for %s: %w", key, err)
+		}
+	}
+
+	return nil
+}
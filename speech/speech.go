@@ -4,53 +4,56 @@ import (
 	"context"
 	"fmt"
 
-	texttospeech "cloud.google.com/go/texttospeech/apiv1"
-	"github.com/jchorl/gowaker/requestcontext"
 	"github.com/valyala/fasthttp"
-	"google.golang.org/api/option"
-	texttospeechpb "google.golang.org/genproto/googleapis/cloud/texttospeech/v1"
+
+	"github.com/jchorl/gowaker/requestcontext"
+	"github.com/jchorl/gowaker/speech/provider"
+	"github.com/jchorl/gowaker/ssml"
 )
 
-// New returns a new text-to-speech client
-func New(credentialFile string) (*texttospeech.Client, error) {
-	ctx := context.Background()
+// New returns a new text-to-speech provider
+func New(credentialFile string) (provider.Provider, error) {
+	return provider.NewGoogle(credentialFile)
+}
 
-	client, err := texttospeech.NewClient(ctx, option.WithCredentialsFile(credentialFile))
-	if err != nil {
-		return nil, fmt.Errorf("creating text-to-speech client: %w", err)
+// GetAudioContent will translate a string into audio
+func GetAudioContent(ctx *fasthttp.RequestCtx, text string) ([]byte, error) {
+	return synthesize(ctx, text, false)
+}
+
+// GetSSMLAudioContent will translate an SSML document into audio. If the
+// provider does not support SSML, the markup is stripped and the plain text
+// is spoken instead.
+func GetSSMLAudioContent(ctx *fasthttp.RequestCtx, doc string) ([]byte, error) {
+	if !requestcontext.Speech(ctx).SupportsSSML() {
+		return synthesize(ctx, ssml.ToText(doc), false)
 	}
 
-	return client, nil
+	return synthesize(ctx, doc, true)
 }
 
-// GetAudioContent will translate a string into audio
-func GetAudioContent(ctx *fasthttp.RequestCtx, text string) ([]byte, error) {
-	client := requestcontext.Speech(ctx)
-
-	// Perform the text-to-speech request on the text input with the selected
-	// voice parameters and audio file type.
-	req := texttospeechpb.SynthesizeSpeechRequest{
-		// Set the text input to be synthesized.
-		Input: &texttospeechpb.SynthesisInput{
-			InputSource: &texttospeechpb.SynthesisInput_Text{Text: text},
-		},
-		// Build the voice request, select the language code ("en-US") and the SSML
-		// voice gender ("neutral").
-		Voice: &texttospeechpb.VoiceSelectionParams{
-			LanguageCode: "en-US",
-			SsmlGender:   texttospeechpb.SsmlVoiceGender_NEUTRAL,
-		},
-		// Select the type of audio file you want returned.
-		AudioConfig: &texttospeechpb.AudioConfig{
-			AudioEncoding: texttospeechpb.AudioEncoding_LINEAR16,
-		},
+func synthesize(ctx *fasthttp.RequestCtx, text string, isSSML bool) ([]byte, error) {
+	p := requestcontext.Speech(ctx)
+
+	settings, err := getSettings(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("getting speech settings: %w", err)
+	}
+
+	req := provider.Request{
+		Text:         text,
+		SSML:         isSSML,
+		VoiceName:    settings.VoiceName,
+		LanguageCode: settings.LanguageCode,
+		SpeakingRate: settings.SpeakingRate,
+		Pitch:        settings.Pitch,
+		VolumeGainDb: settings.VolumeGainDb,
 	}
 
-	resp, err := client.SynthesizeSpeech(context.TODO(), &req)
+	contents, err := p.Synthesize(context.TODO(), req)
 	if err != nil {
 		return nil, fmt.Errorf("synthesizing speech: %w", err)
 	}
 
-	// The resp's AudioContent is binary.
-	return resp.AudioContent, nil
+	return contents, nil
 }
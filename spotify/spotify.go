@@ -289,18 +289,25 @@ func PauseSong(ctx *fasthttp.RequestCtx) error {
 	return nil
 }
 
-func WaitForSong(ctx *fasthttp.RequestCtx) <-chan error {
+// WaitForSong polls until the song that is playing is nearly over. Closing
+// stop ends the polling without sending anything.
+func WaitForSong(ctx *fasthttp.RequestCtx, stop <-chan struct{}) <-chan error {
 	spotifyClient := requestcontext.Spotify(ctx)
 
-	errChan := make(chan error)
+	// buffered so the poller can finish if nobody is waiting anymore
+	errChan := make(chan error, 1)
 
 	ticker := time.NewTicker(5 * time.Second)
+	timeout := time.NewTimer(10 * time.Minute)
 	go func() {
 		defer ticker.Stop()
+		defer timeout.Stop()
 
 		for {
 			select {
-			case <-time.After(10 * time.Minute):
+			case <-stop:
+				return
+			case <-timeout.C:
 				errChan <- errors.New("timed out polling for spotify song")
 				return
 			case <-ticker.C:
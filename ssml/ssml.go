@@ -0,0 +1,56 @@
+// Package ssml builds Speech Synthesis Markup Language fragments
+package ssml
+
+import (
+	"fmt"
+	"html"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// Escape escapes text so it can be embedded in an SSML document
+func Escape(text string) string {
+	return html.EscapeString(text)
+}
+
+// Break is a pause of the given duration
+func Break(d time.Duration) string {
+	return fmt.Sprintf(`<break time="%dms"/>`, d.Milliseconds())
+}
+
+// Emphasis speaks text with the given emphasis level, e.g. "strong", "moderate" or "reduced"
+func Emphasis(level, text string) string {
+	return fmt.Sprintf(`<emphasis level="%s">%s</emphasis>`, level, Escape(text))
+}
+
+// Time speaks the wall clock time of t, in t's location
+func Time(t time.Time) string {
+	return fmt.Sprintf(`<say-as interpret-as="time" format="hms24">%s</say-as>`, t.Format("15:04"))
+}
+
+// Date speaks the date of t, in t's location
+func Date(t time.Time) string {
+	return fmt.Sprintf(`<say-as interpret-as="date" format="yyyymmdd" detail="1">%s</say-as>`, t.Format("2006-01-02"))
+}
+
+// Speak joins fragments into a single SSML document, pausing between each of them
+func Speak(pause time.Duration, fragments ...string) string {
+	return "<speak>" + strings.Join(fragments, Break(pause)) + "</speak>"
+}
+
+var (
+	tagRe         = regexp.MustCompile(`<[^>]*>`)
+	whitespaceRe  = regexp.MustCompile(`\s+`)
+	punctuationRe = regexp.MustCompile(`\s+([.,!?;:])`)
+)
+
+// ToText strips the markup from an SSML document or fragment, for providers without SSML support
+func ToText(s string) string {
+	// tags usually separate words, so keep a space where they were
+	text := tagRe.ReplaceAllString(s, " ")
+	text = html.UnescapeString(text)
+	text = whitespaceRe.ReplaceAllString(text, " ")
+	text = punctuationRe.ReplaceAllString(text, "$1")
+	return strings.TrimSpace(text)
+}
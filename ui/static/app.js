@@ -0,0 +1,440 @@
+"use strict";
+
+// Alarms are scheduled in UTC on the server. The app shows and takes times in
+// the browser's zone, moving the days along when that crosses midnight.
+const DAYS = ["sunday", "monday", "tuesday", "wednesday", "thursday", "friday", "saturday"];
+const MINUTES_PER_DAY = 24 * 60;
+
+const $ = (selector, root = document) => root.querySelector(selector);
+
+let alarms = [];
+let editing = null;
+
+// the API token is kept in the browser, and asked for when the server wants one
+function token() {
+  return localStorage.getItem("token") || "";
+}
+
+function askForToken() {
+  const entered = prompt("API token (create one with: gowaker token create --name phone)");
+  if (!entered) {
+    return false;
+  }
+  localStorage.setItem("token", entered.trim());
+  return true;
+}
+
+async function api(method, path, body) {
+  const opts = { method, headers: {} };
+  if (body !== undefined) {
+    opts.body = JSON.stringify(body);
+    opts.headers["Content-Type"] = "application/json";
+  }
+  if (token()) {
+    opts.headers["Authorization"] = `Bearer ${token()}`;
+  }
+
+  const resp = await fetch(path, opts);
+  if (resp.status === 401 && askForToken()) {
+    listen();
+    return api(method, path, body);
+  }
+  if (!resp.ok) {
+    const err = new Error((await resp.text()) || resp.statusText);
+    err.status = resp.status;
+    throw err;
+  }
+
+  const text = await resp.text();
+  return text ? JSON.parse(text) : null;
+}
+
+function showError(err) {
+  const el = $("#error");
+  if (!err) {
+    el.hidden = true;
+    return;
+  }
+  el.textContent = err.message || String(err);
+  el.hidden = false;
+}
+
+// shift moves a time and its days by minutes, wrapping around the week
+function shift(hour, minute, days, minutes) {
+  let total = hour * 60 + minute + minutes;
+  const dayShift = Math.floor(total / MINUTES_PER_DAY);
+  total -= dayShift * MINUTES_PER_DAY;
+
+  return {
+    hour: Math.floor(total / 60),
+    minute: total % 60,
+    days: (days || []).filter((d) => DAYS.includes(d)).map((d) => DAYS[(DAYS.indexOf(d) + dayShift + 7) % 7]),
+  };
+}
+
+function localOffset() {
+  return -new Date().getTimezoneOffset();
+}
+
+function toLocal(alarm) {
+  return shift(alarm.time.hour, alarm.time.minute, alarm.days, localOffset());
+}
+
+function toUTC(hour, minute, days) {
+  return shift(hour, minute, days, -localOffset());
+}
+
+function pad(n) {
+  return String(n).padStart(2, "0");
+}
+
+function formatDate(value) {
+  return new Date(value).toLocaleString([], { weekday: "short", month: "short", day: "numeric", hour: "numeric", minute: "2-digit" });
+}
+
+function describeDays(days) {
+  if (days.length === 7) {
+    return "Every day";
+  }
+  const ordered = DAYS.filter((d) => days.includes(d));
+  return ordered.map((d) => d[0].toUpperCase() + d.slice(1, 3)).join(" ");
+}
+
+function showTab(name) {
+  document.querySelectorAll(".tab").forEach((tab) => tab.classList.toggle("active", tab.dataset.tab === name));
+  document.querySelectorAll(".panel").forEach((panel) => (panel.hidden = panel.id !== name));
+
+  if (name === "music") {
+    loadMusic();
+  } else if (name === "history") {
+    loadHistory();
+  }
+}
+
+async function loadAlarms() {
+  try {
+    alarms = await api("GET", "/alarms");
+  } catch (err) {
+    showError(err);
+    return;
+  }
+
+  alarms.sort((a, b) => new Date(a.next_run) - new Date(b.next_run));
+
+  const list = $("#alarm-list");
+  list.replaceChildren();
+  for (const alarm of alarms) {
+    const item = $("#alarm-item").content.cloneNode(true);
+    const local = toLocal(alarm);
+    $(".time", item).textContent = `${pad(local.hour)}:${pad(local.minute)}`;
+    $(".label", item).textContent = alarm.label || (alarm.repeat ? describeDays(local.days) : "Once");
+
+    const details = [];
+    if (alarm.label) {
+      details.push(alarm.repeat ? describeDays(local.days) : "Once");
+    }
+    if (alarm.next_run && !alarm.next_run.startsWith("0001")) {
+      details.push(`next ${formatDate(alarm.next_run)}`);
+    }
+    $(".details", item).textContent = details.join(" · ");
+
+    $(".edit", item).addEventListener("click", () => openForm(alarm));
+    $(".delete", item).addEventListener("click", () => deleteAlarm(alarm));
+    list.appendChild(item);
+  }
+  $("#alarms-empty").hidden = alarms.length > 0;
+}
+
+function openForm(alarm) {
+  editing = alarm;
+  const form = $("#alarm-form");
+  form.reset();
+  $("#alarm-form-title").textContent = alarm ? "Edit alarm" : "New alarm";
+
+  if (alarm) {
+    const local = toLocal(alarm);
+    form.label.value = alarm.label;
+    form.time.value = `${pad(local.hour)}:${pad(local.minute)}`;
+    form.repeat.checked = alarm.repeat;
+    form.timezone.value = alarm.timezone;
+    for (const day of DAYS) {
+      form[day].checked = local.days.includes(day);
+    }
+  } else {
+    form.timezone.value = Intl.DateTimeFormat().resolvedOptions().timeZone || "";
+  }
+
+  $("#days").hidden = !form.repeat.checked;
+  form.hidden = false;
+  $("#new-alarm").hidden = true;
+  form.scrollIntoView({ behavior: "smooth" });
+}
+
+function closeForm() {
+  editing = null;
+  $("#alarm-form").hidden = true;
+  $("#new-alarm").hidden = false;
+}
+
+async function saveAlarm(event) {
+  event.preventDefault();
+  const form = event.target;
+
+  const [hour, minute] = form.time.value.split(":").map(Number);
+  const days = form.repeat.checked ? DAYS.filter((d) => form[d].checked) : [];
+  if (form.repeat.checked && days.length === 0) {
+    showError(new Error("Pick at least one day to repeat on"));
+    return;
+  }
+  const utc = toUTC(hour, minute, days);
+
+  // keep what the app doesn't edit, like plugins and integrations
+  const alarm = Object.assign({}, editing, {
+    label: form.label.value.trim(),
+    time: { hour: utc.hour, minute: utc.minute },
+    repeat: form.repeat.checked,
+    days: utc.days,
+    timezone: form.timezone.value.trim(),
+  });
+  delete alarm.next_run;
+
+  try {
+    if (editing) {
+      await api("PUT", `/alarms/${encodeURIComponent(editing.id)}`, alarm);
+    } else {
+      await api("POST", "/alarms", alarm);
+    }
+  } catch (err) {
+    showError(err);
+    return;
+  }
+
+  showError(null);
+  closeForm();
+  loadAlarms();
+}
+
+async function deleteAlarm(alarm) {
+  if (!confirm(`Delete ${alarm.label || "this alarm"}?`)) {
+    return;
+  }
+
+  try {
+    await api("DELETE", "/alarms", { id: alarm.id });
+  } catch (err) {
+    showError(err);
+    return;
+  }
+  loadAlarms();
+}
+
+function artists(track) {
+  return (track.artists || []).map((a) => a.name).join(", ");
+}
+
+function renderNextSong(track) {
+  const el = $("#next-song");
+  if (!track) {
+    el.textContent = "Nothing picked, so it's a surprise.";
+    el.classList.add("muted");
+    return;
+  }
+  el.textContent = `${track.name} by ${artists(track)}`;
+  el.classList.remove("muted");
+}
+
+async function loadMusic() {
+  try {
+    const playlists = await api("GET", "/spotify/playlists");
+    let current = null;
+    try {
+      current = await api("GET", "/spotify/default_playlist");
+    } catch (err) {
+      // there's no default playlist until one is saved
+    }
+
+    const select = $("#playlist");
+    select.replaceChildren(new Option("Pick a playlist", ""));
+    for (const playlist of playlists) {
+      select.appendChild(new Option(playlist.name, playlist.id, false, current && current.id === playlist.id));
+    }
+  } catch (err) {
+    showError(err);
+  }
+
+  try {
+    renderNextSong(await api("GET", "/spotify/next_wakeup_song"));
+  } catch (err) {
+    if (err.status !== 404) {
+      showError(err);
+    }
+    renderNextSong(null);
+  }
+}
+
+async function savePlaylist() {
+  const id = $("#playlist").value;
+  if (!id) {
+    return;
+  }
+
+  try {
+    await api("PUT", "/spotify/default_playlist", { id });
+    showError(null);
+  } catch (err) {
+    showError(err);
+  }
+}
+
+async function search(event) {
+  event.preventDefault();
+  const q = event.target.q.value.trim();
+
+  let results;
+  try {
+    results = await api("GET", `/spotify/search?q=${encodeURIComponent(q)}`);
+  } catch (err) {
+    showError(err);
+    return;
+  }
+
+  const list = $("#results");
+  list.replaceChildren();
+  for (const track of (results.tracks && results.tracks.items) || []) {
+    const item = $("#track-item").content.cloneNode(true);
+    const images = (track.album && track.album.images) || [];
+    const img = $("img", item);
+    if (images.length > 0) {
+      img.src = images[images.length - 1].url;
+    } else {
+      img.remove();
+    }
+    $(".name", item).textContent = track.name;
+    $(".artist", item).textContent = artists(track);
+    $(".pick", item).addEventListener("click", () => pickSong(track));
+    list.appendChild(item);
+  }
+}
+
+async function pickSong(track) {
+  try {
+    await api("PUT", "/spotify/next_wakeup_song", track);
+  } catch (err) {
+    showError(err);
+    return;
+  }
+
+  showError(null);
+  renderNextSong(track);
+  $("#results").replaceChildren();
+  $("#search").reset();
+}
+
+async function loadHistory() {
+  let runs;
+  try {
+    runs = await api("GET", "/alarms/history");
+  } catch (err) {
+    showError(err);
+    return;
+  }
+
+  const list = $("#run-list");
+  list.replaceChildren();
+  for (const run of runs) {
+    const item = $("#run-item").content.cloneNode(true);
+    $(".label", item).textContent = run.label || "Alarm";
+    $(".when", item).textContent = formatDate(run.started);
+    $(".track-name", item).textContent = run.track;
+    $(".run-error", item).textContent = run.error;
+    const badge = $(".badge", item);
+    badge.textContent = run.outcome;
+    badge.classList.add(run.outcome);
+    list.appendChild(item);
+  }
+  $("#history-empty").hidden = runs.length > 0;
+}
+
+const STEPS = {
+  setting_volume: "Turning it up",
+  getting_song: "Picking a song",
+  playing_song: "Playing the song",
+  waiting_for_briefing: "Getting the briefing ready",
+  speaking_briefing: "Reading the briefing",
+};
+
+function showRinging(title, step) {
+  $("#ringing").hidden = false;
+  $("#ringing-title").textContent = title;
+  $("#ringing-step").textContent = step || "";
+}
+
+function hideRinging() {
+  $("#ringing").hidden = true;
+}
+
+let source = null;
+
+function listen() {
+  if (source) {
+    source.close();
+  }
+  // EventSource can't send headers, so the token goes in the url
+  source = new EventSource(token() ? `/events?access_token=${encodeURIComponent(token())}` : "/events");
+  const on = (type, handler) => source.addEventListener(type, (e) => handler(JSON.parse(e.data)));
+
+  for (const type of ["scheduled", "updated", "deleted"]) {
+    on(type, () => loadAlarms());
+  }
+
+  on("firing", (e) => showRinging(e.label || "Wake up!"));
+  on("step", (e) => showRinging($("#ringing-title").textContent || e.label || "Wake up!", STEPS[e.data.step] || e.data.step));
+  on("track", (e) => ($("#ringing-step").textContent = `${e.data.name} by ${e.data.artist}`));
+  on("snoozed", (e) => {
+    showRinging("Snoozed", `Until ${formatDate(e.data.until)}`);
+    setTimeout(hideRinging, 5000);
+  });
+  for (const type of ["finished", "dismissed", "failed"]) {
+    on(type, () => {
+      hideRinging();
+      loadAlarms();
+      if (!$("#history").hidden) {
+        loadHistory();
+      }
+    });
+  }
+}
+
+async function stopAlarm(action) {
+  try {
+    await api("POST", `/alarms/${action}`);
+    showError(null);
+  } catch (err) {
+    showError(err);
+  }
+}
+
+function init() {
+  const days = $("#days");
+  for (const day of DAYS) {
+    const label = document.createElement("label");
+    label.className = "check";
+    label.innerHTML = `<input type="checkbox" name="${day}"> ${day[0].toUpperCase()}${day.slice(1, 3)}`;
+    days.appendChild(label);
+  }
+
+  document.querySelectorAll(".tab").forEach((tab) => tab.addEventListener("click", () => showTab(tab.dataset.tab)));
+  $("#new-alarm").addEventListener("click", () => openForm(null));
+  $("#cancel-alarm").addEventListener("click", closeForm);
+  $("#alarm-form").addEventListener("submit", saveAlarm);
+  $("#alarm-form").repeat.addEventListener("change", (e) => ($("#days").hidden = !e.target.checked));
+  $("#save-playlist").addEventListener("click", savePlaylist);
+  $("#search").addEventListener("submit", search);
+  $("#snooze").addEventListener("click", () => stopAlarm("snooze"));
+  $("#dismiss").addEventListener("click", () => stopAlarm("dismiss"));
+
+  loadAlarms();
+  listen();
+}
+
+init();
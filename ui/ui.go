@@ -0,0 +1,48 @@
+// Package ui serves the web app for managing gowaker from a phone. It is
+// static and talks to the JSON API like any other client.
+package ui
+
+import (
+	"embed"
+	"io/fs"
+	"mime"
+	"path"
+	"strings"
+
+	"github.com/valyala/fasthttp"
+)
+
+//go:embed static
+var static embed.FS
+
+// Prefix is where the app is served from
+const Prefix = "/ui/"
+
+// HandlerRedirect sends / to the app
+func HandlerRedirect(ctx *fasthttp.RequestCtx) {
+	ctx.Redirect(Prefix, fasthttp.StatusFound)
+}
+
+// HandlerStatic serves a file of the app, from the filepath route param
+func HandlerStatic(ctx *fasthttp.RequestCtx) {
+	name := strings.TrimPrefix(ctx.UserValue("filepath").(string), "/")
+	if name == "" {
+		name = "index.html"
+	}
+
+	contents, err := fs.ReadFile(static, path.Join("static", path.Clean("/"+name)))
+	if err != nil {
+		ctx.Error("not found", fasthttp.StatusNotFound)
+		return
+	}
+
+	contentType := mime.TypeByExtension(path.Ext(name))
+	if contentType == "" {
+		contentType = "application/octet-stream"
+	}
+
+	// the app changes with the binary, so make browsers check for a new one
+	ctx.Response.Header.Set("Cache-Control", "no-cache")
+	ctx.SetContentType(contentType)
+	ctx.SetBody(contents)
+}
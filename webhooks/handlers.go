@@ -0,0 +1,170 @@
+package webhooks
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	log "github.com/golang/glog"
+	"github.com/google/uuid"
+	"github.com/valyala/fasthttp"
+
+	"github.com/jchorl/gowaker/requestcontext"
+)
+
+// maskedSecret stands in for secrets when webhooks are read back
+const maskedSecret = "********"
+
+func mask(w Webhook) Webhook {
+	if w.Secret != "" {
+		w.Secret = maskedSecret
+	}
+	return w
+}
+
+// HandlerGet lists the webhooks
+func HandlerGet(ctx *fasthttp.RequestCtx) {
+	hooks, err := allWebhooks(ctx, requestcontext.DB(ctx))
+	if err != nil {
+		err = fmt.Errorf("getting webhooks: %w", err)
+		log.Error(err)
+		ctx.Error(err.Error(), fasthttp.StatusInternalServerError)
+		return
+	}
+
+	for i := range hooks {
+		hooks[i] = mask(hooks[i])
+	}
+
+	ctx.Response.SetStatusCode(fasthttp.StatusOK)
+	json.NewEncoder(ctx).Encode(hooks)
+}
+
+// HandlerPost registers a webhook
+func HandlerPost(ctx *fasthttp.RequestCtx) {
+	w := Webhook{}
+	err := json.Unmarshal(ctx.Request.Body(), &w)
+	if err != nil {
+		err = fmt.Errorf("decoding body: %w", err)
+		log.Error(err)
+		ctx.Error(err.Error(), fasthttp.StatusBadRequest)
+		return
+	}
+
+	err = w.validate()
+	if err != nil {
+		log.Error(err)
+		ctx.Error(err.Error(), fasthttp.StatusBadRequest)
+		return
+	}
+
+	w.ID = uuid.New().String()
+	w.Created = time.Now()
+
+	err = createWebhook(ctx, requestcontext.DB(ctx), w)
+	if err != nil {
+		err = fmt.Errorf("saving webhook: %w", err)
+		log.Error(err)
+		ctx.Error(err.Error(), fasthttp.StatusInternalServerError)
+		return
+	}
+
+	ctx.Response.SetStatusCode(fasthttp.StatusOK)
+	json.NewEncoder(ctx).Encode(mask(w))
+}
+
+// HandlerDelete deletes a webhook along with its deliveries
+func HandlerDelete(ctx *fasthttp.RequestCtx) {
+	id := ctx.UserValue("id").(string)
+
+	err := deleteWebhook(ctx, requestcontext.DB(ctx), id)
+	if errors.Is(err, sql.ErrNoRows) {
+		ctx.Error(fmt.Sprintf("webhook %s not found", id), fasthttp.StatusNotFound)
+		return
+	}
+	if err != nil {
+		err = fmt.Errorf("deleting webhook: %w", err)
+		log.Error(err)
+		ctx.Error(err.Error(), fasthttp.StatusInternalServerError)
+		return
+	}
+}
+
+// HandlerGetDeliveries lists a webhook's deliveries, newest first. The status
+// query param filters them, e.g. ?status=failed.
+func HandlerGetDeliveries(ctx *fasthttp.RequestCtx) {
+	db := requestcontext.DB(ctx)
+	id := ctx.UserValue("id").(string)
+
+	_, err := getWebhook(ctx, db, id)
+	if errors.Is(err, sql.ErrNoRows) {
+		ctx.Error(fmt.Sprintf("webhook %s not found", id), fasthttp.StatusNotFound)
+		return
+	}
+	if err != nil {
+		err = fmt.Errorf("getting webhook: %w", err)
+		log.Error(err)
+		ctx.Error(err.Error(), fasthttp.StatusInternalServerError)
+		return
+	}
+
+	where := "where webhook_id = ?"
+	args := []interface{}{id}
+	if status := string(ctx.QueryArgs().Peek("status")); status != "" {
+		if status != StatusPending && status != StatusSucceeded && status != StatusFailed {
+			ctx.Error(fmt.Sprintf("unknown status %q", status), fasthttp.StatusBadRequest)
+			return
+		}
+		where += " and status = ?"
+		args = append(args, status)
+	}
+
+	deliveries, err := queryDeliveries(ctx, db, where+" order by created_at desc", args...)
+	if err != nil {
+		err = fmt.Errorf("getting deliveries: %w", err)
+		log.Error(err)
+		ctx.Error(err.Error(), fasthttp.StatusInternalServerError)
+		return
+	}
+
+	ctx.Response.SetStatusCode(fasthttp.StatusOK)
+	json.NewEncoder(ctx).Encode(deliveries)
+}
+
+// HandlerRedeliver sends a delivery again right away, once, and returns how it
+// went. Pending deliveries are already being retried, so they can't be redelivered.
+func HandlerRedeliver(ctx *fasthttp.RequestCtx) {
+	db := requestcontext.DB(ctx)
+	webhookID := ctx.UserValue("id").(string)
+	id := ctx.UserValue("delivery").(string)
+
+	d, err := getDelivery(ctx, db, webhookID, id)
+	if errors.Is(err, sql.ErrNoRows) {
+		ctx.Error(fmt.Sprintf("delivery %s not found", id), fasthttp.StatusNotFound)
+		return
+	}
+	if err != nil {
+		err = fmt.Errorf("getting delivery: %w", err)
+		log.Error(err)
+		ctx.Error(err.Error(), fasthttp.StatusInternalServerError)
+		return
+	}
+
+	if d.Status == StatusPending {
+		ctx.Error(fmt.Sprintf("delivery %s is still being retried", id), fasthttp.StatusConflict)
+		return
+	}
+
+	d, err = attempt(ctx, db, d, true)
+	if err != nil {
+		err = fmt.Errorf("redelivering: %w", err)
+		log.Error(err)
+		ctx.Error(err.Error(), fasthttp.StatusInternalServerError)
+		return
+	}
+
+	ctx.Response.SetStatusCode(fasthttp.StatusOK)
+	json.NewEncoder(ctx).Encode(d)
+}
@@ -0,0 +1,451 @@
+// Package webhooks POSTs alarm events to URLs that users register, signing
+// them with HMAC and retrying failed deliveries with backoff
+package webhooks
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	log "github.com/golang/glog"
+	"github.com/google/uuid"
+	"github.com/valyala/fasthttp"
+
+	"github.com/jchorl/gowaker/events"
+	"github.com/jchorl/gowaker/requestcontext"
+)
+
+const (
+	// MaxAttempts is how many times a delivery is tried before it is marked failed
+	MaxAttempts = 5
+
+	deliveryTimeout = 10 * time.Second
+
+	// maxResponseBytes caps how much of a response body is kept for inspection
+	maxResponseBytes = 4 << 10
+)
+
+// Headers sent with each delivery
+const (
+	EventHeader     = "X-Gowaker-Event"
+	DeliveryHeader  = "X-Gowaker-Delivery"
+	SignatureHeader = "X-Gowaker-Signature"
+)
+
+// Statuses of a delivery
+const (
+	StatusPending   = "pending"
+	StatusSucceeded = "succeeded"
+	StatusFailed    = "failed"
+)
+
+var httpClient = &http.Client{Timeout: deliveryTimeout}
+
+// firstBackoff is the wait before the first retry, doubling after each attempt
+var firstBackoff = 30 * time.Second
+
+// Webhook is a URL that events are POSTed to
+type Webhook struct {
+	ID  string `json:"id"`
+	URL string `json:"url"`
+
+	// Secret signs deliveries: SignatureHeader is sha256= and the hex HMAC-SHA256
+	// of the body. Empty leaves deliveries unsigned.
+	Secret string `json:"secret,omitempty"`
+
+	// Events limits which types of events are sent. Empty sends
+	// events.Lifecycle.
+	Events  []events.Type `json:"events"`
+	Created time.Time     `json:"created"`
+}
+
+func (w Webhook) validate() error {
+	u, err := url.Parse(w.URL)
+	if err != nil {
+		return fmt.Errorf("parsing url: %w", err)
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return errors.New("url must be http(s)")
+	}
+	if u.Host == "" {
+		return errors.New("url must have a host")
+	}
+
+	for _, t := range w.Events {
+		if !events.Known(t) {
+			return fmt.Errorf("unknown event %q", t)
+		}
+	}
+
+	return nil
+}
+
+func (w Webhook) wants(t events.Type) bool {
+	wanted := w.Events
+	if len(wanted) == 0 {
+		wanted = events.Lifecycle
+	}
+
+	for _, e := range wanted {
+		if e == t {
+			return true
+		}
+	}
+	return false
+}
+
+// Delivery is an event sent, or being sent, to a webhook
+type Delivery struct {
+	ID        string          `json:"id"`
+	WebhookID string          `json:"webhook_id"`
+	Event     events.Type     `json:"event"`
+	Payload   json.RawMessage `json:"payload"`
+	Status    string          `json:"status"`
+	Attempts  int             `json:"attempts"`
+
+	// ResponseStatus and Response are from the last attempt, 0 and empty if
+	// the request didn't get a response
+	ResponseStatus int    `json:"response_status"`
+	Response       string `json:"response"`
+	Error          string `json:"error"`
+
+	Created time.Time `json:"created"`
+	Updated time.Time `json:"updated"`
+}
+
+// Sign returns the signature of a body for SignatureHeader
+func Sign(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// Start delivers events from the bus on ctx to the registered webhooks until
+// the process exits. Deliveries that were pending when gowaker last stopped
+// are picked back up.
+func Start(ctx *fasthttp.RequestCtx) error {
+	db := requestcontext.DB(ctx)
+	// ctx isn't from a server, so it can't be used as a context.Context
+	bg := context.Background()
+
+	pending, err := queryDeliveries(bg, db, "where status = ? order by created_at", StatusPending)
+	if err != nil {
+		return fmt.Errorf("getting pending deliveries: %w", err)
+	}
+	for _, d := range pending {
+		go retry(bg, db, d)
+	}
+
+	// queued, since the deliveries are written to the db and dropping events
+	// while that's slow would lose them for good
+	ch, _ := requestcontext.Events(ctx).SubscribeQueued()
+	go func() {
+		for e := range ch {
+			err := dispatch(bg, db, e)
+			if err != nil {
+				log.Errorf("dispatching %s event to webhooks: %s", e.Type, err)
+			}
+		}
+	}()
+
+	return nil
+}
+
+// dispatch records a delivery of the event for every webhook that wants it,
+// and starts sending them
+func dispatch(ctx context.Context, db *sql.DB, e events.Event) error {
+	hooks, err := allWebhooks(ctx, db)
+	if err != nil {
+		return err
+	}
+
+	payload, err := json.Marshal(e)
+	if err != nil {
+		return fmt.Errorf("encoding event: %w", err)
+	}
+
+	for _, w := range hooks {
+		if !w.wants(e.Type) {
+			continue
+		}
+
+		now := time.Now()
+		d := Delivery{
+			ID:        uuid.New().String(),
+			WebhookID: w.ID,
+			Event:     e.Type,
+			Payload:   payload,
+			Status:    StatusPending,
+			Created:   now,
+			Updated:   now,
+		}
+		err = saveDelivery(ctx, db, d)
+		if err != nil {
+			return fmt.Errorf("saving delivery to %s: %w", w.URL, err)
+		}
+
+		go retry(ctx, db, d)
+	}
+
+	return nil
+}
+
+// retry attempts a delivery until it succeeds or runs out of attempts
+func retry(ctx context.Context, db *sql.DB, d Delivery) {
+	for d.Status == StatusPending {
+		if d.Attempts > 0 {
+			time.Sleep(firstBackoff << uint(d.Attempts-1))
+		}
+
+		var err error
+		d, err = attempt(ctx, db, d, false)
+		if err != nil {
+			log.Errorf("delivering %s: %s", d.ID, err)
+			return
+		}
+
+		if d.Status == StatusPending {
+			log.Errorf("delivery %s failed on attempt %d, retrying: %s", d.ID, d.Attempts, d.Error)
+		}
+	}
+
+	if d.Status == StatusFailed {
+		log.Errorf("delivery %s failed after %d attempts: %s", d.ID, d.Attempts, d.Error)
+	}
+}
+
+// attempt sends a delivery once and saves the outcome. Unless it is final, a
+// failed delivery stays pending while it has attempts left. It only returns an
+// error if the outcome couldn't be saved or the webhook is gone.
+func attempt(ctx context.Context, db *sql.DB, d Delivery, final bool) (Delivery, error) {
+	w, err := getWebhook(ctx, db, d.WebhookID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return d, fmt.Errorf("webhook %s was deleted", d.WebhookID)
+	}
+	if err != nil {
+		return d, fmt.Errorf("getting webhook: %w", err)
+	}
+
+	d.Attempts++
+	d.Updated = time.Now()
+	d.ResponseStatus, d.Response, err = send(ctx, w, d)
+	switch {
+	case err == nil:
+		d.Status = StatusSucceeded
+		d.Error = ""
+	case final || d.Attempts >= MaxAttempts:
+		d.Status = StatusFailed
+		d.Error = err.Error()
+	default:
+		d.Status = StatusPending
+		d.Error = err.Error()
+	}
+
+	err = saveDelivery(ctx, db, d)
+	if err != nil {
+		return d, fmt.Errorf("saving delivery: %w", err)
+	}
+
+	return d, nil
+}
+
+// send POSTs the payload, returning an error unless the response is a 2xx
+func send(ctx context.Context, w Webhook, d Delivery) (int, string, error) {
+	ctx, cancel := context.WithTimeout(ctx, deliveryTimeout)
+	defer cancel()
+
+	req, err := http.NewRequest(http.MethodPost, w.URL, bytes.NewReader(d.Payload))
+	if err != nil {
+		return 0, "", fmt.Errorf("creating request: %w", err)
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "gowaker")
+	req.Header.Set(EventHeader, string(d.Event))
+	req.Header.Set(DeliveryHeader, d.ID)
+	if w.Secret != "" {
+		req.Header.Set(SignatureHeader, Sign(w.Secret, d.Payload))
+	}
+
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return 0, "", fmt.Errorf("posting to %s: %w", w.URL, err)
+	}
+	defer resp.Body.Close()
+
+	body, err := ioutil.ReadAll(io.LimitReader(resp.Body, maxResponseBytes))
+	if err != nil {
+		return resp.StatusCode, "", fmt.Errorf("reading response: %w", err)
+	}
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return resp.StatusCode, string(body), fmt.Errorf("unexpected status %d", resp.StatusCode)
+	}
+
+	return resp.StatusCode, string(body), nil
+}
+
+func scanWebhook(row interface{ Scan(...interface{}) error }) (Webhook, error) {
+	var w Webhook
+	var evts string
+	var created int64
+	err := row.Scan(&w.ID, &w.URL, &w.Secret, &evts, &created)
+	if err != nil {
+		return Webhook{}, err
+	}
+
+	w.Events = []events.Type{}
+	if evts != "" {
+		for _, e := range strings.Split(evts, ",") {
+			w.Events = append(w.Events, events.Type(e))
+		}
+	}
+	w.Created = time.Unix(created, 0)
+
+	return w, nil
+}
+
+const webhookColumns = "id, url, secret, events, created_at"
+
+func allWebhooks(ctx context.Context, db *sql.DB) ([]Webhook, error) {
+	rows, err := db.QueryContext(ctx, "select "+webhookColumns+" from webhooks order by created_at")
+	if err != nil {
+		return nil, fmt.Errorf("querying webhooks: %w", err)
+	}
+	defer rows.Close()
+
+	hooks := []Webhook{}
+	for rows.Next() {
+		w, err := scanWebhook(rows)
+		if err != nil {
+			return nil, fmt.Errorf("scanning row: %w", err)
+		}
+		hooks = append(hooks, w)
+	}
+	err = rows.Err()
+	if err != nil {
+		return nil, fmt.Errorf("iterating over webhook query results: %w", err)
+	}
+
+	return hooks, nil
+}
+
+func getWebhook(ctx context.Context, db *sql.DB, id string) (Webhook, error) {
+	row := db.QueryRowContext(ctx, "select "+webhookColumns+" from webhooks where id = ?", id)
+	return scanWebhook(row)
+}
+
+func createWebhook(ctx context.Context, db *sql.DB, w Webhook) error {
+	evts := make([]string, 0, len(w.Events))
+	for _, e := range w.Events {
+		evts = append(evts, string(e))
+	}
+
+	_, err := db.ExecContext(ctx,
+		"insert into webhooks("+webhookColumns+") values(?, ?, ?, ?, ?)",
+		w.ID, w.URL, w.Secret, strings.Join(evts, ","), w.Created.Unix(),
+	)
+	if err != nil {
+		return fmt.Errorf("inserting webhook: %w", err)
+	}
+
+	return nil
+}
+
+// deleteWebhook deletes a webhook and its deliveries, returning
+// sql.ErrNoRows if it doesn't exist
+func deleteWebhook(ctx context.Context, db *sql.DB, id string) error {
+	res, err := db.ExecContext(ctx, "delete from webhooks where id = ?", id)
+	if err != nil {
+		return fmt.Errorf("deleting webhook: %w", err)
+	}
+
+	n, err := res.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("counting deleted webhooks: %w", err)
+	}
+	if n == 0 {
+		return sql.ErrNoRows
+	}
+
+	_, err = db.ExecContext(ctx, "delete from webhook_deliveries where webhook_id = ?", id)
+	if err != nil {
+		return fmt.Errorf("deleting deliveries: %w", err)
+	}
+
+	return nil
+}
+
+func scanDelivery(row interface{ Scan(...interface{}) error }) (Delivery, error) {
+	var d Delivery
+	var event, payload string
+	var created, updated int64
+	err := row.Scan(&d.ID, &d.WebhookID, &event, &payload, &d.Status, &d.Attempts, &d.ResponseStatus, &d.Response, &d.Error, &created, &updated)
+	if err != nil {
+		return Delivery{}, err
+	}
+
+	d.Event = events.Type(event)
+	d.Payload = json.RawMessage(payload)
+	d.Created = time.Unix(created, 0)
+	d.Updated = time.Unix(updated, 0)
+
+	return d, nil
+}
+
+const deliveryColumns = "id, webhook_id, event, payload, status, attempts, response_status, response, error, created_at, updated_at"
+
+func queryDeliveries(ctx context.Context, db *sql.DB, where string, args ...interface{}) ([]Delivery, error) {
+	rows, err := db.QueryContext(ctx, "select "+deliveryColumns+" from webhook_deliveries "+where, args...)
+	if err != nil {
+		return nil, fmt.Errorf("querying deliveries: %w", err)
+	}
+	defer rows.Close()
+
+	deliveries := []Delivery{}
+	for rows.Next() {
+		d, err := scanDelivery(rows)
+		if err != nil {
+			return nil, fmt.Errorf("scanning row: %w", err)
+		}
+		deliveries = append(deliveries, d)
+	}
+	err = rows.Err()
+	if err != nil {
+		return nil, fmt.Errorf("iterating over delivery query results: %w", err)
+	}
+
+	return deliveries, nil
+}
+
+func getDelivery(ctx context.Context, db *sql.DB, webhookID, id string) (Delivery, error) {
+	row := db.QueryRowContext(ctx, "select "+deliveryColumns+" from webhook_deliveries where webhook_id = ? and id = ?", webhookID, id)
+	return scanDelivery(row)
+}
+
+func saveDelivery(ctx context.Context, db *sql.DB, d Delivery) error {
+	_, err := db.ExecContext(ctx, `
+		insert into webhook_deliveries(`+deliveryColumns+`) values(?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+		on conflict(id) do update set status = excluded.status, attempts = excluded.attempts, response_status = excluded.response_status,
+			response = excluded.response, error = excluded.error, updated_at = excluded.updated_at
+	`,
+		d.ID, d.WebhookID, string(d.Event), string(d.Payload), d.Status, d.Attempts, d.ResponseStatus, d.Response, d.Error, d.Created.Unix(), d.Updated.Unix(),
+	)
+	if err != nil {
+		return fmt.Errorf("upserting delivery: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,310 @@
+package webhooks
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	_ "github.com/mattn/go-sqlite3"
+	"github.com/valyala/fasthttp"
+	"github.com/valyala/fasthttp/fasthttputil"
+
+	"github.com/jchorl/gowaker/events"
+	"github.com/jchorl/gowaker/requestcontext"
+)
+
+func newTestDB(t *testing.T) *sql.DB {
+	t.Helper()
+
+	db, err := sql.Open("sqlite3", ":memory:")
+	if err != nil {
+		t.Fatalf("opening db: %s", err)
+	}
+	// every connection to :memory: is its own db
+	db.SetMaxOpenConns(1)
+	t.Cleanup(func() { db.Close() })
+
+	_, err = db.Exec(`
+	create table webhooks (
+		id text not null primary key,
+		url text not null,
+		secret text not null default '',
+		events text not null default '',
+		created_at int not null
+	);
+	create table webhook_deliveries (
+		id text not null primary key,
+		webhook_id text not null,
+		event text not null,
+		payload text not null,
+		status text not null,
+		attempts int not null default 0,
+		response_status int not null default 0,
+		response text not null default '',
+		error text not null default '',
+		created_at int not null,
+		updated_at int not null
+	);`)
+	if err != nil {
+		t.Fatalf("creating tables: %s", err)
+	}
+
+	return db
+}
+
+// receiver is a webhook endpoint that answers with status and records what
+// it was sent
+type receiver struct {
+	*httptest.Server
+
+	mu       sync.Mutex
+	status   int
+	requests []*http.Request
+}
+
+func newReceiver(t *testing.T, status int) *receiver {
+	t.Helper()
+
+	r := &receiver{status: status}
+	r.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		r.mu.Lock()
+		r.requests = append(r.requests, req)
+		status := r.status
+		r.mu.Unlock()
+
+		w.WriteHeader(status)
+		fmt.Fprint(w, http.StatusText(status))
+	}))
+	t.Cleanup(r.Close)
+
+	return r
+}
+
+func (r *receiver) received() []*http.Request {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return append([]*http.Request(nil), r.requests...)
+}
+
+func addWebhook(t *testing.T, db *sql.DB, w Webhook) {
+	t.Helper()
+
+	w.Created = time.Now()
+	err := createWebhook(context.Background(), db, w)
+	if err != nil {
+		t.Fatalf("creating webhook: %s", err)
+	}
+}
+
+// waitForDeliveries waits for n deliveries to stop being pending
+func waitForDeliveries(t *testing.T, db *sql.DB, n int) []Delivery {
+	t.Helper()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		deliveries, err := queryDeliveries(context.Background(), db, "where status != ? order by event", StatusPending)
+		if err != nil {
+			t.Fatalf("getting deliveries: %s", err)
+		}
+		if len(deliveries) >= n || time.Now().After(deadline) {
+			return deliveries
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestSign(t *testing.T) {
+	got := Sign("shh", []byte(`{"type":"firing"}`))
+	want := "sha256=fdaed5919856ae00e3acc4bebfbe9de615f2c5697a22bc49bb4cdadd93efc92d"
+	if got != want {
+		t.Errorf("got %s, want %s", got, want)
+	}
+}
+
+func TestWants(t *testing.T) {
+	tests := []struct {
+		name   string
+		events []events.Type
+		event  events.Type
+		want   bool
+	}{
+		{"default lifecycle", nil, events.Firing, true},
+		{"default leaves out progress", nil, events.Track, false},
+		{"listed", []events.Type{events.Track}, events.Track, true},
+		{"not listed", []events.Type{events.Track}, events.Firing, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := (Webhook{Events: tt.events}).wants(tt.event); got != tt.want {
+				t.Errorf("got %t, want %t", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestStart(t *testing.T) {
+	db := newTestDB(t)
+	r := newReceiver(t, http.StatusOK)
+	addWebhook(t, db, Webhook{ID: "lifecycle", URL: r.URL, Secret: "shh"})
+	addWebhook(t, db, Webhook{ID: "tracks", URL: r.URL, Events: []events.Type{events.Track}})
+
+	bus := events.NewBus()
+	ctx := requestcontext.NewInternal()
+	requestcontext.SetDB(ctx, db)
+	requestcontext.SetEvents(ctx, bus)
+	err := Start(ctx)
+	if err != nil {
+		t.Fatalf("starting: %s", err)
+	}
+
+	// more than a lossy subscriber would buffer, so none may be dropped
+	const steps = 100
+	bus.Publish(events.Event{Type: events.Firing, AlarmID: "a"})
+	for i := 0; i < steps; i++ {
+		bus.Publish(events.Event{Type: events.Step, AlarmID: "a"})
+	}
+	bus.Publish(events.Event{Type: events.Track, AlarmID: "a"})
+	bus.Publish(events.Event{Type: events.Finished, AlarmID: "a"})
+
+	deliveries := waitForDeliveries(t, db, 3)
+	if len(deliveries) != 3 {
+		t.Fatalf("got %d deliveries, want 3", len(deliveries))
+	}
+	want := []struct {
+		event   events.Type
+		webhook string
+	}{
+		{events.Finished, "lifecycle"},
+		{events.Firing, "lifecycle"},
+		{events.Track, "tracks"},
+	}
+	for i, w := range want {
+		d := deliveries[i]
+		if d.Event != w.event || d.WebhookID != w.webhook || d.Status != StatusSucceeded || d.Attempts != 1 {
+			t.Errorf("delivery %d: got %+v, want a %s delivery to %s", i, d, w.event, w.webhook)
+		}
+	}
+
+	for _, req := range r.received() {
+		signature := req.Header.Get(SignatureHeader)
+		if signed := signature != ""; signed != (req.Header.Get(EventHeader) != string(events.Track)) {
+			t.Errorf("got signature %q on a %s delivery, want only the webhook with a secret signed", signature, req.Header.Get(EventHeader))
+		}
+	}
+}
+
+func TestRetryFails(t *testing.T) {
+	backoff := firstBackoff
+	firstBackoff = time.Millisecond
+	t.Cleanup(func() { firstBackoff = backoff })
+
+	db := newTestDB(t)
+	r := newReceiver(t, http.StatusBadGateway)
+	addWebhook(t, db, Webhook{ID: "w", URL: r.URL})
+
+	now := time.Now()
+	d := Delivery{ID: "d", WebhookID: "w", Event: events.Firing, Payload: json.RawMessage(`{}`), Status: StatusPending, Created: now, Updated: now}
+	err := saveDelivery(context.Background(), db, d)
+	if err != nil {
+		t.Fatalf("saving delivery: %s", err)
+	}
+
+	retry(context.Background(), db, d)
+
+	d, err = getDelivery(context.Background(), db, "w", "d")
+	if err != nil {
+		t.Fatalf("getting delivery: %s", err)
+	}
+	if d.Status != StatusFailed || d.Attempts != MaxAttempts || d.ResponseStatus != http.StatusBadGateway || d.Response != "Bad Gateway" || d.Error == "" {
+		t.Errorf("got delivery %+v, want failed after %d attempts", d, MaxAttempts)
+	}
+	if n := len(r.received()); n != MaxAttempts {
+		t.Errorf("got %d requests, want %d", n, MaxAttempts)
+	}
+}
+
+// serve runs handler behind a real server, which handlers need to use their
+// ctx as a context.Context, and returns the response
+func serve(t *testing.T, db *sql.DB, handler fasthttp.RequestHandler, userValues map[string]string) *fasthttp.Response {
+	t.Helper()
+
+	ln := fasthttputil.NewInmemoryListener()
+	server := &fasthttp.Server{Handler: func(ctx *fasthttp.RequestCtx) {
+		requestcontext.SetDB(ctx, db)
+		for k, v := range userValues {
+			ctx.SetUserValue(k, v)
+		}
+		handler(ctx)
+	}}
+	go server.Serve(ln)
+	t.Cleanup(func() { ln.Close() })
+
+	client := &fasthttp.Client{Dial: func(string) (net.Conn, error) { return ln.Dial() }}
+	req := fasthttp.AcquireRequest()
+	defer fasthttp.ReleaseRequest(req)
+	req.SetRequestURI("http://gowaker/webhooks/w/deliveries/d/redeliver")
+	req.Header.SetMethod(fasthttp.MethodPost)
+
+	resp := &fasthttp.Response{}
+	err := client.Do(req, resp)
+	if err != nil {
+		t.Fatalf("making request: %s", err)
+	}
+	return resp
+}
+
+func TestHandlerRedeliver(t *testing.T) {
+	db := newTestDB(t)
+	r := newReceiver(t, http.StatusOK)
+	addWebhook(t, db, Webhook{ID: "w", URL: r.URL})
+	userValues := map[string]string{"id": "w", "delivery": "d"}
+
+	now := time.Now()
+	d := Delivery{ID: "d", WebhookID: "w", Event: events.Firing, Payload: json.RawMessage(`{}`), Status: StatusPending, Attempts: 1, Created: now, Updated: now}
+	err := saveDelivery(context.Background(), db, d)
+	if err != nil {
+		t.Fatalf("saving delivery: %s", err)
+	}
+
+	resp := serve(t, db, HandlerRedeliver, userValues)
+	if resp.StatusCode() != fasthttp.StatusConflict {
+		t.Errorf("got status %d, want a conflict for a pending delivery", resp.StatusCode())
+	}
+	if n := len(r.received()); n != 0 {
+		t.Errorf("got %d requests, want a pending delivery left to its retries", n)
+	}
+
+	d.Status = StatusFailed
+	err = saveDelivery(context.Background(), db, d)
+	if err != nil {
+		t.Fatalf("saving delivery: %s", err)
+	}
+
+	resp = serve(t, db, HandlerRedeliver, userValues)
+	if resp.StatusCode() != fasthttp.StatusOK {
+		t.Fatalf("got status %d: %s", resp.StatusCode(), resp.Body())
+	}
+	got := Delivery{}
+	err = json.Unmarshal(resp.Body(), &got)
+	if err != nil {
+		t.Fatalf("decoding response: %s", err)
+	}
+	if got.Status != StatusSucceeded || got.Attempts != 2 {
+		t.Errorf("got delivery %+v, want it succeeded on its second attempt", got)
+	}
+
+	userValues["delivery"] = "missing"
+	resp = serve(t, db, HandlerRedeliver, userValues)
+	if resp.StatusCode() != fasthttp.StatusNotFound {
+		t.Errorf("got status %d, want not found", resp.StatusCode())
+	}
+}